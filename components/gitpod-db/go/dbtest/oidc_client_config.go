@@ -6,6 +6,8 @@ package dbtest
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"testing"
 	"time"
 
@@ -19,7 +21,9 @@ func NewOIDCClientConfig(t *testing.T, record db.OIDCClientConfig) db.OIDCClient
 	t.Helper()
 
 	cipher, _ := GetTestCipher(t)
-	encrypted, err := db.EncryptJSON(cipher, db.OIDCSpec{})
+	// UsePKCE is set, CreateOIDCClientConfig stores the config as it is
+	usePKCE := true
+	encrypted, err := db.EncryptJSON(cipher, db.OIDCSpec{UsePKCE: &usePKCE})
 	require.NoError(t, err)
 
 	now := time.Now().UTC().Truncate(time.Millisecond)
@@ -27,6 +31,7 @@ func NewOIDCClientConfig(t *testing.T, record db.OIDCClientConfig) db.OIDCClient
 		ID:           uuid.New(),
 		Issuer:       "issuer",
 		Data:         encrypted,
+		CreatedAt:    now,
 		LastModified: now,
 	}
 
@@ -34,6 +39,10 @@ func NewOIDCClientConfig(t *testing.T, record db.OIDCClientConfig) db.OIDCClient
 		result.ID = record.ID
 	}
 
+	if record.PublicID != "" {
+		result.PublicID = record.PublicID
+	}
+
 	if record.OrganizationID != uuid.Nil {
 		result.OrganizationID = record.OrganizationID
 	}
@@ -46,6 +55,19 @@ func NewOIDCClientConfig(t *testing.T, record db.OIDCClientConfig) db.OIDCClient
 		result.Data = record.Data
 	}
 
+	if !record.CreatedAt.IsZero() {
+		result.CreatedAt = record.CreatedAt
+	}
+
+	if !record.LastModified.IsZero() {
+		result.LastModified = record.LastModified
+	}
+
+	result.Active = record.Active
+	result.VerifiedAt = record.VerifiedAt
+	result.DeleteScheduledAt = record.DeleteScheduledAt
+	result.FirstActivatedAt = record.FirstActivatedAt
+
 	return result
 }
 
@@ -56,11 +78,44 @@ func CreateOIDCClientConfigs(t *testing.T, conn *gorm.DB, entries ...db.OIDCClie
 	var ids []string
 	for _, entry := range entries {
 		record := NewOIDCClientConfig(t, entry)
-		records = append(records, record)
 		ids = append(ids, record.ID.String())
 
-		_, err := db.CreateOIDCClientConfig(context.Background(), conn, record)
+		created, err := db.CreateOIDCClientConfig(context.Background(), conn, CipherSet(t), record, 0)
 		require.NoError(t, err)
+		records = append(records, created)
+	}
+
+	t.Cleanup(func() {
+		HardDeleteOIDCClientConfigs(t, ids...)
+	})
+
+	return records
+}
+
+// CreateUndecryptableOIDCClientConfigs inserts the configs as they are, bypassing the checks of
+// db.CreateOIDCClientConfig, which rejects a spec it cannot decrypt. Use it only for configs stored with such a spec.
+func CreateUndecryptableOIDCClientConfigs(t *testing.T, conn *gorm.DB, entries ...db.OIDCClientConfig) []db.OIDCClientConfig {
+	t.Helper()
+
+	var records []db.OIDCClientConfig
+	var ids []string
+	for _, entry := range entries {
+		record := NewOIDCClientConfig(t, entry)
+		ids = append(ids, record.ID.String())
+
+		if record.PublicID == "" {
+			b := make([]byte, 12)
+			_, err := rand.Read(b)
+			require.NoError(t, err)
+			record.PublicID = base64.RawURLEncoding.EncodeToString(b)
+		}
+		if record.Active && record.FirstActivatedAt == nil {
+			firstActivatedAt := record.CreatedAt
+			record.FirstActivatedAt = &firstActivatedAt
+		}
+
+		require.NoError(t, conn.Create(&record).Error)
+		records = append(records, record)
 	}
 
 	t.Cleanup(func() {
@@ -0,0 +1,19 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package dbtest
+
+import (
+	"testing"
+
+	db "github.com/gitpod-io/gitpod/components/gitpod-db/go"
+	"github.com/stretchr/testify/require"
+)
+
+// HardDeleteOIDCClientConfigAudits deletes the audit records of the configs with the IDs.
+func HardDeleteOIDCClientConfigAudits(t *testing.T, oidcClientConfigIDs ...string) {
+	if len(oidcClientConfigIDs) > 0 {
+		require.NoError(t, conn.Where("oidcClientConfigId IN ?", oidcClientConfigIDs).Delete(&db.OIDCClientConfigAudit{}).Error)
+	}
+}
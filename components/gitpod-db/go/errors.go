@@ -4,8 +4,68 @@
 
 package db
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var (
 	ErrorNotFound = errors.New("not found")
+
+	ErrDataDecryption = errors.New("failed to decrypt data")
+
+	ErrRedirectURLConflict = errors.New("redirect url is already used by another oidc client config")
+
+	ErrOIDCClientConfigNotVerified = errors.New("oidc client config has not been verified")
+
+	ErrConfigLimitReached = errors.New("organization has reached the maximum number of oidc client configs")
+
+	// errStopIteration is used to abort batched queries early, it is never returned to callers.
+	errStopIteration = errors.New("stop iteration")
 )
+
+// FieldError describes a problem with a single field of a record.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) String() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Message)
+}
+
+// ValidationError reports all problems found while validating a record, so they can be fixed at once.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		problems = append(problems, f.String())
+	}
+	return strings.Join(problems, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// merge appends the problems of err, if it is a ValidationError, and reports whether it was one.
+func (e *ValidationError) merge(err error) bool {
+	var other *ValidationError
+	if !errors.As(err, &other) {
+		return false
+	}
+	e.Fields = append(e.Fields, other.Fields...)
+	return true
+}
+
+// errOrNil returns the ValidationError if it holds any problems, and nil otherwise.
+func (e *ValidationError) errOrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
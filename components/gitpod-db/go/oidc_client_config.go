@@ -6,17 +6,33 @@ package db
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type OIDCClientConfig struct {
 	ID uuid.UUID `gorm:"primary_key;column:id;type:char;size:36;" json:"id"`
 
+	// PublicID is a short, url-safe handle of the config, which can be exposed instead of the ID. It is generated on
+	// create, configs created before it was introduced have none.
+	PublicID string `gorm:"column:publicId;type:varchar;size:16;" json:"publicId,omitempty"`
+
 	OrganizationID uuid.UUID `gorm:"column:organizationId;type:char;size:36;" json:"organizationId"`
 
 	Issuer string `gorm:"column:issuer;type:char;size:255;" json:"issuer"`
@@ -25,6 +41,19 @@ type OIDCClientConfig struct {
 
 	Active bool `gorm:"column:active;type:tinyint;default:0;" json:"active"`
 
+	// VerifiedAt is set when a test login with the config succeeded, and cleared whenever the spec changes.
+	VerifiedAt *time.Time `gorm:"column:verifiedAt;type:timestamp;" json:"verifiedAt,omitempty"`
+
+	// DeleteScheduledAt is when the config is soft-deleted by ProcessScheduledOIDCDeletions, unless the deletion is
+	// cancelled before.
+	DeleteScheduledAt *time.Time `gorm:"column:deleteScheduledAt;type:timestamp;" json:"deleteScheduledAt,omitempty"`
+
+	// FirstActivatedAt is set when the config becomes active for the first time, and kept when it is deactivated.
+	FirstActivatedAt *time.Time `gorm:"column:firstActivatedAt;type:timestamp;" json:"firstActivatedAt,omitempty"`
+
+	// CreatedAt is set on create. Configs created before it was introduced carry the time of their last modification.
+	CreatedAt time.Time `gorm:"column:createdAt;type:timestamp;default:CURRENT_TIMESTAMP(6);" json:"createdAt"`
+
 	LastModified time.Time `gorm:"column:_lastModified;type:timestamp;default:CURRENT_TIMESTAMP(6);" json:"_lastModified"`
 	// deleted is reserved for use by periodic deleter.
 	_ bool `gorm:"column:deleted;type:tinyint;default:0;" json:"deleted"`
@@ -50,27 +79,620 @@ type OIDCSpec struct {
 
 	// Scope specifies optional requested permissions.
 	Scopes []string `json:"scopes"`
+
+	// SecretExpiresAt is the time at which the ClientSecret expires, if the IdP enforces an expiry.
+	SecretExpiresAt *time.Time `json:"secretExpiresAt,omitempty"`
+
+	// Metadata holds free-form annotations, e.g. references to the source of truth of the config.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Prompt is the optional, space separated, `prompt` parameter of the authentication request, e.g. "login consent".
+	Prompt string `json:"prompt,omitempty"`
+
+	// ResponseType is the `response_type` of the authentication request. Defaults to the authorization code flow.
+	ResponseType string `json:"responseType,omitempty"`
+
+	// UsePKCE protects the authorization code with PKCE, and requires a response type which includes `code`.
+	// Left unset, CreateOIDCClientConfig enables it for response types which support it. Configs stored before decode
+	// as unset, which disables PKCE until EnablePKCEForExistingOIDCClientConfigs runs.
+	UsePKCE *bool `json:"usePKCE,omitempty"`
 }
 
-func CreateOIDCClientConfig(ctx context.Context, conn *gorm.DB, cfg OIDCClientConfig) (OIDCClientConfig, error) {
-	if cfg.ID == uuid.Nil {
-		return OIDCClientConfig{}, errors.New("id must be set")
+const DefaultOIDCResponseType = "code"
+
+var (
+	knownOIDCPromptValues = map[string]bool{
+		"none":           true,
+		"login":          true,
+		"consent":        true,
+		"select_account": true,
+	}
+
+	knownOIDCResponseTypes = map[string]bool{
+		"code":                true,
+		"id_token":            true,
+		"id_token token":      true,
+		"code id_token":       true,
+		"code token":          true,
+		"code id_token token": true,
+	}
+)
+
+// supportsPKCE reports whether the response type issues an authorization code, which PKCE protects.
+func supportsPKCE(responseType string) bool {
+	for _, value := range strings.Fields(responseType) {
+		if value == "code" {
+			return true
+		}
+	}
+	return false
+}
+
+// PKCEEnabled reports whether UsePKCE is set and true.
+func (s OIDCSpec) PKCEEnabled() bool {
+	return s.UsePKCE != nil && *s.UsePKCE
+}
+
+// EffectiveResponseType returns the configured response type, or the authorization code flow if none is set.
+func (s OIDCSpec) EffectiveResponseType() string {
+	if s.ResponseType == "" {
+		return DefaultOIDCResponseType
+	}
+	return s.ResponseType
+}
+
+// Fingerprint returns a stable SHA-256 over the canonical form of the spec, to detect changes without storing the spec.
+// URLs and scopes are normalized, scopes are sorted, and the ClientSecret only contributes through its own hash.
+func (s OIDCSpec) Fingerprint() string {
+	redirectURL, err := NormalizeRedirectURL(s.RedirectURL)
+	if err != nil {
+		redirectURL = strings.TrimSpace(s.RedirectURL)
+	}
+
+	scopes := NormalizeScopes(s.Scopes)
+	sort.Strings(scopes)
+
+	prompt := strings.Fields(s.Prompt)
+	sort.Strings(prompt)
+
+	var secretExpiresAt string
+	if s.SecretExpiresAt != nil {
+		secretExpiresAt = s.SecretExpiresAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	// json.Marshal sorts map keys, which makes the encoding deterministic
+	canonical, err := json.Marshal(struct {
+		ClientID         string            `json:"clientId"`
+		ClientSecretHash string            `json:"clientSecretHash"`
+		RedirectURL      string            `json:"redirectUrl"`
+		Scopes           []string          `json:"scopes"`
+		SecretExpiresAt  string            `json:"secretExpiresAt"`
+		Metadata         map[string]string `json:"metadata"`
+		Prompt           []string          `json:"prompt"`
+		ResponseType     string            `json:"responseType"`
+		UsePKCE          bool              `json:"usePKCE"`
+	}{
+		ClientID:         s.ClientID,
+		ClientSecretHash: clientSecretFingerprint(s.ClientSecret),
+		RedirectURL:      redirectURL,
+		Scopes:           scopes,
+		SecretExpiresAt:  secretExpiresAt,
+		Metadata:         s.Metadata,
+		Prompt:           prompt,
+		ResponseType:     s.EffectiveResponseType(),
+		UsePKCE:          s.PKCEEnabled(),
+	})
+	if err != nil {
+		// marshalling strings, slices and maps of strings does not fail
+		panic(fmt.Sprintf("failed to marshal oidc spec fingerprint: %v", err))
+	}
+
+	fingerprint := sha256.Sum256(canonical)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// clientSecretFingerprint returns the hex encoded SHA-256 of the secret, which identifies it without revealing it.
+func clientSecretFingerprint(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// FieldDiff is a field which differs between two specs. Fields are named by their JSON names, metadata keys as
+// metadata.<key>. Values which are unset are empty.
+type FieldDiff struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// DiffOIDCSpecs returns the fields which differ between the specs a and b, in the order of the fields of OIDCSpec.
+// Unchanged fields are omitted. The ClientSecret is represented by its SHA-256 fingerprint, never in plaintext.
+func DiffOIDCSpecs(a, b OIDCSpec) []FieldDiff {
+	var diffs []FieldDiff
+	add := func(field, oldValue, newValue string) {
+		if oldValue != newValue {
+			diffs = append(diffs, FieldDiff{Field: field, Old: oldValue, New: newValue})
+		}
+	}
+
+	secretFingerprint := func(secret string) string {
+		if secret == "" {
+			return ""
+		}
+		return clientSecretFingerprint(secret)
+	}
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+
+	add("clientId", a.ClientID, b.ClientID)
+	add("clientSecret", secretFingerprint(a.ClientSecret), secretFingerprint(b.ClientSecret))
+	add("redirectUrl", a.RedirectURL, b.RedirectURL)
+	add("scopes", strings.Join(a.Scopes, " "), strings.Join(b.Scopes, " "))
+	add("secretExpiresAt", formatTime(a.SecretExpiresAt), formatTime(b.SecretExpiresAt))
+
+	keys := map[string]bool{}
+	for key := range a.Metadata {
+		keys[key] = true
+	}
+	for key := range b.Metadata {
+		keys[key] = true
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+	for _, key := range sortedKeys {
+		add("metadata."+key, a.Metadata[key], b.Metadata[key])
+	}
+
+	add("prompt", a.Prompt, b.Prompt)
+	add("responseType", a.ResponseType, b.ResponseType)
+	add("usePKCE", strconv.FormatBool(a.PKCEEnabled()), strconv.FormatBool(b.PKCEEnabled()))
+
+	return diffs
+}
+
+const (
+	MaxOIDCSpecMetadataKeys        = 32
+	MaxOIDCSpecMetadataKeyLength   = 128
+	MaxOIDCSpecMetadataValueLength = 1024
+)
+
+// ValidateOIDCSpec checks the spec for problems which must be rejected before it is persisted.
+// All problems are reported at once as a *ValidationError.
+func ValidateOIDCSpec(spec OIDCSpec) error {
+	problems := &ValidationError{}
+
+	if len(spec.Metadata) > MaxOIDCSpecMetadataKeys {
+		problems.add("metadata", "must not have more than %d keys, got %d", MaxOIDCSpecMetadataKeys, len(spec.Metadata))
+	}
+
+	keys := make([]string, 0, len(spec.Metadata))
+	for k := range spec.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := spec.Metadata[k]
+		if k == "" {
+			problems.add("metadata", "keys must not be empty")
+		}
+		if len(k) > MaxOIDCSpecMetadataKeyLength {
+			problems.add("metadata", "key %q must not be longer than %d characters", k, MaxOIDCSpecMetadataKeyLength)
+		}
+		if len(v) > MaxOIDCSpecMetadataValueLength {
+			problems.add(fmt.Sprintf("metadata.%s", k), "must not be longer than %d characters", MaxOIDCSpecMetadataValueLength)
+		}
+	}
+
+	if spec.Prompt != "" {
+		values := strings.Fields(spec.Prompt)
+		var hasNone bool
+		for _, v := range values {
+			if !knownOIDCPromptValues[v] {
+				problems.add("prompt", "has unknown value %q", v)
+			}
+			hasNone = hasNone || v == "none"
+		}
+		if len(values) > 1 && hasNone {
+			problems.add("prompt", "must not combine none with other values")
+		}
+	}
+
+	if spec.ResponseType != "" && !knownOIDCResponseTypes[spec.ResponseType] {
+		problems.add("responseType", "has unknown value %q", spec.ResponseType)
+	}
+
+	if spec.PKCEEnabled() && !supportsPKCE(spec.EffectiveResponseType()) {
+		problems.add("usePKCE", "requires a response type including code, got %q", spec.EffectiveResponseType())
+	}
+
+	return problems.errOrNil()
+}
+
+// NormalizeRedirectURL returns the canonical form of a redirect URL, used to compare URLs for equality.
+// Scheme and host are lower-cased, default ports and trailing slashes are removed.
+func NormalizeRedirectURL(redirectURL string) (string, error) {
+	normalized, err := normalizeURL(redirectURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse redirect url: %w", err)
+	}
+	return normalized, nil
+}
+
+// NormalizeIssuer returns the canonical form of an issuer URL, normalized like NormalizeRedirectURL.
+func NormalizeIssuer(issuer string) (string, error) {
+	normalized, err := normalizeURL(issuer)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse issuer: %w", err)
+	}
+	return normalized, nil
+}
+
+// NormalizeScopes returns the scopes without surrounding whitespace, empty entries and duplicates, keeping their order.
+func NormalizeScopes(scopes []string) []string {
+	if scopes == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(scopes))
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" || seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		normalized = append(normalized, scope)
+	}
+
+	return normalized
+}
+
+func normalizeURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "https" && port == "443") || (u.Scheme == "http" && port == "80") {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	u.Host = host
+	u.Path = strings.TrimRight(u.Path, "/")
+
+	return u.String(), nil
+}
+
+// CheckRedirectURLConflict returns ErrRedirectURLConflict when another non-deleted config of the organization uses the same
+// redirect URL, after normalization. CreateOIDCClientConfig and UpdateOIDCClientConfig run it within their write
+// transactions, other callers pass the ID of the config being changed as excludeID, or uuid.Nil for a new one.
+func CheckRedirectURLConflict(ctx context.Context, conn *gorm.DB, decryptor Decryptor, organizationID, excludeID uuid.UUID, redirectURL string) error {
+	if redirectURL == "" {
+		return nil
+	}
+
+	normalized, err := NormalizeRedirectURL(redirectURL)
+	if err != nil {
+		return err
+	}
+
+	configs, err := ListOIDCClientConfigsForOrganization(ctx, conn, organizationID)
+	if err != nil {
+		return err
+	}
+
+	for _, config := range configs {
+		if config.ID == excludeID {
+			continue
+		}
+
+		spec, err := config.Data.Decrypt(decryptor)
+		if err != nil {
+			return fmt.Errorf("OIDC Client Config with ID %s: %v: %w", config.ID.String(), err, ErrDataDecryption)
+		}
+		if spec.RedirectURL == "" {
+			continue
+		}
+
+		existing, err := NormalizeRedirectURL(spec.RedirectURL)
+		if err != nil {
+			continue
+		}
+		if existing == normalized {
+			return fmt.Errorf("redirect url %s collides with oidc client config %s: %w", redirectURL, config.ID.String(), ErrRedirectURLConflict)
+		}
+	}
+
+	return nil
+}
+
+// EncryptOIDCSpec validates the spec and encrypts it for storage in OIDCClientConfig.Data.
+// Validation problems are returned as a *ValidationError.
+func EncryptOIDCSpec(encryptor Encryptor, spec OIDCSpec) (EncryptedJSON[OIDCSpec], error) {
+	if err := ValidateOIDCSpec(spec); err != nil {
+		return nil, err
+	}
+
+	return EncryptJSON(encryptor, spec)
+}
+
+// The functions below all operate on the *gorm.DB they receive, which may also be a transaction handle
+// obtained through conn.Transaction(...). Callers can therefore compose OIDC Client Config changes with
+// other writes, and have them committed or rolled back together.
+
+// CreateOIDCClientConfig persists the config. Missing required fields and the problems of the spec reported by
+// ValidateOIDCSpec are reported together as a *ValidationError.
+// A PublicID is generated, unless one is set.
+// The cipher reads the redirect URL of the config and of the other configs of the organization, a redirect URL which is
+// already used is rejected with ErrRedirectURLConflict. A spec which leaves UsePKCE unset is stored with PKCE enabled if
+// its response type supports it, and disabled otherwise.
+// With a positive maxPerOrg, ErrConfigLimitReached is returned once the organization has maxPerOrg non-deleted configs.
+// A maxPerOrg of 0 does not limit the configs. Both checks run within the insert transaction, concurrent creates for the
+// same organization are serialized by the lock taken on its configs, a create losing the race fails.
+func CreateOIDCClientConfig(ctx context.Context, conn *gorm.DB, cipher Cipher, cfg OIDCClientConfig, maxPerOrg int) (OIDCClientConfig, error) {
+	if maxPerOrg < 0 {
+		return OIDCClientConfig{}, errors.New("max configs per organization must not be negative")
 	}
 
+	problems := &ValidationError{}
+	if cfg.ID == uuid.Nil {
+		problems.add("id", "must be set")
+	}
 	if cfg.Issuer == "" {
-		return OIDCClientConfig{}, errors.New("issuer must be set")
+		problems.add("issuer", "must be set")
+	}
+	if cfg.PublicID != "" && !validPublicID(cfg.PublicID) {
+		problems.add("publicId", fmt.Sprintf("must consist of at most %d url-safe characters", publicIDLength))
 	}
 
-	tx := conn.
-		WithContext(ctx).
-		Create(&cfg)
-	if tx.Error != nil {
-		return OIDCClientConfig{}, fmt.Errorf("failed to create oidc client config: %w", tx.Error)
+	var spec OIDCSpec
+	if len(cfg.Data) == 0 {
+		problems.add("data", "must be set")
+	} else {
+		decrypted, err := cfg.Data.Decrypt(cipher)
+		if err != nil {
+			return OIDCClientConfig{}, fmt.Errorf("OIDC Client Config with ID %s: %v: %w", cfg.ID.String(), err, ErrDataDecryption)
+		}
+		spec = decrypted
+	}
+	defaultPKCE := spec.UsePKCE == nil
+	spec = withPKCEDefault(spec)
+	if err := ValidateOIDCSpec(spec); err != nil && !problems.merge(err) {
+		return OIDCClientConfig{}, err
+	}
+	if err := problems.errOrNil(); err != nil {
+		return OIDCClientConfig{}, err
+	}
+
+	if cfg.PublicID == "" {
+		publicID, err := generatePublicID()
+		if err != nil {
+			return OIDCClientConfig{}, err
+		}
+		cfg.PublicID = publicID
+	}
+
+	if cfg.CreatedAt.IsZero() {
+		cfg.CreatedAt = time.Now().UTC()
+	}
+	if cfg.Active && cfg.FirstActivatedAt == nil {
+		firstActivatedAt := cfg.CreatedAt
+		cfg.FirstActivatedAt = &firstActivatedAt
+	}
+
+	if defaultPKCE {
+		data, err := EncryptJSON(cipher, spec)
+		if err != nil {
+			return OIDCClientConfig{}, fmt.Errorf("failed to encrypt oidc spec: %w", err)
+		}
+		cfg.Data = data
+	}
+
+	err := conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		count, err := lockOIDCClientConfigsOfOrganization(tx, cfg.OrganizationID)
+		if err != nil {
+			return err
+		}
+		if maxPerOrg > 0 && count >= int64(maxPerOrg) {
+			return fmt.Errorf("organization %s has %d of at most %d oidc client configs: %w", cfg.OrganizationID.String(), count, maxPerOrg, ErrConfigLimitReached)
+		}
+
+		if err := CheckRedirectURLConflict(ctx, tx, cipher, cfg.OrganizationID, uuid.Nil, spec.RedirectURL); err != nil {
+			return err
+		}
+
+		if err := tx.Create(&cfg).Error; err != nil {
+			return fmt.Errorf("failed to create oidc client config: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return OIDCClientConfig{}, err
 	}
 
 	return cfg, nil
 }
 
+// withPKCEDefault sets an unset UsePKCE, enabling PKCE for response types which support it.
+func withPKCEDefault(spec OIDCSpec) OIDCSpec {
+	if spec.UsePKCE == nil {
+		usePKCE := supportsPKCE(spec.EffectiveResponseType())
+		spec.UsePKCE = &usePKCE
+	}
+
+	return spec
+}
+
+// lockOIDCClientConfigsOfOrganization locks the non-deleted configs of the organization for the rest of the transaction,
+// and returns their count.
+func lockOIDCClientConfigsOfOrganization(tx *gorm.DB, organizationID uuid.UUID) (int64, error) {
+	var count int64
+	result := tx.
+		Model(&OIDCClientConfig{}).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("organizationId = ?", organizationID.String()).
+		Where("deleted = ?", 0).
+		Count(&count)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to count oidc client configs of organization %s: %w", organizationID.String(), result.Error)
+	}
+
+	return count, nil
+}
+
+// publicIDLength is the length of generated public IDs, which encode 96 random bits.
+const publicIDLength = 16
+
+func generatePublicID() (string, error) {
+	b := make([]byte, base64.RawURLEncoding.DecodedLen(publicIDLength))
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate public id: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func validPublicID(publicID string) bool {
+	if len(publicID) > publicIDLength {
+		return false
+	}
+	for _, c := range publicID {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CreateOIDCClientConfigWithHook creates the config like CreateOIDCClientConfig, and runs the hook with the created
+// config within the same transaction, e.g. to update settings of the organization which depend on it. The insert is
+// rolled back when the hook returns an error, which is returned unchanged.
+func CreateOIDCClientConfigWithHook(ctx context.Context, conn *gorm.DB, cipher Cipher, cfg OIDCClientConfig, hook func(tx *gorm.DB, created OIDCClientConfig) error) (OIDCClientConfig, error) {
+	if hook == nil {
+		return OIDCClientConfig{}, errors.New("hook is a required argument")
+	}
+
+	var created OIDCClientConfig
+	err := conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		created, err = CreateOIDCClientConfig(ctx, tx, cipher, cfg, 0)
+		if err != nil {
+			return err
+		}
+
+		return hook(tx, created)
+	})
+	if err != nil {
+		return OIDCClientConfig{}, err
+	}
+
+	return created, nil
+}
+
+// CreateOIDCClientConfigAutoID creates an inactive config with a generated ID, and returns the created record.
+// The cipher encrypts the spec, and is used to check the redirect URL against the other configs of the organization.
+// PKCE defaults as for CreateOIDCClientConfig.
+func CreateOIDCClientConfigAutoID(ctx context.Context, conn *gorm.DB, cipher Cipher, organizationID uuid.UUID, issuer string, spec OIDCSpec) (OIDCClientConfig, error) {
+	if organizationID == uuid.Nil {
+		return OIDCClientConfig{}, errors.New("organization ID is a required argument")
+	}
+
+	if err := validateOIDCClientConfigInput(issuer, spec); err != nil {
+		return OIDCClientConfig{}, err
+	}
+
+	data, err := EncryptJSON(cipher, spec)
+	if err != nil {
+		return OIDCClientConfig{}, fmt.Errorf("failed to encrypt oidc spec: %w", err)
+	}
+
+	return CreateOIDCClientConfig(ctx, conn, cipher, OIDCClientConfig{
+		ID:             uuid.New(),
+		OrganizationID: organizationID,
+		Issuer:         issuer,
+		Data:           data,
+		Active:         false,
+		LastModified:   time.Now().UTC(),
+	}, 0)
+}
+
+// UpdateOIDCClientConfig replaces the issuer and spec of a config of the organization, and clears its verification
+// such that the changed config has to be verified again. Validation problems are reported together as a *ValidationError, a redirect URL used by another config of the
+// organization is rejected with ErrRedirectURLConflict. An unset UsePKCE defaults as for CreateOIDCClientConfig.
+func UpdateOIDCClientConfig(ctx context.Context, conn *gorm.DB, cipher Cipher, id, organizationID uuid.UUID, issuer string, spec OIDCSpec) (OIDCClientConfig, error) {
+	if err := validateOIDCClientConfigInput(issuer, spec); err != nil {
+		return OIDCClientConfig{}, err
+	}
+	spec = withPKCEDefault(spec)
+
+	if _, err := GetOIDCClientConfigForOrganization(ctx, conn, id, organizationID); err != nil {
+		return OIDCClientConfig{}, err
+	}
+
+	data, err := EncryptJSON(cipher, spec)
+	if err != nil {
+		return OIDCClientConfig{}, fmt.Errorf("failed to encrypt oidc spec: %w", err)
+	}
+
+	err = conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := lockOIDCClientConfigsOfOrganization(tx, organizationID); err != nil {
+			return err
+		}
+
+		if err := CheckRedirectURLConflict(ctx, tx, cipher, organizationID, id, spec.RedirectURL); err != nil {
+			return err
+		}
+
+		result := tx.
+			Table((&OIDCClientConfig{}).TableName()).
+			Where("id = ?", id.String()).
+			Where("organizationId = ?", organizationID.String()).
+			Where("deleted = ?", 0).
+			Updates(map[string]interface{}{
+				"issuer":        issuer,
+				"data":          data,
+				"verifiedAt":    nil,
+				"_lastModified": time.Now().UTC(),
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to update oidc client config %s: %w", id.String(), result.Error)
+		}
+		return nil
+	})
+	if err != nil {
+		return OIDCClientConfig{}, err
+	}
+
+	return GetOIDCClientConfigForOrganization(ctx, conn, id, organizationID)
+}
+
+// validateOIDCClientConfigInput collects the problems of the issuer and spec into a single *ValidationError.
+func validateOIDCClientConfigInput(issuer string, spec OIDCSpec) error {
+	problems := &ValidationError{}
+	if issuer == "" {
+		problems.add("issuer", "must be set")
+	}
+	if err := ValidateOIDCSpec(spec); err != nil && !problems.merge(err) {
+		return err
+	}
+
+	return problems.errOrNil()
+}
+
 func GetOIDCClientConfig(ctx context.Context, conn *gorm.DB, id uuid.UUID) (OIDCClientConfig, error) {
 	var config OIDCClientConfig
 
@@ -87,7 +709,30 @@ func GetOIDCClientConfig(ctx context.Context, conn *gorm.DB, id uuid.UUID) (OIDC
 		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
 			return OIDCClientConfig{}, fmt.Errorf("OIDC Client Config with ID %s does not exist: %w", id.String(), ErrorNotFound)
 		}
-		return OIDCClientConfig{}, fmt.Errorf("Failed to retrieve OIDC client config: %v", tx.Error)
+		return OIDCClientConfig{}, fmt.Errorf("Failed to retrieve OIDC client config: %w", tx.Error)
+	}
+
+	return config, nil
+}
+
+// GetOIDCClientConfigByPublicID returns the non-deleted config with the public ID, or ErrorNotFound.
+func GetOIDCClientConfigByPublicID(ctx context.Context, conn *gorm.DB, publicID string) (OIDCClientConfig, error) {
+	var config OIDCClientConfig
+
+	if publicID == "" {
+		return OIDCClientConfig{}, errors.New("public ID is a required argument")
+	}
+
+	tx := conn.
+		WithContext(ctx).
+		Where("publicId = ?", publicID).
+		Where("deleted = ?", 0).
+		First(&config)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return OIDCClientConfig{}, fmt.Errorf("OIDC Client Config with public ID %s does not exist: %w", publicID, ErrorNotFound)
+		}
+		return OIDCClientConfig{}, fmt.Errorf("failed to retrieve oidc client config by public id: %w", tx.Error)
 	}
 
 	return config, nil
@@ -115,30 +760,850 @@ func GetOIDCClientConfigForOrganization(ctx context.Context, conn *gorm.DB, id,
 			return OIDCClientConfig{}, fmt.Errorf("OIDC Client Config with ID %s for Organization ID %s does not exist: %w", id.String(), organizationID.String(), ErrorNotFound)
 		}
 
-		return OIDCClientConfig{}, fmt.Errorf("Failed to retrieve OIDC client config %s for Organization ID %s: %v", id.String(), organizationID.String(), tx.Error)
+		return OIDCClientConfig{}, fmt.Errorf("Failed to retrieve OIDC client config %s for Organization ID %s: %w", id.String(), organizationID.String(), tx.Error)
 	}
 
 	return config, nil
 }
 
-func ListOIDCClientConfigsForOrganization(ctx context.Context, conn *gorm.DB, organizationID uuid.UUID) ([]OIDCClientConfig, error) {
-	if organizationID == uuid.Nil {
-		return nil, errors.New("organization ID is a required argument")
+// DecodeSpec decrypts the spec of the config. Decryption failures are reported as ErrDataDecryption.
+// Use an OIDCSpecCache to avoid decrypting the same config repeatedly.
+func DecodeSpec(decryptor Decryptor, config OIDCClientConfig) (OIDCSpec, error) {
+	spec, err := config.Data.Decrypt(decryptor)
+	if err != nil {
+		return OIDCSpec{}, fmt.Errorf("OIDC Client Config with ID %s: %v: %w", config.ID.String(), err, ErrDataDecryption)
 	}
 
-	var results []OIDCClientConfig
+	return spec, nil
+}
 
-	tx := conn.
-		WithContext(ctx).
-		Where("organizationId = ?", organizationID.String()).
-		Where("deleted = ?", 0).
-		Order("id").
-		Find(&results)
-	if tx.Error != nil {
-		return nil, fmt.Errorf("failed to list oidc client configs for organization %s: %w", organizationID.String(), tx.Error)
+// GetDecodedOIDCClientConfigForOrganization retrieves the OIDC Client Config and decrypts its spec in one step.
+// Decryption failures are reported as ErrDataDecryption, to distinguish them from ErrorNotFound.
+func GetDecodedOIDCClientConfigForOrganization(ctx context.Context, conn *gorm.DB, decryptor Decryptor, id, organizationID uuid.UUID) (OIDCClientConfig, OIDCSpec, error) {
+	config, err := GetOIDCClientConfigForOrganization(ctx, conn, id, organizationID)
+	if err != nil {
+		return OIDCClientConfig{}, OIDCSpec{}, err
 	}
 
-	return results, nil
+	spec, err := config.Data.Decrypt(decryptor)
+	if err != nil {
+		return OIDCClientConfig{}, OIDCSpec{}, fmt.Errorf("OIDC Client Config with ID %s: %v: %w", id.String(), err, ErrDataDecryption)
+	}
+
+	return config, spec, nil
+}
+
+// oidcSpecRedirectURL decodes only the RedirectURL of an encrypted OIDCSpec.
+type oidcSpecRedirectURL struct {
+	RedirectURL string `json:"redirectUrl"`
+}
+
+// GetOIDCClientConfigRedirectURL returns the RedirectURL of the config. Only the RedirectURL is decoded from the decrypted
+// spec, the ClientSecret is never unmarshalled.
+func GetOIDCClientConfigRedirectURL(ctx context.Context, conn *gorm.DB, decryptor Decryptor, id uuid.UUID) (string, error) {
+	config, err := GetOIDCClientConfig(ctx, conn, id)
+	if err != nil {
+		return "", err
+	}
+
+	data := EncryptedJSON[oidcSpecRedirectURL](config.Data)
+	spec, err := data.Decrypt(decryptor)
+	if err != nil {
+		return "", fmt.Errorf("OIDC Client Config with ID %s: %v: %w", id.String(), err, ErrDataDecryption)
+	}
+
+	return spec.RedirectURL, nil
+}
+
+func ListOIDCClientConfigsForOrganization(ctx context.Context, conn *gorm.DB, organizationID uuid.UUID) ([]OIDCClientConfig, error) {
+	if organizationID == uuid.Nil {
+		return nil, errors.New("organization ID is a required argument")
+	}
+
+	var results []OIDCClientConfig
+
+	tx := conn.
+		WithContext(ctx).
+		Where("organizationId = ?", organizationID.String()).
+		Where("deleted = ?", 0).
+		Order("id").
+		Find(&results)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to list oidc client configs for organization %s: %w", organizationID.String(), tx.Error)
+	}
+
+	return results, nil
+}
+
+// DecodedOIDCClientConfig is a config together with its decrypted spec.
+type DecodedOIDCClientConfig struct {
+	OIDCClientConfig
+	Spec OIDCSpec
+}
+
+// ListDecodedOIDCClientConfigsForOrganization lists the non-deleted configs of the organization with their decrypted specs.
+// Unless includeSecrets is set, results are secret-free: the ClientSecret of the spec is cleared, and Data, which holds
+// the encrypted spec, is removed. Prefer it over ListOIDCClientConfigsForOrganization for results shared with clients.
+func ListDecodedOIDCClientConfigsForOrganization(ctx context.Context, conn *gorm.DB, decryptor Decryptor, organizationID uuid.UUID, includeSecrets bool) ([]DecodedOIDCClientConfig, error) {
+	configs, err := ListOIDCClientConfigsForOrganization(ctx, conn, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]DecodedOIDCClientConfig, 0, len(configs))
+	for _, config := range configs {
+		spec, err := DecodeSpec(decryptor, config)
+		if err != nil {
+			return nil, err
+		}
+
+		if !includeSecrets {
+			spec.ClientSecret = ""
+			config.Data = nil
+		}
+
+		results = append(results, DecodedOIDCClientConfig{
+			OIDCClientConfig: config,
+			Spec:             spec,
+		})
+	}
+
+	return results, nil
+}
+
+type sanitizedOIDCClientConfig struct {
+	ID                      uuid.UUID `json:"id"`
+	Issuer                  string    `json:"issuer"`
+	ClientID                string    `json:"clientId"`
+	ClientSecretFingerprint string    `json:"clientSecretFingerprint"`
+	RedirectURL             string    `json:"redirectUrl"`
+	Scopes                  []string  `json:"scopes"`
+	Active                  bool      `json:"active"`
+	LastModified            time.Time `json:"lastModified"`
+}
+
+// ExportOIDCClientConfigsSanitized returns the non-deleted configs of the organization as JSON, which is safe to share,
+// e.g. to attach to support tickets. The ClientSecret is replaced by its SHA-256 fingerprint.
+func ExportOIDCClientConfigsSanitized(ctx context.Context, conn *gorm.DB, decryptor Decryptor, organizationID uuid.UUID) ([]byte, error) {
+	configs, err := ListOIDCClientConfigsForOrganization(ctx, conn, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	sanitized := make([]sanitizedOIDCClientConfig, 0, len(configs))
+	for _, config := range configs {
+		spec, err := DecodeSpec(decryptor, config)
+		if err != nil {
+			return nil, err
+		}
+
+		sanitized = append(sanitized, sanitizedOIDCClientConfig{
+			ID:                      config.ID,
+			Issuer:                  config.Issuer,
+			ClientID:                spec.ClientID,
+			ClientSecretFingerprint: clientSecretFingerprint(spec.ClientSecret),
+			RedirectURL:             spec.RedirectURL,
+			Scopes:                  spec.Scopes,
+			Active:                  config.Active,
+			LastModified:            config.LastModified,
+		})
+	}
+
+	exported, err := json.MarshalIndent(sanitized, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sanitized oidc client configs: %w", err)
+	}
+
+	return exported, nil
+}
+
+// ListOIDCClientConfigsMissingScopes returns the non-deleted configs of the organization, whose scopes lack any of the
+// required scopes. Scopes are compared after trimming whitespace, and are case-sensitive.
+func ListOIDCClientConfigsMissingScopes(ctx context.Context, conn *gorm.DB, decryptor Decryptor, organizationID uuid.UUID, required []string) ([]OIDCClientConfig, error) {
+	configs, err := ListOIDCClientConfigsForOrganization(ctx, conn, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	required = NormalizeScopes(required)
+
+	var results []OIDCClientConfig
+	for _, config := range configs {
+		spec, err := DecodeSpec(decryptor, config)
+		if err != nil {
+			return nil, err
+		}
+
+		scopes := map[string]bool{}
+		for _, scope := range NormalizeScopes(spec.Scopes) {
+			scopes[scope] = true
+		}
+		for _, scope := range required {
+			if !scopes[scope] {
+				results = append(results, config)
+				break
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// GroupOIDCClientConfigsByIssuer returns the number of non-deleted configs of an organization, keyed by issuer.
+func GroupOIDCClientConfigsByIssuer(ctx context.Context, conn *gorm.DB, organizationID uuid.UUID) (map[string]int, error) {
+	if organizationID == uuid.Nil {
+		return nil, errors.New("organization ID is a required argument")
+	}
+
+	var rows []struct {
+		Issuer string
+		Count  int
+	}
+
+	tx := conn.
+		WithContext(ctx).
+		Table((&OIDCClientConfig{}).TableName()).
+		Select("issuer, COUNT(*) AS count").
+		Where("organizationId = ?", organizationID.String()).
+		Where("deleted = ?", 0).
+		Group("issuer").
+		Scan(&rows)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to group oidc client configs by issuer for organization %s: %w", organizationID.String(), tx.Error)
+	}
+
+	result := make(map[string]int, len(rows))
+	for _, row := range rows {
+		result[row.Issuer] = row.Count
+	}
+
+	return result, nil
+}
+
+// UnknownIssuerHost buckets issuers from which CountOIDCConfigsByIssuerHost cannot derive a host.
+const UnknownIssuerHost = "unknown"
+
+// CountOIDCConfigsByIssuerHost returns the number of non-deleted configs across all organizations, keyed by the
+// lower-cased host of their issuer, e.g. accounts.google.com.
+func CountOIDCConfigsByIssuerHost(ctx context.Context, conn *gorm.DB) (map[string]int, error) {
+	var rows []struct {
+		Issuer string
+		Count  int
+	}
+
+	tx := conn.
+		WithContext(ctx).
+		Table((&OIDCClientConfig{}).TableName()).
+		Select("issuer, COUNT(*) AS count").
+		Where("deleted = ?", 0).
+		Group("issuer").
+		Scan(&rows)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to count oidc client configs by issuer: %w", tx.Error)
+	}
+
+	result := make(map[string]int)
+	for _, row := range rows {
+		result[issuerHost(row.Issuer)] += row.Count
+	}
+
+	return result, nil
+}
+
+func issuerHost(issuer string) string {
+	parsed, err := url.Parse(strings.TrimSpace(issuer))
+	if err != nil || parsed.Hostname() == "" {
+		return UnknownIssuerHost
+	}
+
+	return strings.ToLower(parsed.Hostname())
+}
+
+// ActiveOIDCStatusForOrganizations reports for each of the organizations whether it has an active, non-deleted config.
+// Every requested organization is present in the result.
+func ActiveOIDCStatusForOrganizations(ctx context.Context, conn *gorm.DB, organizationIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	result := make(map[uuid.UUID]bool, len(organizationIDs))
+	if len(organizationIDs) == 0 {
+		return result, nil
+	}
+
+	ids := make([]string, 0, len(organizationIDs))
+	for _, id := range organizationIDs {
+		result[id] = false
+		ids = append(ids, id.String())
+	}
+
+	var rows []struct {
+		OrganizationID uuid.UUID `gorm:"column:organizationId"`
+		Active         int       `gorm:"column:active"`
+	}
+
+	tx := conn.
+		WithContext(ctx).
+		Table((&OIDCClientConfig{}).TableName()).
+		Select("organizationId, MAX(active) AS active").
+		Where("organizationId IN ?", ids).
+		Where("deleted = ?", 0).
+		Group("organizationId").
+		Scan(&rows)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to retrieve active oidc client config status for organizations: %w", tx.Error)
+	}
+
+	for _, row := range rows {
+		result[row.OrganizationID] = row.Active > 0
+	}
+
+	return result, nil
+}
+
+// LatestModifiedOIDCClientConfigPerOrganization returns for each of the organizations its non-deleted config which was
+// modified last. Organizations without configs are absent from the result. Ties are broken by the lowest ID.
+func LatestModifiedOIDCClientConfigPerOrganization(ctx context.Context, conn *gorm.DB, organizationIDs []uuid.UUID) (map[uuid.UUID]OIDCClientConfig, error) {
+	result := make(map[uuid.UUID]OIDCClientConfig, len(organizationIDs))
+	if len(organizationIDs) == 0 {
+		return result, nil
+	}
+
+	ids := make([]string, 0, len(organizationIDs))
+	for _, id := range organizationIDs {
+		ids = append(ids, id.String())
+	}
+
+	tableName := (&OIDCClientConfig{}).TableName()
+	latest := conn.
+		Table(tableName).
+		Select("organizationId, MAX(_lastModified) AS lastModified").
+		Where("organizationId IN ?", ids).
+		Where("deleted = ?", 0).
+		Group("organizationId")
+
+	var configs []OIDCClientConfig
+	tx := conn.
+		WithContext(ctx).
+		Table(fmt.Sprintf("%s AS config", tableName)).
+		Select("config.*").
+		Joins("JOIN (?) AS latest ON latest.organizationId = config.organizationId AND latest.lastModified = config._lastModified", latest).
+		Where("config.deleted = ?", 0).
+		Order("config.id").
+		Find(&configs)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to retrieve latest modified oidc client configs for organizations: %w", tx.Error)
+	}
+
+	for _, config := range configs {
+		if _, ok := result[config.OrganizationID]; !ok {
+			result[config.OrganizationID] = config
+		}
+	}
+
+	return result, nil
+}
+
+// ListOIDCClientConfigsWithSecretExpiringBefore returns up to limit non-deleted configs, whose ClientSecret expires before the cutoff.
+// The expiry is part of the encrypted spec, hence all configs are decrypted in batches to find matching ones.
+func ListOIDCClientConfigsWithSecretExpiringBefore(ctx context.Context, conn *gorm.DB, decryptor Decryptor, cutoff time.Time, limit int) ([]OIDCClientConfig, error) {
+	if limit <= 0 {
+		return nil, errors.New("limit must be a positive number")
+	}
+
+	var results []OIDCClientConfig
+	var batch []OIDCClientConfig
+
+	tx := conn.
+		WithContext(ctx).
+		Where("deleted = ?", 0).
+		FindInBatches(&batch, 100, func(_ *gorm.DB, _ int) error {
+			for _, config := range batch {
+				spec, err := config.Data.Decrypt(decryptor)
+				if err != nil {
+					return fmt.Errorf("OIDC Client Config with ID %s: %v: %w", config.ID.String(), err, ErrDataDecryption)
+				}
+
+				if spec.SecretExpiresAt != nil && spec.SecretExpiresAt.Before(cutoff) {
+					results = append(results, config)
+				}
+
+				if len(results) >= limit {
+					// returning an error is the only way to stop FindInBatches early
+					return errStopIteration
+				}
+			}
+			return nil
+		})
+	if tx.Error != nil && !errors.Is(tx.Error, errStopIteration) {
+		if errors.Is(tx.Error, ErrDataDecryption) {
+			return nil, tx.Error
+		}
+		return nil, fmt.Errorf("failed to list oidc client configs with secrets expiring before %s: %w", cutoff.String(), tx.Error)
+	}
+
+	return results, nil
+}
+
+// ForEachOIDCClientConfig calls fn with every non-deleted config, loading batchSize configs at a time. Iteration stops at
+// the first error returned by fn, which is returned unchanged.
+func ForEachOIDCClientConfig(ctx context.Context, conn *gorm.DB, batchSize int, fn func(config OIDCClientConfig) error) error {
+	if batchSize <= 0 {
+		return errors.New("batch size must be a positive number")
+	}
+
+	var fnErr error
+	var batch []OIDCClientConfig
+
+	tx := conn.
+		WithContext(ctx).
+		Where("deleted = ?", 0).
+		FindInBatches(&batch, batchSize, func(_ *gorm.DB, _ int) error {
+			for _, config := range batch {
+				if err := fn(config); err != nil {
+					fnErr = err
+					return err
+				}
+			}
+			return nil
+		})
+	if fnErr != nil {
+		return fnErr
+	}
+	if tx.Error != nil {
+		return fmt.Errorf("failed to iterate oidc client configs: %w", tx.Error)
+	}
+
+	return nil
+}
+
+type OIDCClientConfigValidationResult struct {
+	ID             uuid.UUID
+	OrganizationID uuid.UUID
+	OK             bool
+	// Err explains why the config is broken, and is nil when OK.
+	Err error
+}
+
+// ValidateAllActiveOIDCClientConfigs decrypts and validates every non-deleted active config, e.g. as a self-check on
+// startup. A result is returned for every config, broken configs do not stop the scan.
+func ValidateAllActiveOIDCClientConfigs(ctx context.Context, conn *gorm.DB, decryptor Decryptor) ([]OIDCClientConfigValidationResult, error) {
+	var results []OIDCClientConfigValidationResult
+	var batch []OIDCClientConfig
+
+	tx := conn.
+		WithContext(ctx).
+		Where("deleted = ?", 0).
+		Where("active = ?", 1).
+		FindInBatches(&batch, 100, func(_ *gorm.DB, _ int) error {
+			for _, config := range batch {
+				result := OIDCClientConfigValidationResult{
+					ID:             config.ID,
+					OrganizationID: config.OrganizationID,
+				}
+
+				spec, err := DecodeSpec(decryptor, config)
+				if err == nil {
+					err = validateOIDCClientConfigInput(config.Issuer, spec)
+				}
+				result.OK = err == nil
+				result.Err = err
+
+				results = append(results, result)
+			}
+			return nil
+		})
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to validate active oidc client configs: %w", tx.Error)
+	}
+
+	return results, nil
+}
+
+// UndecryptableOIDCClientConfig identifies a config whose data cannot be decrypted, together with the reason.
+type UndecryptableOIDCClientConfig struct {
+	ID             uuid.UUID
+	OrganizationID uuid.UUID
+	Err            error
+}
+
+// ListUndecryptableOIDCClientConfigs returns up to limit non-deleted configs, whose data the decryptor cannot decrypt,
+// e.g. after a key has been removed from the cipher set. All configs are decrypted in batches to find them.
+func ListUndecryptableOIDCClientConfigs(ctx context.Context, conn *gorm.DB, decryptor Decryptor, limit int) ([]UndecryptableOIDCClientConfig, error) {
+	if limit <= 0 {
+		return nil, errors.New("limit must be a positive number")
+	}
+
+	var results []UndecryptableOIDCClientConfig
+	var batch []OIDCClientConfig
+
+	tx := conn.
+		WithContext(ctx).
+		Where("deleted = ?", 0).
+		FindInBatches(&batch, 100, func(_ *gorm.DB, _ int) error {
+			for _, config := range batch {
+				if _, err := config.Data.Decrypt(decryptor); err != nil {
+					results = append(results, UndecryptableOIDCClientConfig{
+						ID:             config.ID,
+						OrganizationID: config.OrganizationID,
+						Err:            fmt.Errorf("%v: %w", err, ErrDataDecryption),
+					})
+				}
+
+				if len(results) >= limit {
+					// returning an error is the only way to stop FindInBatches early
+					return errStopIteration
+				}
+			}
+			return nil
+		})
+	if tx.Error != nil && !errors.Is(tx.Error, errStopIteration) {
+		return nil, fmt.Errorf("failed to list undecryptable oidc client configs: %w", tx.Error)
+	}
+
+	return results, nil
+}
+
+// ListOIDCClientConfigsWithUnresolvableIssuer returns up to limit non-deleted configs whose issuer host is not found via
+// DNS, which are likely abandoned integrations. Each host is looked up once. Configs whose issuer has no host are not
+// included. Any other lookup failure is returned as an error, so that an unavailable resolver does not flag all
+// configs. A nil resolver uses net.DefaultResolver.
+func ListOIDCClientConfigsWithUnresolvableIssuer(ctx context.Context, conn *gorm.DB, resolver *net.Resolver, limit int) ([]OIDCClientConfig, error) {
+	if limit <= 0 {
+		return nil, errors.New("limit must be a positive number")
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	resolvable := map[string]bool{}
+	var results []OIDCClientConfig
+	var batch []OIDCClientConfig
+
+	tx := conn.
+		WithContext(ctx).
+		Where("deleted = ?", 0).
+		FindInBatches(&batch, 100, func(_ *gorm.DB, _ int) error {
+			for _, config := range batch {
+				host := issuerHost(config.Issuer)
+				if host == UnknownIssuerHost {
+					continue
+				}
+
+				ok, looked := resolvable[host]
+				if !looked {
+					_, err := resolver.LookupHost(ctx, host)
+					var dnsErr *net.DNSError
+					switch {
+					case err == nil:
+						ok = true
+					case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+						ok = false
+					default:
+						return fmt.Errorf("failed to resolve issuer host %s: %w", host, err)
+					}
+					resolvable[host] = ok
+				}
+
+				if !ok {
+					results = append(results, config)
+				}
+
+				if len(results) >= limit {
+					// returning an error is the only way to stop FindInBatches early
+					return errStopIteration
+				}
+			}
+			return nil
+		})
+	if tx.Error != nil && !errors.Is(tx.Error, errStopIteration) {
+		return nil, fmt.Errorf("failed to list oidc client configs with unresolvable issuer: %w", tx.Error)
+	}
+
+	return results, nil
+}
+
+type NormalizationReport struct {
+	Scanned int
+	Changed int
+	Failed  int
+}
+
+// NormalizeExistingOIDCClientConfigs re-applies issuer and redirect URL normalization and scope deduplication to all
+// non-deleted configs, in batches of batchSize. Only configs which change are written back. Configs which cannot be
+// decrypted or normalized are counted as failed and left unchanged, so the function is safe to re-run.
+func NormalizeExistingOIDCClientConfigs(ctx context.Context, conn *gorm.DB, cipher Cipher, batchSize int) (NormalizationReport, error) {
+	if batchSize <= 0 {
+		return NormalizationReport{}, errors.New("batch size must be a positive number")
+	}
+
+	report, err := updateExistingOIDCClientConfigs(ctx, conn, batchSize, func(config OIDCClientConfig) (bool, error) {
+		return normalizeOIDCClientConfig(ctx, conn, cipher, config)
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to normalize oidc client configs: %w", err)
+	}
+
+	return report, nil
+}
+
+// EnablePKCEForExistingOIDCClientConfigs enables PKCE on all non-deleted configs which leave UsePKCE unset, and whose
+// response type supports it. It applies the default of CreateOIDCClientConfig to configs stored before, configs which
+// disable PKCE explicitly are left unchanged.
+// Configs which cannot be decrypted are counted as failed and left unchanged, so the function is safe to re-run.
+func EnablePKCEForExistingOIDCClientConfigs(ctx context.Context, conn *gorm.DB, cipher Cipher, batchSize int) (NormalizationReport, error) {
+	if batchSize <= 0 {
+		return NormalizationReport{}, errors.New("batch size must be a positive number")
+	}
+
+	report, err := updateExistingOIDCClientConfigs(ctx, conn, batchSize, func(config OIDCClientConfig) (bool, error) {
+		spec, err := DecodeSpec(cipher, config)
+		if err != nil {
+			return false, err
+		}
+		if spec.UsePKCE != nil || !supportsPKCE(spec.EffectiveResponseType()) {
+			return false, nil
+		}
+
+		usePKCE := true
+		spec.UsePKCE = &usePKCE
+		data, err := EncryptJSON(cipher, spec)
+		if err != nil {
+			return false, fmt.Errorf("failed to encrypt oidc spec: %w", err)
+		}
+
+		tx := conn.
+			WithContext(ctx).
+			Table((&OIDCClientConfig{}).TableName()).
+			Where("id = ?", config.ID.String()).
+			Updates(map[string]interface{}{
+				"data":          data,
+				"_lastModified": time.Now().UTC(),
+			})
+		if tx.Error != nil {
+			return false, fmt.Errorf("failed to update oidc client config %s: %w", config.ID.String(), tx.Error)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to enable pkce for oidc client configs: %w", err)
+	}
+
+	return report, nil
+}
+
+// updateExistingOIDCClientConfigs calls update for all non-deleted configs, in batches of batchSize. Configs for which
+// update fails are counted as failed, unless the context is done, which aborts the scan.
+func updateExistingOIDCClientConfigs(ctx context.Context, conn *gorm.DB, batchSize int, update func(config OIDCClientConfig) (bool, error)) (NormalizationReport, error) {
+	var report NormalizationReport
+	var batch []OIDCClientConfig
+
+	tx := conn.
+		WithContext(ctx).
+		Where("deleted = ?", 0).
+		FindInBatches(&batch, batchSize, func(_ *gorm.DB, _ int) error {
+			for _, config := range batch {
+				report.Scanned++
+
+				changed, err := update(config)
+				if err != nil {
+					if ctx.Err() != nil {
+						return err
+					}
+					report.Failed++
+					continue
+				}
+				if changed {
+					report.Changed++
+				}
+			}
+			return nil
+		})
+
+	return report, tx.Error
+}
+
+// normalizeOIDCClientConfig writes back the normalized issuer and spec of the config, if they differ from the stored ones.
+func normalizeOIDCClientConfig(ctx context.Context, conn *gorm.DB, cipher Cipher, config OIDCClientConfig) (bool, error) {
+	spec, err := DecodeSpec(cipher, config)
+	if err != nil {
+		return false, err
+	}
+
+	issuer, err := NormalizeIssuer(config.Issuer)
+	if err != nil {
+		return false, err
+	}
+
+	normalized := spec
+	normalized.Scopes = NormalizeScopes(spec.Scopes)
+	if spec.RedirectURL != "" {
+		normalized.RedirectURL, err = NormalizeRedirectURL(spec.RedirectURL)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	specChanged := normalized.RedirectURL != spec.RedirectURL || !equalStrings(normalized.Scopes, spec.Scopes)
+	if !specChanged && issuer == config.Issuer {
+		return false, nil
+	}
+
+	updates := map[string]interface{}{
+		"issuer":        issuer,
+		"_lastModified": time.Now().UTC(),
+	}
+	if specChanged {
+		data, err := EncryptJSON(cipher, normalized)
+		if err != nil {
+			return false, fmt.Errorf("failed to encrypt oidc spec: %w", err)
+		}
+		updates["data"] = data
+	}
+
+	tx := conn.
+		WithContext(ctx).
+		Table((&OIDCClientConfig{}).TableName()).
+		Where("id = ?", config.ID.String()).
+		Updates(updates)
+	if tx.Error != nil {
+		return false, fmt.Errorf("failed to update oidc client config %s: %w", config.ID.String(), tx.Error)
+	}
+
+	return true, nil
+}
+
+// ReencryptOIDCClientConfig re-encrypts the spec of a single config with the primary key of the cipher, which must still
+// be able to decrypt the spec, e.g. with a previous key. ErrDataDecryption is returned when the spec cannot be decrypted.
+func ReencryptOIDCClientConfig(ctx context.Context, conn *gorm.DB, cipher Cipher, id uuid.UUID) (OIDCClientConfig, error) {
+	config, err := GetOIDCClientConfig(ctx, conn, id)
+	if err != nil {
+		return OIDCClientConfig{}, err
+	}
+
+	spec, err := DecodeSpec(cipher, config)
+	if err != nil {
+		return OIDCClientConfig{}, err
+	}
+
+	data, err := EncryptJSON(cipher, spec)
+	if err != nil {
+		return OIDCClientConfig{}, fmt.Errorf("failed to encrypt oidc spec: %w", err)
+	}
+
+	lastModified := time.Now().UTC()
+	tx := conn.
+		WithContext(ctx).
+		Table((&OIDCClientConfig{}).TableName()).
+		Where("id = ?", id.String()).
+		// guards against overwriting a spec changed since it was read
+		Where("data = ?", string(config.Data)).
+		Updates(map[string]interface{}{
+			"data":          data,
+			"_lastModified": lastModified,
+		})
+	if tx.Error != nil {
+		return OIDCClientConfig{}, fmt.Errorf("failed to re-encrypt oidc client config %s: %w", id.String(), tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return OIDCClientConfig{}, fmt.Errorf("OIDC Client Config with ID %s changed while it was re-encrypted, retry", id.String())
+	}
+
+	config.Data = data
+	config.LastModified = lastModified
+
+	return config, nil
+}
+
+// RewriteRedirectURLHostForOrganization replaces the host of the redirect URL of all non-deleted configs of the organization
+// whose host is oldHost, e.g. after a domain migration. Hosts are compared case-insensitively, ports and paths are kept.
+// Rewritten configs have to be verified again. All configs are rewritten in a single transaction, which is rolled back
+// if any config cannot be decrypted or the rewrite would make two configs share a redirect URL.
+// Returns the number of rewritten configs.
+func RewriteRedirectURLHostForOrganization(ctx context.Context, conn *gorm.DB, cipher Cipher, organizationID uuid.UUID, oldHost, newHost string) (int, error) {
+	if oldHost == "" || newHost == "" {
+		return 0, errors.New("old and new host are required arguments")
+	}
+	if strings.ContainsAny(newHost, "/:?#@") {
+		return 0, fmt.Errorf("new host %q must be a plain host name", newHost)
+	}
+
+	changed := 0
+	err := conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		configs, err := ListOIDCClientConfigsForOrganization(ctx, tx, organizationID)
+		if err != nil {
+			return err
+		}
+
+		// normalized redirect URL after the rewrite, to detect configs which would collide
+		redirectURLs := make(map[string]uuid.UUID, len(configs))
+		for _, config := range configs {
+			spec, err := DecodeSpec(cipher, config)
+			if err != nil {
+				return err
+			}
+			if spec.RedirectURL == "" {
+				continue
+			}
+
+			u, err := url.Parse(spec.RedirectURL)
+			if err != nil {
+				return fmt.Errorf("failed to parse redirect url of oidc client config %s: %w", config.ID.String(), err)
+			}
+
+			if strings.EqualFold(u.Hostname(), oldHost) {
+				if port := u.Port(); port != "" {
+					u.Host = net.JoinHostPort(newHost, port)
+				} else {
+					u.Host = newHost
+				}
+				spec.RedirectURL = u.String()
+
+				data, err := EncryptJSON(cipher, spec)
+				if err != nil {
+					return fmt.Errorf("failed to encrypt oidc spec: %w", err)
+				}
+
+				update := tx.
+					WithContext(ctx).
+					Table((&OIDCClientConfig{}).TableName()).
+					Where("id = ?", config.ID.String()).
+					Updates(map[string]interface{}{
+						"data":          data,
+						"verifiedAt":    nil,
+						"_lastModified": time.Now().UTC(),
+					})
+				if update.Error != nil {
+					return fmt.Errorf("failed to update oidc client config %s: %w", config.ID.String(), update.Error)
+				}
+				changed++
+			}
+
+			normalized, err := NormalizeRedirectURL(spec.RedirectURL)
+			if err != nil {
+				return err
+			}
+			if other, ok := redirectURLs[normalized]; ok {
+				return fmt.Errorf("redirect url %s of oidc client config %s collides with oidc client config %s: %w", spec.RedirectURL, config.ID.String(), other.String(), ErrRedirectURLConflict)
+			}
+			redirectURLs[normalized] = config.ID
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return changed, nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
 func DeleteOIDCClientConfig(ctx context.Context, conn *gorm.DB, id, organizationID uuid.UUID) error {
@@ -159,7 +1624,35 @@ func DeleteOIDCClientConfig(ctx context.Context, conn *gorm.DB, id, organization
 		Update("deleted", 1)
 
 	if tx.Error != nil {
-		return fmt.Errorf("failed to delete oidc client config (ID: %s): %v", id.String(), tx.Error)
+		return fmt.Errorf("failed to delete oidc client config (ID: %s): %w", id.String(), tx.Error)
+	}
+
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("oidc client config ID: %s for organization ID: %s does not exist: %w", id.String(), organizationID.String(), ErrorNotFound)
+	}
+
+	return nil
+}
+
+// HardDeleteOIDCClientConfig physically removes the OIDC Client Config, including its encrypted secret.
+// It is intended to be used after the retention window of a soft-delete has passed.
+func HardDeleteOIDCClientConfig(ctx context.Context, conn *gorm.DB, id, organizationID uuid.UUID) error {
+	if id == uuid.Nil {
+		return fmt.Errorf("id is a required argument")
+	}
+
+	if organizationID == uuid.Nil {
+		return fmt.Errorf("organization id is a required argument")
+	}
+
+	tx := conn.
+		WithContext(ctx).
+		Where("id = ?", id).
+		Where("organizationId = ?", organizationID).
+		Delete(&OIDCClientConfig{})
+
+	if tx.Error != nil {
+		return fmt.Errorf("failed to hard delete oidc client config (ID: %s): %w", id.String(), tx.Error)
 	}
 
 	if tx.RowsAffected == 0 {
@@ -169,7 +1662,149 @@ func DeleteOIDCClientConfig(ctx context.Context, conn *gorm.DB, id, organization
 	return nil
 }
 
+// ScheduleOIDCClientConfigDeletion schedules the config of the organization to be soft-deleted after the grace period,
+// which admins can cancel the deletion within. Scheduling a config again replaces its scheduled time.
+func ScheduleOIDCClientConfigDeletion(ctx context.Context, conn *gorm.DB, id, organizationID uuid.UUID, after time.Duration) error {
+	if after <= 0 {
+		return errors.New("grace period must be a positive duration")
+	}
+
+	return setOIDCClientConfigDeleteScheduledAt(ctx, conn, id, organizationID, time.Now().UTC().Add(after))
+}
+
+// CancelScheduledDeletion cancels the scheduled deletion of the config of the organization. Cancelling a config, whose
+// deletion is not scheduled, has no effect.
+func CancelScheduledDeletion(ctx context.Context, conn *gorm.DB, id, organizationID uuid.UUID) error {
+	return setOIDCClientConfigDeleteScheduledAt(ctx, conn, id, organizationID, nil)
+}
+
+func setOIDCClientConfigDeleteScheduledAt(ctx context.Context, conn *gorm.DB, id, organizationID uuid.UUID, deleteScheduledAt interface{}) error {
+	_, err := GetOIDCClientConfigForOrganization(ctx, conn, id, organizationID)
+	if err != nil {
+		return err
+	}
+
+	tx := conn.
+		WithContext(ctx).
+		Table((&OIDCClientConfig{}).TableName()).
+		Where("id = ?", id.String()).
+		Where("organizationId = ?", organizationID.String()).
+		Updates(map[string]interface{}{
+			"deleteScheduledAt": deleteScheduledAt,
+			"_lastModified":     time.Now().UTC(),
+		})
+	if tx.Error != nil {
+		return fmt.Errorf("failed to update scheduled deletion of oidc client config %s: %w", id.String(), tx.Error)
+	}
+
+	return nil
+}
+
+// ProcessScheduledOIDCDeletions soft-deletes all configs, whose scheduled deletion is due, and returns their number.
+func ProcessScheduledOIDCDeletions(ctx context.Context, conn *gorm.DB) (int64, error) {
+	now := time.Now().UTC()
+
+	tx := conn.
+		WithContext(ctx).
+		Table((&OIDCClientConfig{}).TableName()).
+		Where("deleted = ?", 0).
+		Where("deleteScheduledAt IS NOT NULL").
+		Where("deleteScheduledAt <= ?", now).
+		Updates(map[string]interface{}{
+			"deleted":       1,
+			"_lastModified": now,
+		})
+	if tx.Error != nil {
+		return 0, fmt.Errorf("failed to delete oidc client configs scheduled for deletion: %w", tx.Error)
+	}
+
+	return tx.RowsAffected, nil
+}
+
+// ListOrphanedOIDCClientConfigs returns up to limit non-deleted configs, whose organization has no team row at all.
+// Configs of teams which are only marked as deleted are not orphaned.
+func ListOrphanedOIDCClientConfigs(ctx context.Context, conn *gorm.DB, limit int) ([]OIDCClientConfig, error) {
+	if limit <= 0 {
+		return nil, errors.New("limit must be a positive number")
+	}
+
+	var configs []OIDCClientConfig
+	tx := conn.
+		WithContext(ctx).
+		Table(fmt.Sprintf("%s AS config", (&OIDCClientConfig{}).TableName())).
+		Select("config.*").
+		Joins(fmt.Sprintf("LEFT JOIN %s AS team ON team.id = config.organizationId", (&Team{}).TableName())).
+		Where("team.id IS NULL").
+		Where("config.deleted = ?", 0).
+		Order("config.id").
+		Limit(limit).
+		Find(&configs)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to list orphaned oidc client configs: %w", tx.Error)
+	}
+
+	return configs, nil
+}
+
+// ListStaleUnactivatedOIDCClientConfigs returns the non-deleted configs, which were created more than olderThan ago and
+// have never been active, oldest first. Configs which were deactivated again are told apart by their FirstActivatedAt.
+// It is unknown for configs which were deactivated before it was introduced, they are listed as never active.
+func ListStaleUnactivatedOIDCClientConfigs(ctx context.Context, conn *gorm.DB, olderThan time.Duration) ([]OIDCClientConfig, error) {
+	if olderThan <= 0 {
+		return nil, errors.New("olderThan must be a positive duration")
+	}
+
+	var configs []OIDCClientConfig
+	tx := conn.
+		WithContext(ctx).
+		Table(fmt.Sprintf("%s AS config", (&OIDCClientConfig{}).TableName())).
+		Select("config.*").
+		Where("config.deleted = ?", 0).
+		Where("config.active = ?", 0).
+		Where("config.createdAt < ?", time.Now().UTC().Add(-olderThan)).
+		Where("config.firstActivatedAt IS NULL").
+		Order("config.createdAt").
+		Order("config.id").
+		Find(&configs)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to list stale unactivated oidc client configs: %w", tx.Error)
+	}
+
+	return configs, nil
+}
+
+// DeleteOrphanedOIDCClientConfigs soft-deletes all configs listed by ListOrphanedOIDCClientConfigs, and returns their number.
+func DeleteOrphanedOIDCClientConfigs(ctx context.Context, conn *gorm.DB) (int64, error) {
+	tableName := (&OIDCClientConfig{}).TableName()
+
+	tx := conn.
+		WithContext(ctx).
+		Table(tableName).
+		Where("deleted = ?", 0).
+		Where(fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s AS team WHERE team.id = %s.organizationId)", (&Team{}).TableName(), tableName)).
+		Updates(map[string]interface{}{
+			"deleted":       1,
+			"_lastModified": time.Now().UTC(),
+		})
+	if tx.Error != nil {
+		return 0, fmt.Errorf("failed to delete orphaned oidc client configs: %w", tx.Error)
+	}
+
+	return tx.RowsAffected, nil
+}
+
+// GetOIDCClientConfigByOrgSlug returns the active config of the organization with the slug, as used for logins.
+// Deleted configs and configs of deleted organizations are never returned, ErrorNotFound is returned instead.
 func GetOIDCClientConfigByOrgSlug(ctx context.Context, conn *gorm.DB, slug string) (OIDCClientConfig, error) {
+	return getOIDCClientConfigByOrgSlug(ctx, conn, slug, true)
+}
+
+// GetAnyOIDCClientConfigByOrgSlug behaves like GetOIDCClientConfigByOrgSlug, but also returns inactive configs.
+func GetAnyOIDCClientConfigByOrgSlug(ctx context.Context, conn *gorm.DB, slug string) (OIDCClientConfig, error) {
+	return getOIDCClientConfigByOrgSlug(ctx, conn, slug, false)
+}
+
+func getOIDCClientConfigByOrgSlug(ctx context.Context, conn *gorm.DB, slug string, onlyActive bool) (OIDCClientConfig, error) {
 	var config OIDCClientConfig
 
 	if slug == "" {
@@ -182,29 +1817,471 @@ func GetOIDCClientConfigByOrgSlug(ctx context.Context, conn *gorm.DB, slug strin
 		// TODO: is there a better way to reference table names here and below?
 		Joins("JOIN d_b_team team ON team.id = d_b_oidc_client_config.organizationId").
 		Where("team.slug = ?", slug).
-		Where("d_b_oidc_client_config.deleted = ?", 0).
-		First(&config)
+		Where("team.markedDeleted = ?", 0).
+		Where("team.deleted = ?", 0).
+		Where("d_b_oidc_client_config.deleted = ?", 0)
+	if onlyActive {
+		tx = tx.Where("d_b_oidc_client_config.active = ?", 1)
+	}
+	tx = tx.First(&config)
 
 	if tx.Error != nil {
-		return OIDCClientConfig{}, fmt.Errorf("failed to get oidc client config by org slug (slug: %s): %v", slug, tx.Error)
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return OIDCClientConfig{}, fmt.Errorf("OIDC Client Config for Organization (slug: %s) does not exist: %w", slug, ErrorNotFound)
+		}
+		return OIDCClientConfig{}, fmt.Errorf("failed to get oidc client config by org slug (slug: %s): %w", slug, tx.Error)
 	}
 
 	return config, nil
 }
 
+// OIDCClientConfigDiagnosis describes how the configs of an organization resolve, to support investigations.
+type OIDCClientConfigDiagnosis struct {
+	OrganizationID uuid.UUID
+
+	// ActiveConfigs is the number of non-deleted active configs, ActiveConfigID the first of them.
+	ActiveConfigs  int
+	ActiveConfigID uuid.UUID
+
+	// SlugConfigID is the config the join on the organization slug resolves to, regardless of the active flag.
+	SlugConfigID      uuid.UUID
+	SlugConfigActive  bool
+	SlugMatchesActive bool
+
+	DecryptionFailures []UndecryptableOIDCClientConfig
+}
+
+// DiagnoseOIDCClientConfigForOrganization reports whether the config resolved through the slug of the organization
+// is its single active config, and which of its non-deleted configs cannot be decrypted.
+func DiagnoseOIDCClientConfigForOrganization(ctx context.Context, conn *gorm.DB, decryptor Decryptor, organizationID uuid.UUID) (OIDCClientConfigDiagnosis, error) {
+	configs, err := ListOIDCClientConfigsForOrganization(ctx, conn, organizationID)
+	if err != nil {
+		return OIDCClientConfigDiagnosis{}, err
+	}
+
+	diagnosis := OIDCClientConfigDiagnosis{OrganizationID: organizationID}
+	for _, config := range configs {
+		if config.Active {
+			if diagnosis.ActiveConfigs == 0 {
+				diagnosis.ActiveConfigID = config.ID
+			}
+			diagnosis.ActiveConfigs++
+		}
+
+		if _, err := config.Data.Decrypt(decryptor); err != nil {
+			diagnosis.DecryptionFailures = append(diagnosis.DecryptionFailures, UndecryptableOIDCClientConfig{
+				ID:             config.ID,
+				OrganizationID: config.OrganizationID,
+				Err:            fmt.Errorf("%v: %w", err, ErrDataDecryption),
+			})
+		}
+	}
+
+	var team Team
+	tx := conn.
+		WithContext(ctx).
+		Where("id = ?", organizationID.String()).
+		First(&team)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return OIDCClientConfigDiagnosis{}, fmt.Errorf("Organization %s does not exist: %w", organizationID.String(), ErrorNotFound)
+		}
+		return OIDCClientConfigDiagnosis{}, fmt.Errorf("failed to get organization %s: %w", organizationID.String(), tx.Error)
+	}
+
+	slugConfig, err := GetAnyOIDCClientConfigByOrgSlug(ctx, conn, team.Slug)
+	if err != nil && !errors.Is(err, ErrorNotFound) {
+		return OIDCClientConfigDiagnosis{}, err
+	}
+	if err == nil {
+		diagnosis.SlugConfigID = slugConfig.ID
+		diagnosis.SlugConfigActive = slugConfig.Active
+	}
+
+	diagnosis.SlugMatchesActive = diagnosis.ActiveConfigs == 1 && diagnosis.SlugConfigID == diagnosis.ActiveConfigID
+
+	return diagnosis, nil
+}
+
+// MarkOIDCClientConfigVerified records that a test login with the config of the organization succeeded.
+func MarkOIDCClientConfigVerified(ctx context.Context, conn *gorm.DB, id, organizationID uuid.UUID) error {
+	return setOIDCClientConfigVerifiedAt(ctx, conn, id, organizationID, time.Now().UTC())
+}
+
+// ClearOIDCClientConfigVerification resets the verification of the config of the organization.
+func ClearOIDCClientConfigVerification(ctx context.Context, conn *gorm.DB, id, organizationID uuid.UUID) error {
+	return setOIDCClientConfigVerifiedAt(ctx, conn, id, organizationID, nil)
+}
+
+func setOIDCClientConfigVerifiedAt(ctx context.Context, conn *gorm.DB, id, organizationID uuid.UUID, verifiedAt interface{}) error {
+	_, err := GetOIDCClientConfigForOrganization(ctx, conn, id, organizationID)
+	if err != nil {
+		return err
+	}
+
+	tx := conn.
+		WithContext(ctx).
+		Table((&OIDCClientConfig{}).TableName()).
+		Where("id = ?", id.String()).
+		Where("organizationId = ?", organizationID.String()).
+		Updates(map[string]interface{}{
+			"verifiedAt": verifiedAt,
+			// verification does not change the spec, keep _lastModified from being bumped on update, which would
+			// invalidate cached specs
+			"_lastModified": gorm.Expr("_lastModified"),
+		})
+	if tx.Error != nil {
+		return fmt.Errorf("failed to update verification of oidc client config %s: %w", id.String(), tx.Error)
+	}
+
+	return nil
+}
+
 func ActivateClientConfig(ctx context.Context, conn *gorm.DB, id uuid.UUID) error {
-	_, err := GetOIDCClientConfig(ctx, conn, id)
+	return activateClientConfig(ctx, conn, id, false)
+}
+
+// ActivateVerifiedClientConfig behaves like ActivateClientConfig, but refuses to activate a config which has not been
+// verified through a successful test login, returning ErrOIDCClientConfigNotVerified.
+func ActivateVerifiedClientConfig(ctx context.Context, conn *gorm.DB, id uuid.UUID) error {
+	return activateClientConfig(ctx, conn, id, true)
+}
+
+func activateClientConfig(ctx context.Context, conn *gorm.DB, id uuid.UUID, requireVerified bool) error {
+	config, err := GetOIDCClientConfig(ctx, conn, id)
+	if err != nil {
+		return err
+	}
+
+	if requireVerified && config.VerifiedAt == nil {
+		return fmt.Errorf("oidc client config %s: %w", id.String(), ErrOIDCClientConfigNotVerified)
+	}
+
+	tx := conn.
+		WithContext(ctx).
+		Table((&OIDCClientConfig{}).TableName()).
+		Where("id = ?", id.String()).
+		Updates(activationUpdates())
+	if tx.Error != nil {
+		return fmt.Errorf("failed to mark oidc client config as active (id: %s): %w", id.String(), tx.Error)
+	}
+	return nil
+}
+
+type OIDCClientConfigActivation struct {
+	ID             uuid.UUID
+	OrganizationID uuid.UUID
+}
+
+type OIDCClientConfigActivationResult struct {
+	ID             uuid.UUID
+	OrganizationID uuid.UUID
+	// Err is nil when the config was activated.
+	Err error
+}
+
+// ActivateOIDCClientConfigs activates each of the given configs, keeping at most one active config per organization.
+// Every item is validated and activated in its own transaction, such that a failing item does not block the others.
+// Results are returned in the same order as the items.
+func ActivateOIDCClientConfigs(ctx context.Context, conn *gorm.DB, items []OIDCClientConfigActivation) []OIDCClientConfigActivationResult {
+	results := make([]OIDCClientConfigActivationResult, 0, len(items))
+	activatedOrgs := make(map[uuid.UUID]uuid.UUID)
+
+	for _, item := range items {
+		result := OIDCClientConfigActivationResult{
+			ID:             item.ID,
+			OrganizationID: item.OrganizationID,
+		}
+
+		if previous, ok := activatedOrgs[item.OrganizationID]; ok {
+			result.Err = fmt.Errorf("oidc client config %s was already activated for organization %s in this batch", previous.String(), item.OrganizationID.String())
+			results = append(results, result)
+			continue
+		}
+
+		result.Err = conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return activateOIDCClientConfigForOrganization(ctx, tx, item.ID, item.OrganizationID)
+		})
+		if result.Err == nil {
+			activatedOrgs[item.OrganizationID] = item.ID
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+type OIDCClientConfigAutoActivationOutcome string
+
+const (
+	OIDCClientConfigAutoActivationActivated       OIDCClientConfigAutoActivationOutcome = "activated"
+	OIDCClientConfigAutoActivationSkippedZero     OIDCClientConfigAutoActivationOutcome = "skipped_zero"
+	OIDCClientConfigAutoActivationSkippedMultiple OIDCClientConfigAutoActivationOutcome = "skipped_multiple"
+	OIDCClientConfigAutoActivationError           OIDCClientConfigAutoActivationOutcome = "error"
+)
+
+type OIDCClientConfigAutoActivationResult struct {
+	OrganizationID uuid.UUID
+	Outcome        OIDCClientConfigAutoActivationOutcome
+	// ID is the activated config, set when the Outcome is OIDCClientConfigAutoActivationActivated.
+	ID uuid.UUID
+	// Err is set when the Outcome is OIDCClientConfigAutoActivationError.
+	Err error
+}
+
+// AutoActivateSingleConfigPerOrganization activates the config of each of the organizations which has exactly one
+// non-deleted config. Organizations with zero or multiple configs are skipped, as the config to activate is ambiguous.
+// Every organization is handled in its own transaction. Results are returned in the same order as the organizations.
+func AutoActivateSingleConfigPerOrganization(ctx context.Context, conn *gorm.DB, organizationIDs []uuid.UUID) []OIDCClientConfigAutoActivationResult {
+	results := make([]OIDCClientConfigAutoActivationResult, 0, len(organizationIDs))
+
+	for _, organizationID := range organizationIDs {
+		result := OIDCClientConfigAutoActivationResult{
+			OrganizationID: organizationID,
+		}
+
+		err := conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			configs, err := ListOIDCClientConfigsForOrganization(ctx, tx, organizationID)
+			if err != nil {
+				return err
+			}
+
+			if len(configs) == 0 {
+				result.Outcome = OIDCClientConfigAutoActivationSkippedZero
+				return nil
+			}
+			if len(configs) > 1 {
+				result.Outcome = OIDCClientConfigAutoActivationSkippedMultiple
+				return nil
+			}
+
+			if err := activateOIDCClientConfigForOrganization(ctx, tx, configs[0].ID, organizationID); err != nil {
+				return err
+			}
+
+			result.Outcome = OIDCClientConfigAutoActivationActivated
+			result.ID = configs[0].ID
+			return nil
+		})
+		if err != nil {
+			result = OIDCClientConfigAutoActivationResult{
+				OrganizationID: organizationID,
+				Outcome:        OIDCClientConfigAutoActivationError,
+				Err:            err,
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// SetSoleActiveOIDCClientConfig makes a config with the issuer and spec the only config of the organization, in a single
+// transaction, and returns it. The active config is kept and updated, otherwise a config with the same issuer, so
+// references to its ID stay valid. A new config is created if neither exists. All other configs are soft-deleted.
+// A kept config whose issuer and spec are unchanged is not updated, and keeps its verification.
+func SetSoleActiveOIDCClientConfig(ctx context.Context, conn *gorm.DB, cipher Cipher, organizationID uuid.UUID, issuer string, spec OIDCSpec) (OIDCClientConfig, error) {
+	if organizationID == uuid.Nil {
+		return OIDCClientConfig{}, errors.New("organization ID is a required argument")
+	}
+
+	if err := validateOIDCClientConfigInput(issuer, spec); err != nil {
+		return OIDCClientConfig{}, err
+	}
+	// compare the kept config with the spec as it would be stored
+	spec = withPKCEDefault(spec)
+
+	var result OIDCClientConfig
+	err := conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		configs, err := ListOIDCClientConfigsForOrganization(ctx, tx, organizationID)
+		if err != nil {
+			return err
+		}
+
+		var kept *OIDCClientConfig
+		for i := range configs {
+			if configs[i].Active {
+				kept = &configs[i]
+				break
+			}
+		}
+		if kept == nil {
+			for i := range configs {
+				if configs[i].Issuer == issuer {
+					kept = &configs[i]
+					break
+				}
+			}
+		}
+
+		// delete the others first, so their redirect URLs do not conflict with the spec
+		for _, config := range configs {
+			if kept != nil && config.ID == kept.ID {
+				continue
+			}
+			if err := DeleteOIDCClientConfig(ctx, tx, config.ID, organizationID); err != nil {
+				return err
+			}
+		}
+
+		var id uuid.UUID
+		switch {
+		case kept == nil:
+			created, err := CreateOIDCClientConfigAutoID(ctx, tx, cipher, organizationID, issuer, spec)
+			if err != nil {
+				return err
+			}
+			id = created.ID
+		case kept.Issuer == issuer && specUnchanged(cipher, *kept, spec):
+			id = kept.ID
+		default:
+			if _, err := UpdateOIDCClientConfig(ctx, tx, cipher, kept.ID, organizationID, issuer, spec); err != nil {
+				return err
+			}
+			id = kept.ID
+		}
+
+		if err := activateOIDCClientConfigForOrganization(ctx, tx, id, organizationID); err != nil {
+			return err
+		}
+
+		result, err = GetOIDCClientConfigForOrganization(ctx, tx, id, organizationID)
+		return err
+	})
+	if err != nil {
+		return OIDCClientConfig{}, err
+	}
+
+	return result, nil
+}
+
+// specUnchanged reports whether the stored spec of the config equals the spec. Configs which cannot be decrypted differ.
+func specUnchanged(decryptor Decryptor, config OIDCClientConfig, spec OIDCSpec) bool {
+	stored, err := DecodeSpec(decryptor, config)
+	if err != nil {
+		return false
+	}
+
+	return stored.Fingerprint() == spec.Fingerprint()
+}
+
+// SwapActiveOIDCClientConfig makes the target the only active config of the organization, in a single transaction.
+// Returns ErrorNotFound if the target is not a non-deleted config of the organization, leaving all configs unchanged.
+func SwapActiveOIDCClientConfig(ctx context.Context, conn *gorm.DB, organizationID, targetID uuid.UUID) error {
+	return conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return activateOIDCClientConfigForOrganization(ctx, tx, targetID, organizationID)
+	})
+}
+
+// activationUpdates marks a config as active, and records its first activation.
+func activationUpdates() map[string]interface{} {
+	return map[string]interface{}{
+		"active":           1,
+		"firstActivatedAt": gorm.Expr("COALESCE(firstActivatedAt, ?)", time.Now().UTC()),
+	}
+}
+
+// activateOIDCClientConfigForOrganization marks the config as active, and all other configs of the organization as inactive.
+func activateOIDCClientConfigForOrganization(ctx context.Context, conn *gorm.DB, id, organizationID uuid.UUID) error {
+	_, err := GetOIDCClientConfigForOrganization(ctx, conn, id, organizationID)
 	if err != nil {
 		return err
 	}
 
 	tx := conn.
+		WithContext(ctx).
+		Table((&OIDCClientConfig{}).TableName()).
+		Where("organizationId = ?", organizationID.String()).
+		Where("id <> ?", id.String()).
+		Where("active = ?", 1).
+		Update("active", 0)
+	if tx.Error != nil {
+		return fmt.Errorf("failed to deactivate oidc client configs for organization %s: %w", organizationID.String(), tx.Error)
+	}
+
+	tx = conn.
 		WithContext(ctx).
 		Table((&OIDCClientConfig{}).TableName()).
 		Where("id = ?", id.String()).
-		Update("active", 1)
+		Updates(activationUpdates())
 	if tx.Error != nil {
-		return fmt.Errorf("failed to mark oidc client config as active (id: %s): %v", id.String(), tx.Error)
+		return fmt.Errorf("failed to mark oidc client config as active (id: %s): %w", id.String(), tx.Error)
 	}
+
 	return nil
 }
+
+type DuplicateClientSecretGroup struct {
+	// SecretHash is a salted hash of the shared ClientSecret. The salt is random per invocation,
+	// so hashes can only be compared within the same result.
+	SecretHash string
+	ConfigIDs  []uuid.UUID
+}
+
+// FindDuplicateClientSecrets decrypts all non-deleted configs and returns up to limit groups of configs sharing the same ClientSecret.
+// The plaintext secret is never returned.
+func FindDuplicateClientSecrets(ctx context.Context, conn *gorm.DB, decryptor Decryptor, limit int) ([]DuplicateClientSecretGroup, error) {
+	if limit <= 0 {
+		return nil, errors.New("limit must be a positive number")
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	var hashes []string
+	idsByHash := make(map[string][]uuid.UUID)
+	var batch []OIDCClientConfig
+
+	tx := conn.
+		WithContext(ctx).
+		Where("deleted = ?", 0).
+		FindInBatches(&batch, 100, func(_ *gorm.DB, _ int) error {
+			for _, config := range batch {
+				spec, err := config.Data.Decrypt(decryptor)
+				if err != nil {
+					return fmt.Errorf("OIDC Client Config with ID %s: %v: %w", config.ID.String(), err, ErrDataDecryption)
+				}
+
+				if spec.ClientSecret == "" {
+					continue
+				}
+
+				mac := hmac.New(sha256.New, salt)
+				mac.Write([]byte(spec.ClientSecret))
+				hash := hex.EncodeToString(mac.Sum(nil))
+
+				if _, seen := idsByHash[hash]; !seen {
+					hashes = append(hashes, hash)
+				}
+				idsByHash[hash] = append(idsByHash[hash], config.ID)
+			}
+			return nil
+		})
+	if tx.Error != nil {
+		if errors.Is(tx.Error, ErrDataDecryption) {
+			return nil, tx.Error
+		}
+		return nil, fmt.Errorf("failed to scan oidc client configs for duplicate secrets: %w", tx.Error)
+	}
+
+	var groups []DuplicateClientSecretGroup
+	for _, hash := range hashes {
+		if len(idsByHash[hash]) < 2 {
+			continue
+		}
+
+		groups = append(groups, DuplicateClientSecretGroup{
+			SecretHash: hash,
+			ConfigIDs:  idsByHash[hash],
+		})
+		if len(groups) >= limit {
+			break
+		}
+	}
+
+	return groups, nil
+}
@@ -0,0 +1,431 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type OIDCClientConfigAuditAction string
+
+const (
+	OIDCClientConfigAuditActionCreated     OIDCClientConfigAuditAction = "created"
+	OIDCClientConfigAuditActionUpdated     OIDCClientConfigAuditAction = "updated"
+	OIDCClientConfigAuditActionActivated   OIDCClientConfigAuditAction = "activated"
+	OIDCClientConfigAuditActionDeactivated OIDCClientConfigAuditAction = "deactivated"
+	OIDCClientConfigAuditActionDeleted     OIDCClientConfigAuditAction = "deleted"
+
+	// OIDCClientConfigAuditActionSecretAccessed records that the ClientSecret of a config was read.
+	OIDCClientConfigAuditActionSecretAccessed OIDCClientConfigAuditAction = "secret_accessed"
+)
+
+// oidcClientConfigChangeActions are the actions, which change a config.
+var oidcClientConfigChangeActions = []OIDCClientConfigAuditAction{
+	OIDCClientConfigAuditActionCreated,
+	OIDCClientConfigAuditActionUpdated,
+	OIDCClientConfigAuditActionActivated,
+	OIDCClientConfigAuditActionDeactivated,
+	OIDCClientConfigAuditActionDeleted,
+}
+
+// OIDCClientConfigAudit records an action of an actor on an OIDC Client Config.
+type OIDCClientConfigAudit struct {
+	ID uuid.UUID `gorm:"primary_key;column:id;type:char;size:36;" json:"id"`
+
+	OIDCClientConfigID uuid.UUID `gorm:"column:oidcClientConfigId;type:char;size:36;" json:"oidcClientConfigId"`
+	OrganizationID     uuid.UUID `gorm:"column:organizationId;type:char;size:36;" json:"organizationId"`
+	ActorID            uuid.UUID `gorm:"column:actorId;type:char;size:36;" json:"actorId"`
+
+	Action OIDCClientConfigAuditAction `gorm:"column:action;type:varchar;size:255;" json:"action"`
+
+	Timestamp time.Time `gorm:"column:timestamp;type:timestamp;default:CURRENT_TIMESTAMP(6);" json:"timestamp"`
+
+	LastModified time.Time `gorm:"->;column:_lastModified;type:timestamp;default:CURRENT_TIMESTAMP(6);" json:"_lastModified"`
+}
+
+func (a *OIDCClientConfigAudit) TableName() string {
+	return "d_b_oidc_client_config_audit"
+}
+
+// CreateOIDCClientConfigAudit persists the audit record. The ID is generated, and the Timestamp set to now, if unset.
+func CreateOIDCClientConfigAudit(ctx context.Context, conn *gorm.DB, record OIDCClientConfigAudit) (OIDCClientConfigAudit, error) {
+	problems := &ValidationError{}
+	if record.OIDCClientConfigID == uuid.Nil {
+		problems.add("oidcClientConfigId", "must be set")
+	}
+	if record.OrganizationID == uuid.Nil {
+		problems.add("organizationId", "must be set")
+	}
+	if record.ActorID == uuid.Nil {
+		problems.add("actorId", "must be set")
+	}
+	if record.Action == "" {
+		problems.add("action", "must be set")
+	}
+	if err := problems.errOrNil(); err != nil {
+		return OIDCClientConfigAudit{}, err
+	}
+
+	if record.ID == uuid.Nil {
+		record.ID = uuid.New()
+	}
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now().UTC()
+	}
+
+	tx := conn.
+		WithContext(ctx).
+		Create(&record)
+	if tx.Error != nil {
+		return OIDCClientConfigAudit{}, fmt.Errorf("failed to create oidc client config audit record: %w", tx.Error)
+	}
+
+	return record, nil
+}
+
+// ListOIDCClientConfigAudit returns a page of the audit records of the config, newest first.
+func ListOIDCClientConfigAudit(ctx context.Context, conn *gorm.DB, oidcClientConfigID uuid.UUID, pagination Pagination) (*PaginatedResult[OIDCClientConfigAudit], error) {
+	if oidcClientConfigID == uuid.Nil {
+		return nil, errors.New("OIDC Client Config ID is a required argument")
+	}
+
+	var results []OIDCClientConfigAudit
+
+	tx := conn.
+		WithContext(ctx).
+		Where("oidcClientConfigId = ?", oidcClientConfigID.String()).
+		Order("timestamp DESC").
+		Order("id DESC").
+		Scopes(Paginate(pagination)).
+		Find(&results)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to list audit records of oidc client config %s: %w", oidcClientConfigID.String(), tx.Error)
+	}
+
+	var count int64
+	tx = conn.
+		WithContext(ctx).
+		Model(&OIDCClientConfigAudit{}).
+		Where("oidcClientConfigId = ?", oidcClientConfigID.String()).
+		Count(&count)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to count audit records of oidc client config %s: %w", oidcClientConfigID.String(), tx.Error)
+	}
+
+	return &PaginatedResult[OIDCClientConfigAudit]{
+		Results: results,
+		Total:   count,
+	}, nil
+}
+
+// OIDCClientConfigAuditPage is a page of audit records, listed with a cursor.
+type OIDCClientConfigAuditPage struct {
+	Results []OIDCClientConfigAudit
+	// NextCursor lists the following page, it is empty on the last page.
+	NextCursor string
+}
+
+const defaultOIDCClientConfigAuditPageSize = 25
+
+// ListOIDCClientConfigAuditWithCursor returns a page of the audit records of the config, newest first, starting after
+// the cursor. An empty cursor starts at the newest record. Unlike the offset pagination of ListOIDCClientConfigAudit,
+// the (timestamp, id) cursor does not drift when records are added between pages, so none are skipped or repeated.
+func ListOIDCClientConfigAuditWithCursor(ctx context.Context, conn *gorm.DB, oidcClientConfigID uuid.UUID, cursor string, pageSize int) (OIDCClientConfigAuditPage, error) {
+	if oidcClientConfigID == uuid.Nil {
+		return OIDCClientConfigAuditPage{}, errors.New("OIDC Client Config ID is a required argument")
+	}
+	if pageSize <= 0 {
+		pageSize = defaultOIDCClientConfigAuditPageSize
+	}
+
+	query := conn.
+		WithContext(ctx).
+		Where("oidcClientConfigId = ?", oidcClientConfigID.String())
+
+	if cursor != "" {
+		timestamp, id, err := decodeOIDCClientConfigAuditCursor(cursor)
+		if err != nil {
+			problems := &ValidationError{}
+			problems.add("cursor", "is invalid")
+			return OIDCClientConfigAuditPage{}, problems.errOrNil()
+		}
+		query = query.Where("(timestamp < ? OR (timestamp = ? AND id < ?))", timestamp, timestamp, id.String())
+	}
+
+	// one more than requested tells whether a following page exists
+	var results []OIDCClientConfigAudit
+	tx := query.
+		Order("timestamp DESC").
+		Order("id DESC").
+		Limit(pageSize + 1).
+		Find(&results)
+	if tx.Error != nil {
+		return OIDCClientConfigAuditPage{}, fmt.Errorf("failed to list audit records of oidc client config %s: %w", oidcClientConfigID.String(), tx.Error)
+	}
+
+	page := OIDCClientConfigAuditPage{Results: results}
+	if len(results) > pageSize {
+		page.Results = results[:pageSize]
+		last := page.Results[pageSize-1]
+		page.NextCursor = encodeOIDCClientConfigAuditCursor(last.Timestamp, last.ID)
+	}
+
+	return page, nil
+}
+
+func encodeOIDCClientConfigAuditCursor(timestamp time.Time, id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(timestamp.UTC().Format(time.RFC3339Nano) + "|" + id.String()))
+}
+
+func decodeOIDCClientConfigAuditCursor(cursor string) (time.Time, uuid.UUID, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+
+	rawTimestamp, rawID, found := strings.Cut(string(decoded), "|")
+	if !found {
+		return time.Time{}, uuid.Nil, errors.New("cursor has no id")
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, rawTimestamp)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+
+	return timestamp.UTC(), id, nil
+}
+
+// ListOIDCConfigChangesByActor returns a page of the audit records of changes the actor made to any config since the
+// given time, newest first. Accesses which do not change a config, like reading its secret, are not included.
+// Changes are recorded by the WithAudit variants of the functions changing a config.
+func ListOIDCConfigChangesByActor(ctx context.Context, conn *gorm.DB, actorID uuid.UUID, since time.Time, pagination Pagination) (*PaginatedResult[OIDCClientConfigAudit], error) {
+	if actorID == uuid.Nil {
+		return nil, errors.New("actor ID is a required argument")
+	}
+
+	query := func() *gorm.DB {
+		return conn.
+			WithContext(ctx).
+			Model(&OIDCClientConfigAudit{}).
+			Where("actorId = ?", actorID.String()).
+			Where("timestamp >= ?", since.UTC()).
+			Where("action IN ?", oidcClientConfigChangeActions)
+	}
+
+	var results []OIDCClientConfigAudit
+	tx := query().
+		Order("timestamp DESC").
+		Order("id DESC").
+		Scopes(Paginate(pagination)).
+		Find(&results)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to list oidc client config changes by actor %s: %w", actorID.String(), tx.Error)
+	}
+
+	var count int64
+	tx = query().Count(&count)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to count oidc client config changes by actor %s: %w", actorID.String(), tx.Error)
+	}
+
+	return &PaginatedResult[OIDCClientConfigAudit]{
+		Results: results,
+		Total:   count,
+	}, nil
+}
+
+type OIDCClientConfigTimeline struct {
+	// Config is the current state of the config. It is redacted: Data, which holds the encrypted spec, is removed.
+	Config OIDCClientConfig
+	// Events are the audit records of the config, oldest first.
+	Events []OIDCClientConfigAudit
+}
+
+// GetOIDCClientConfigTimeline returns the current state of the config together with all of its audit records, as written
+// by GetOIDCSecretWithAudit and the WithAudit variants of the functions changing a config.
+// Returns ErrorNotFound when the config does not exist, or is deleted.
+func GetOIDCClientConfigTimeline(ctx context.Context, conn *gorm.DB, id uuid.UUID) (OIDCClientConfigTimeline, error) {
+	config, err := GetOIDCClientConfig(ctx, conn, id)
+	if err != nil {
+		return OIDCClientConfigTimeline{}, err
+	}
+	config.Data = nil
+
+	var events []OIDCClientConfigAudit
+	tx := conn.
+		WithContext(ctx).
+		Where("oidcClientConfigId = ?", id.String()).
+		Order("timestamp ASC").
+		Order("id ASC").
+		Find(&events)
+	if tx.Error != nil {
+		return OIDCClientConfigTimeline{}, fmt.Errorf("failed to list audit records of oidc client config %s: %w", id.String(), tx.Error)
+	}
+
+	return OIDCClientConfigTimeline{
+		Config: config,
+		Events: events,
+	}, nil
+}
+
+// GetOIDCSecretWithAudit returns the decrypted ClientSecret of the config of the organization, and records the access
+// by the actor. The record is written in the same transaction: the secret is only returned once its access is recorded,
+// and no access is recorded when the secret cannot be returned.
+func GetOIDCSecretWithAudit(ctx context.Context, conn *gorm.DB, decryptor Decryptor, id, organizationID, actorID uuid.UUID) (string, error) {
+	if actorID == uuid.Nil {
+		return "", errors.New("actor ID is a required argument")
+	}
+
+	var secret string
+	err := conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		_, spec, err := GetDecodedOIDCClientConfigForOrganization(ctx, tx, decryptor, id, organizationID)
+		if err != nil {
+			return err
+		}
+
+		err = recordOIDCClientConfigAudit(ctx, tx, id, organizationID, actorID, OIDCClientConfigAuditActionSecretAccessed)
+		if err != nil {
+			return err
+		}
+
+		secret = spec.ClientSecret
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// CreateOIDCClientConfigWithAudit creates the config like CreateOIDCClientConfig, and records its creation by the actor.
+// A config created as active also records its activation. The records are written in the same transaction as the config.
+func CreateOIDCClientConfigWithAudit(ctx context.Context, conn *gorm.DB, cipher Cipher, cfg OIDCClientConfig, maxPerOrg int, actorID uuid.UUID) (OIDCClientConfig, error) {
+	if actorID == uuid.Nil {
+		return OIDCClientConfig{}, errors.New("actor ID is a required argument")
+	}
+
+	var created OIDCClientConfig
+	err := conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		created, err = CreateOIDCClientConfig(ctx, tx, cipher, cfg, maxPerOrg)
+		if err != nil {
+			return err
+		}
+
+		err = recordOIDCClientConfigAudit(ctx, tx, created.ID, created.OrganizationID, actorID, OIDCClientConfigAuditActionCreated)
+		if err != nil {
+			return err
+		}
+		if created.Active {
+			return recordOIDCClientConfigAudit(ctx, tx, created.ID, created.OrganizationID, actorID, OIDCClientConfigAuditActionActivated)
+		}
+		return nil
+	})
+	if err != nil {
+		return OIDCClientConfig{}, err
+	}
+
+	return created, nil
+}
+
+// UpdateOIDCClientConfigWithAudit updates the config like UpdateOIDCClientConfig, and records the update by the actor in
+// the same transaction.
+func UpdateOIDCClientConfigWithAudit(ctx context.Context, conn *gorm.DB, cipher Cipher, id, organizationID uuid.UUID, issuer string, spec OIDCSpec, actorID uuid.UUID) (OIDCClientConfig, error) {
+	if actorID == uuid.Nil {
+		return OIDCClientConfig{}, errors.New("actor ID is a required argument")
+	}
+
+	var updated OIDCClientConfig
+	err := conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		updated, err = UpdateOIDCClientConfig(ctx, tx, cipher, id, organizationID, issuer, spec)
+		if err != nil {
+			return err
+		}
+
+		return recordOIDCClientConfigAudit(ctx, tx, id, organizationID, actorID, OIDCClientConfigAuditActionUpdated)
+	})
+	if err != nil {
+		return OIDCClientConfig{}, err
+	}
+
+	return updated, nil
+}
+
+// ActivateOIDCClientConfigWithAudit makes the config the only active config of the organization, like
+// SwapActiveOIDCClientConfig. The actor is recorded for the activation, and for the deactivation of each config which
+// was active before, in the same transaction.
+func ActivateOIDCClientConfigWithAudit(ctx context.Context, conn *gorm.DB, id, organizationID, actorID uuid.UUID) error {
+	if actorID == uuid.Nil {
+		return errors.New("actor ID is a required argument")
+	}
+
+	return conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var deactivated []OIDCClientConfig
+		result := tx.
+			Where("organizationId = ?", organizationID.String()).
+			Where("id <> ?", id.String()).
+			Where("active = ?", 1).
+			Where("deleted = ?", 0).
+			Find(&deactivated)
+		if result.Error != nil {
+			return fmt.Errorf("failed to list active oidc client configs for organization %s: %w", organizationID.String(), result.Error)
+		}
+
+		err := activateOIDCClientConfigForOrganization(ctx, tx, id, organizationID)
+		if err != nil {
+			return err
+		}
+
+		for _, config := range deactivated {
+			err = recordOIDCClientConfigAudit(ctx, tx, config.ID, organizationID, actorID, OIDCClientConfigAuditActionDeactivated)
+			if err != nil {
+				return err
+			}
+		}
+		return recordOIDCClientConfigAudit(ctx, tx, id, organizationID, actorID, OIDCClientConfigAuditActionActivated)
+	})
+}
+
+// DeleteOIDCClientConfigWithAudit deletes the config like DeleteOIDCClientConfig, and records the deletion by the actor
+// in the same transaction.
+func DeleteOIDCClientConfigWithAudit(ctx context.Context, conn *gorm.DB, id, organizationID, actorID uuid.UUID) error {
+	if actorID == uuid.Nil {
+		return errors.New("actor ID is a required argument")
+	}
+
+	return conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := DeleteOIDCClientConfig(ctx, tx, id, organizationID)
+		if err != nil {
+			return err
+		}
+
+		return recordOIDCClientConfigAudit(ctx, tx, id, organizationID, actorID, OIDCClientConfigAuditActionDeleted)
+	})
+}
+
+func recordOIDCClientConfigAudit(ctx context.Context, conn *gorm.DB, id, organizationID, actorID uuid.UUID, action OIDCClientConfigAuditAction) error {
+	_, err := CreateOIDCClientConfigAudit(ctx, conn, OIDCClientConfigAudit{
+		OIDCClientConfigID: id,
+		OrganizationID:     organizationID,
+		ActorID:            actorID,
+		Action:             action,
+	})
+	return err
+}
@@ -0,0 +1,299 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package db_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	db "github.com/gitpod-io/gitpod/components/gitpod-db/go"
+	"github.com/gitpod-io/gitpod/components/gitpod-db/go/dbtest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOIDCSecretWithAudit(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+
+	data, err := db.EncryptJSON(cipher, db.OIDCSpec{ClientID: "client-id", ClientSecret: "client-secret"})
+	require.NoError(t, err)
+	config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: uuid.New(), Data: data})[0]
+	t.Cleanup(func() {
+		dbtest.HardDeleteOIDCClientConfigAudits(t, config.ID.String())
+	})
+
+	actorID := uuid.New()
+
+	t.Run("no secret and no record on failure", func(t *testing.T) {
+		secret, err := db.GetOIDCSecretWithAudit(ctx, conn, cipher, config.ID, uuid.New(), actorID)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+		require.Empty(t, secret)
+
+		audit, err := db.ListOIDCClientConfigAudit(ctx, conn, config.ID, db.Pagination{})
+		require.NoError(t, err)
+		require.Empty(t, audit.Results)
+	})
+
+	t.Run("records the access", func(t *testing.T) {
+		before := time.Now().UTC().Add(-time.Second)
+
+		secret, err := db.GetOIDCSecretWithAudit(ctx, conn, cipher, config.ID, config.OrganizationID, actorID)
+		require.NoError(t, err)
+		require.Equal(t, "client-secret", secret)
+
+		audit, err := db.ListOIDCClientConfigAudit(ctx, conn, config.ID, db.Pagination{})
+		require.NoError(t, err)
+		require.EqualValues(t, 1, audit.Total)
+		require.Len(t, audit.Results, 1)
+
+		record := audit.Results[0]
+		require.Equal(t, config.ID, record.OIDCClientConfigID)
+		require.Equal(t, config.OrganizationID, record.OrganizationID)
+		require.Equal(t, actorID, record.ActorID)
+		require.Equal(t, db.OIDCClientConfigAuditActionSecretAccessed, record.Action)
+		require.True(t, record.Timestamp.After(before))
+	})
+}
+
+func TestListOIDCClientConfigAuditWithCursor(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	configID, orgID := uuid.New(), uuid.New()
+	t.Cleanup(func() {
+		dbtest.HardDeleteOIDCClientConfigAudits(t, configID.String())
+	})
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	record := func(at time.Time) db.OIDCClientConfigAudit {
+		created, err := db.CreateOIDCClientConfigAudit(ctx, conn, db.OIDCClientConfigAudit{
+			OIDCClientConfigID: configID,
+			OrganizationID:     orgID,
+			ActorID:            uuid.New(),
+			Action:             db.OIDCClientConfigAuditActionUpdated,
+			Timestamp:          at,
+		})
+		require.NoError(t, err)
+		return created
+	}
+
+	var existing []db.OIDCClientConfigAudit
+	for i := 1; i <= 4; i++ {
+		existing = append(existing, record(now.Add(-time.Duration(i)*time.Minute)))
+	}
+	// records at the same time are ordered by their ID
+	existing = append(existing, record(now.Add(-3*time.Minute)))
+
+	t.Run("pages are stable while records are added", func(t *testing.T) {
+		first, err := db.ListOIDCClientConfigAuditWithCursor(ctx, conn, configID, "", 2)
+		require.NoError(t, err)
+		require.Len(t, first.Results, 2)
+		require.NotEmpty(t, first.NextCursor)
+
+		// a newer record lands before the cursor, an older one after it
+		record(now)
+		older := record(now.Add(-time.Hour))
+
+		listed := first.Results
+		cursor := first.NextCursor
+		for cursor != "" {
+			page, err := db.ListOIDCClientConfigAuditWithCursor(ctx, conn, configID, cursor, 2)
+			require.NoError(t, err)
+			listed = append(listed, page.Results...)
+			cursor = page.NextCursor
+		}
+
+		expected := append(append([]db.OIDCClientConfigAudit{}, existing...), older)
+		sort.Slice(expected, func(i, j int) bool {
+			if !expected[i].Timestamp.Equal(expected[j].Timestamp) {
+				return expected[i].Timestamp.After(expected[j].Timestamp)
+			}
+			return expected[i].ID.String() > expected[j].ID.String()
+		})
+
+		var expectedIDs, listedIDs []uuid.UUID
+		for _, r := range expected {
+			expectedIDs = append(expectedIDs, r.ID)
+		}
+		for _, r := range listed {
+			listedIDs = append(listedIDs, r.ID)
+		}
+		require.Equal(t, expectedIDs, listedIDs)
+	})
+
+	t.Run("last page has no next cursor", func(t *testing.T) {
+		page, err := db.ListOIDCClientConfigAuditWithCursor(ctx, conn, configID, "", 100)
+		require.NoError(t, err)
+		require.Len(t, page.Results, 7)
+		require.Empty(t, page.NextCursor)
+	})
+
+	t.Run("rejects invalid cursor", func(t *testing.T) {
+		_, err := db.ListOIDCClientConfigAuditWithCursor(ctx, conn, configID, "not-a-cursor", 2)
+		var validationErr *db.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+}
+
+func TestListOIDCConfigChangesByActor(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	configID, orgID := uuid.New(), uuid.New()
+	t.Cleanup(func() {
+		dbtest.HardDeleteOIDCClientConfigAudits(t, configID.String())
+	})
+
+	actorID, otherActorID := uuid.New(), uuid.New()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	record := func(actorID uuid.UUID, action db.OIDCClientConfigAuditAction, at time.Time) db.OIDCClientConfigAudit {
+		created, err := db.CreateOIDCClientConfigAudit(ctx, conn, db.OIDCClientConfigAudit{
+			OIDCClientConfigID: configID,
+			OrganizationID:     orgID,
+			ActorID:            actorID,
+			Action:             action,
+			Timestamp:          at,
+		})
+		require.NoError(t, err)
+		return created
+	}
+
+	// before the time window
+	record(actorID, db.OIDCClientConfigAuditActionCreated, now.Add(-2*time.Hour))
+	updated := record(actorID, db.OIDCClientConfigAuditActionUpdated, now.Add(-30*time.Minute))
+	activated := record(actorID, db.OIDCClientConfigAuditActionActivated, now.Add(-10*time.Minute))
+	// not a change
+	record(actorID, db.OIDCClientConfigAuditActionSecretAccessed, now.Add(-5*time.Minute))
+	// another actor
+	record(otherActorID, db.OIDCClientConfigAuditActionDeleted, now.Add(-1*time.Minute))
+
+	since := now.Add(-time.Hour)
+
+	changes, err := db.ListOIDCConfigChangesByActor(ctx, conn, actorID, since, db.Pagination{})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, changes.Total)
+	require.Len(t, changes.Results, 2)
+	require.Equal(t, activated.ID, changes.Results[0].ID, "newest first")
+	require.Equal(t, updated.ID, changes.Results[1].ID)
+
+	page, err := db.ListOIDCConfigChangesByActor(ctx, conn, actorID, since, db.Pagination{Page: 2, PageSize: 1})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, page.Total)
+	require.Len(t, page.Results, 1)
+	require.Equal(t, updated.ID, page.Results[0].ID)
+}
+
+func TestGetOIDCClientConfigTimeline(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+
+	actorID := uuid.New()
+	config, err := db.CreateOIDCClientConfigWithAudit(ctx, conn, cipher, dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New()}), 0, actorID)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		dbtest.HardDeleteOIDCClientConfigs(t, config.ID.String())
+		dbtest.HardDeleteOIDCClientConfigAudits(t, config.ID.String())
+	})
+
+	_, err = db.UpdateOIDCClientConfigWithAudit(ctx, conn, cipher, config.ID, config.OrganizationID, "https://accounts.google.com", db.OIDCSpec{ClientID: "client-id"}, actorID)
+	require.NoError(t, err)
+	require.NoError(t, db.ActivateOIDCClientConfigWithAudit(ctx, conn, config.ID, config.OrganizationID, actorID))
+
+	timeline, err := db.GetOIDCClientConfigTimeline(ctx, conn, config.ID)
+	require.NoError(t, err)
+	require.Equal(t, config.ID, timeline.Config.ID)
+	require.Nil(t, timeline.Config.Data, "config must be redacted")
+
+	var actions []db.OIDCClientConfigAuditAction
+	for _, event := range timeline.Events {
+		require.Equal(t, actorID, event.ActorID)
+		actions = append(actions, event.Action)
+	}
+	require.Equal(t, []db.OIDCClientConfigAuditAction{
+		db.OIDCClientConfigAuditActionCreated,
+		db.OIDCClientConfigAuditActionUpdated,
+		db.OIDCClientConfigAuditActionActivated,
+	}, actions)
+
+	_, err = db.GetOIDCClientConfigTimeline(ctx, conn, uuid.New())
+	require.ErrorIs(t, err, db.ErrorNotFound)
+}
+
+func TestOIDCClientConfigChangesWithAudit(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+
+	orgID := uuid.New()
+	actorID := uuid.New()
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Active: true}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+	)
+	active, target := configs[0], configs[1]
+	t.Cleanup(func() {
+		dbtest.HardDeleteOIDCClientConfigAudits(t, active.ID.String(), target.ID.String())
+	})
+
+	actions := func(t *testing.T, id uuid.UUID) []db.OIDCClientConfigAuditAction {
+		t.Helper()
+
+		audit, err := db.ListOIDCClientConfigAudit(ctx, conn, id, db.Pagination{})
+		require.NoError(t, err)
+		var result []db.OIDCClientConfigAuditAction
+		for _, record := range audit.Results {
+			require.Equal(t, actorID, record.ActorID)
+			result = append(result, record.Action)
+		}
+		return result
+	}
+
+	t.Run("requires an actor", func(t *testing.T) {
+		require.Error(t, db.ActivateOIDCClientConfigWithAudit(ctx, conn, target.ID, orgID, uuid.Nil))
+		require.Error(t, db.DeleteOIDCClientConfigWithAudit(ctx, conn, target.ID, orgID, uuid.Nil))
+	})
+
+	t.Run("records nothing when the change fails", func(t *testing.T) {
+		require.ErrorIs(t, db.DeleteOIDCClientConfigWithAudit(ctx, conn, target.ID, uuid.New(), actorID), db.ErrorNotFound)
+		require.Empty(t, actions(t, target.ID))
+	})
+
+	t.Run("records the activation and the deactivation of the previously active config", func(t *testing.T) {
+		require.NoError(t, db.ActivateOIDCClientConfigWithAudit(ctx, conn, target.ID, orgID, actorID))
+		require.Equal(t, []db.OIDCClientConfigAuditAction{db.OIDCClientConfigAuditActionActivated}, actions(t, target.ID))
+		require.Equal(t, []db.OIDCClientConfigAuditAction{db.OIDCClientConfigAuditActionDeactivated}, actions(t, active.ID))
+	})
+
+	t.Run("records the deletion", func(t *testing.T) {
+		require.NoError(t, db.DeleteOIDCClientConfigWithAudit(ctx, conn, active.ID, orgID, actorID))
+		require.Equal(t, []db.OIDCClientConfigAuditAction{
+			db.OIDCClientConfigAuditActionDeleted,
+			db.OIDCClientConfigAuditActionDeactivated,
+		}, actions(t, active.ID))
+
+		changes, err := db.ListOIDCConfigChangesByActor(ctx, conn, actorID, time.Now().UTC().Add(-time.Hour), db.Pagination{})
+		require.NoError(t, err)
+		require.EqualValues(t, 3, changes.Total)
+	})
+
+	t.Run("records the creation and activation of an active config", func(t *testing.T) {
+		created, err := db.CreateOIDCClientConfigWithAudit(ctx, conn, cipher, dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Active: true}), 0, actorID)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			dbtest.HardDeleteOIDCClientConfigs(t, created.ID.String())
+			dbtest.HardDeleteOIDCClientConfigAudits(t, created.ID.String())
+		})
+
+		require.ElementsMatch(t, []db.OIDCClientConfigAuditAction{
+			db.OIDCClientConfigAuditActionCreated,
+			db.OIDCClientConfigAuditActionActivated,
+		}, actions(t, created.ID))
+	})
+}
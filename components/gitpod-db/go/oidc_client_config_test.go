@@ -6,12 +6,24 @@ package db_test
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	db "github.com/gitpod-io/gitpod/components/gitpod-db/go"
 	"github.com/gitpod-io/gitpod/components/gitpod-db/go/dbtest"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+	"gorm.io/gorm"
 )
 
 func TestCreateOIDCClientConfig_Create(t *testing.T) {
@@ -23,126 +35,2397 @@ func TestCreateOIDCClientConfig_Create(t *testing.T) {
 	require.Equal(t, created, retrieved)
 }
 
+func TestCreateOIDCClientConfig_RolledBackWithOuterTransaction(t *testing.T) {
+	conn := dbtest.ConnectForTests(t)
+	config := dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{
+		OrganizationID: uuid.New(),
+	})
+	t.Cleanup(func() {
+		dbtest.HardDeleteOIDCClientConfigs(t, config.ID.String())
+	})
+
+	errRollback := errors.New("rollback")
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		_, err := db.CreateOIDCClientConfig(context.Background(), tx, dbtest.CipherSet(t), config, 0)
+		require.NoError(t, err)
+
+		// the insert is visible within the transaction
+		_, err = db.GetOIDCClientConfig(context.Background(), tx, config.ID)
+		require.NoError(t, err)
+
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+
+	_, err = db.GetOIDCClientConfig(context.Background(), conn, config.ID)
+	require.ErrorIs(t, err, db.ErrorNotFound)
+}
+
+func TestCreateOIDCClientConfig_PublicID(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	t.Run("generated unique and url-safe", func(t *testing.T) {
+		configs := dbtest.CreateOIDCClientConfigs(t, conn,
+			db.OIDCClientConfig{},
+			db.OIDCClientConfig{},
+			db.OIDCClientConfig{},
+		)
+
+		seen := map[string]bool{}
+		for _, config := range configs {
+			require.Len(t, config.PublicID, 16)
+			require.Regexp(t, "^[A-Za-z0-9_-]+$", config.PublicID)
+			require.False(t, seen[config.PublicID], "public ids must be unique")
+			seen[config.PublicID] = true
+
+			retrieved, err := db.GetOIDCClientConfig(ctx, conn, config.ID)
+			require.NoError(t, err)
+			require.Equal(t, config.PublicID, retrieved.PublicID)
+		}
+	})
+
+	t.Run("kept when set", func(t *testing.T) {
+		publicID := strings.ReplaceAll(uuid.New().String(), "-", "")[:16]
+		config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{PublicID: publicID})[0]
+		require.Equal(t, publicID, config.PublicID)
+	})
+
+	t.Run("rejected when not url-safe", func(t *testing.T) {
+		_, err := db.CreateOIDCClientConfig(ctx, conn, dbtest.CipherSet(t), dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{PublicID: "not/url safe"}), 0)
+
+		var validationErr *db.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+}
+
+func TestGetOIDCClientConfigByPublicID(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New()}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New()}),
+	)
+	config, deleted := configs[0], configs[1]
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, deleted.ID, deleted.OrganizationID))
+
+	retrieved, err := db.GetOIDCClientConfigByPublicID(ctx, conn, config.PublicID)
+	require.NoError(t, err)
+	require.Equal(t, config.ID, retrieved.ID)
+
+	_, err = db.GetOIDCClientConfigByPublicID(ctx, conn, "unknown")
+	require.ErrorIs(t, err, db.ErrorNotFound)
+
+	_, err = db.GetOIDCClientConfigByPublicID(ctx, conn, deleted.PublicID)
+	require.ErrorIs(t, err, db.ErrorNotFound)
+
+	_, err = db.GetOIDCClientConfigByPublicID(ctx, conn, "")
+	require.Error(t, err)
+}
+
+func TestCreateOIDCClientConfig_MaxPerOrg(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	orgID := uuid.New()
+
+	// create returns the config to create, which is only stored if there is no error
+	create := func(conn *gorm.DB) (db.OIDCClientConfig, error) {
+		config := dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID})
+		t.Cleanup(func() {
+			dbtest.HardDeleteOIDCClientConfigs(t, config.ID.String())
+		})
+		_, err := db.CreateOIDCClientConfig(ctx, conn, dbtest.CipherSet(t), config, 2)
+		return config, err
+	}
+
+	first, err := create(conn)
+	require.NoError(t, err)
+
+	// the limit applies to configs created earlier within the same transaction
+	err = conn.Transaction(func(tx *gorm.DB) error {
+		if _, err := create(tx); err != nil {
+			return err
+		}
+		_, err := create(tx)
+		return err
+	})
+	require.ErrorIs(t, err, db.ErrConfigLimitReached)
+
+	configs, err := db.ListOIDCClientConfigsForOrganization(ctx, conn, orgID)
+	require.NoError(t, err)
+	require.Len(t, configs, 1, "the failed transaction must be rolled back")
+
+	_, err = create(conn)
+	require.NoError(t, err)
+
+	rejected, err := create(conn)
+	require.ErrorIs(t, err, db.ErrConfigLimitReached)
+
+	_, err = db.GetOIDCClientConfig(ctx, conn, rejected.ID)
+	require.ErrorIs(t, err, db.ErrorNotFound, "the rejected config must not be stored")
+
+	// deleted configs do not count
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, first.ID, orgID))
+	_, err = create(conn)
+	require.NoError(t, err)
+
+	_, err = db.CreateOIDCClientConfig(ctx, conn, dbtest.CipherSet(t), dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}), -1)
+	require.Error(t, err)
+}
+
+func TestCreateOIDCClientConfig_RedirectURLConflict(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	orgID := uuid.New()
+
+	// create returns the config to create, which is only stored if there is no error
+	create := func(redirectURL string) (db.OIDCClientConfig, error) {
+		data, err := db.EncryptJSON(cipher, db.OIDCSpec{RedirectURL: redirectURL})
+		require.NoError(t, err)
+		config := dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Data: data})
+		t.Cleanup(func() {
+			dbtest.HardDeleteOIDCClientConfigs(t, config.ID.String())
+		})
+		_, err = db.CreateOIDCClientConfig(ctx, conn, cipher, config, 0)
+		return config, err
+	}
+
+	_, err := create("https://gitpod.io/iam/oidc/callback")
+	require.NoError(t, err)
+
+	rejected, err := create("HTTPS://Gitpod.IO:443/iam/oidc/callback/")
+	require.ErrorIs(t, err, db.ErrRedirectURLConflict)
+
+	_, err = db.GetOIDCClientConfig(ctx, conn, rejected.ID)
+	require.ErrorIs(t, err, db.ErrorNotFound, "the rejected config must not be stored")
+
+	_, err = create("https://gitpod.io/iam/oidc/other-callback")
+	require.NoError(t, err)
+}
+
+func TestCreateOIDCClientConfigWithHook(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	t.Run("commits the config with the hook", func(t *testing.T) {
+		config := dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New()})
+		t.Cleanup(func() {
+			dbtest.HardDeleteOIDCClientConfigs(t, config.ID.String())
+		})
+
+		var hooked db.OIDCClientConfig
+		created, err := db.CreateOIDCClientConfigWithHook(ctx, conn, dbtest.CipherSet(t), config, func(tx *gorm.DB, created db.OIDCClientConfig) error {
+			hooked = created
+
+			// the insert is visible to the hook
+			_, err := db.GetOIDCClientConfig(ctx, tx, created.ID)
+			return err
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, created.PublicID)
+		config.PublicID = created.PublicID
+		require.Equal(t, config, created)
+		require.Equal(t, created, hooked)
+
+		_, err = db.GetOIDCClientConfig(ctx, conn, config.ID)
+		require.NoError(t, err)
+	})
+
+	t.Run("hook error rolls back the insert", func(t *testing.T) {
+		config := dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New()})
+		t.Cleanup(func() {
+			dbtest.HardDeleteOIDCClientConfigs(t, config.ID.String())
+		})
+
+		errHook := errors.New("hook failed")
+		_, err := db.CreateOIDCClientConfigWithHook(ctx, conn, dbtest.CipherSet(t), config, func(tx *gorm.DB, created db.OIDCClientConfig) error {
+			return errHook
+		})
+		require.ErrorIs(t, err, errHook)
+
+		_, err = db.GetOIDCClientConfig(ctx, conn, config.ID)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+	})
+}
+
+func TestCreateOIDCClientConfigAutoID(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	orgID := uuid.New()
+
+	spec := db.OIDCSpec{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://gitpod.io/iam/oidc/callback",
+		Scopes:       []string{"openid"},
+	}
+
+	created, err := db.CreateOIDCClientConfigAutoID(ctx, conn, cipher, orgID, "https://accounts.google.com", spec)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		dbtest.HardDeleteOIDCClientConfigs(t, created.ID.String())
+	})
+	require.NotEqual(t, uuid.Nil, created.ID)
+	require.False(t, created.Active)
+
+	retrieved, decoded, err := db.GetDecodedOIDCClientConfigForOrganization(ctx, conn, cipher, created.ID, orgID)
+	require.NoError(t, err)
+	require.Equal(t, created.ID, retrieved.ID)
+	require.Equal(t, "https://accounts.google.com", retrieved.Issuer)
+	require.False(t, retrieved.Active)
+
+	// PKCE is enabled by default for new configs
+	usePKCE := true
+	expected := spec
+	expected.UsePKCE = &usePKCE
+	require.Equal(t, expected, decoded)
+
+	_, err = db.CreateOIDCClientConfigAutoID(ctx, conn, cipher, orgID, "https://accounts.google.com", spec)
+	require.ErrorIs(t, err, db.ErrRedirectURLConflict)
+}
+
+func TestCreateOIDCClientConfigAutoID_PKCEDefaultsToResponseType(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	orgID := uuid.New()
+
+	created, err := db.CreateOIDCClientConfigAutoID(ctx, conn, cipher, orgID, "https://accounts.google.com", db.OIDCSpec{
+		ClientID:     "client-id",
+		ResponseType: "id_token",
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		dbtest.HardDeleteOIDCClientConfigs(t, created.ID.String())
+	})
+
+	decoded, err := db.DecodeSpec(cipher, created)
+	require.NoError(t, err)
+	require.NotNil(t, decoded.UsePKCE)
+	require.False(t, decoded.PKCEEnabled(), "implicit flows do not issue a code PKCE could protect")
+}
+
+func TestCreateOIDCClientConfig_PKCEDefault(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	disabled := false
+
+	for name, s := range map[string]struct {
+		Spec     db.OIDCSpec
+		Expected bool
+	}{
+		"enabled when unset":          {Spec: db.OIDCSpec{ClientID: "client-id"}, Expected: true},
+		"disabled for implicit flows": {Spec: db.OIDCSpec{ClientID: "client-id", ResponseType: "id_token"}, Expected: false},
+		"explicitly disabled":         {Spec: db.OIDCSpec{ClientID: "client-id", UsePKCE: &disabled}, Expected: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := db.EncryptJSON(cipher, s.Spec)
+			require.NoError(t, err)
+			config := dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Data: data})
+			t.Cleanup(func() {
+				dbtest.HardDeleteOIDCClientConfigs(t, config.ID.String())
+			})
+
+			created, err := db.CreateOIDCClientConfig(ctx, conn, cipher, config, 0)
+			require.NoError(t, err)
+
+			retrieved, err := db.GetOIDCClientConfig(ctx, conn, created.ID)
+			require.NoError(t, err)
+			decoded, err := db.DecodeSpec(cipher, retrieved)
+			require.NoError(t, err)
+			require.NotNil(t, decoded.UsePKCE, "the default is stored with the config")
+			require.Equal(t, s.Expected, *decoded.UsePKCE)
+		})
+	}
+}
+
+func TestOIDCSpec_UsePKCE(t *testing.T) {
+	cipher := dbtest.CipherSet(t)
+	enabled := true
+
+	t.Run("round trips", func(t *testing.T) {
+		encrypted, err := db.EncryptJSON(cipher, db.OIDCSpec{ClientID: "client-id", UsePKCE: &enabled})
+		require.NoError(t, err)
+
+		decrypted, err := encrypted.Decrypt(cipher)
+		require.NoError(t, err)
+		require.True(t, decrypted.PKCEEnabled())
+	})
+
+	t.Run("decodes as unset when absent", func(t *testing.T) {
+		var spec db.OIDCSpec
+		require.NoError(t, json.Unmarshal([]byte(`{"clientId":"client-id"}`), &spec))
+		require.Nil(t, spec.UsePKCE)
+		require.False(t, spec.PKCEEnabled())
+	})
+
+	t.Run("requires a response type including code", func(t *testing.T) {
+		require.NoError(t, db.ValidateOIDCSpec(db.OIDCSpec{UsePKCE: &enabled}))
+		require.NoError(t, db.ValidateOIDCSpec(db.OIDCSpec{UsePKCE: &enabled, ResponseType: "code id_token"}))
+
+		err := db.ValidateOIDCSpec(db.OIDCSpec{UsePKCE: &enabled, ResponseType: "id_token"})
+		var validationErr *db.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+		require.Equal(t, "usePKCE", validationErr.Fields[0].Field)
+	})
+}
+
+func TestEnablePKCEForExistingOIDCClientConfigs(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	orgID := uuid.New()
+
+	disabled := false
+	specs := []db.OIDCSpec{
+		{ClientID: "client-id"},
+		{ClientID: "client-id", ResponseType: "id_token"},
+		{ClientID: "client-id", UsePKCE: &disabled},
+	}
+
+	// configs stored before UsePKCE existed leave it unset, which CreateOIDCClientConfig no longer does
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+	)
+	for i, spec := range specs {
+		data, err := db.EncryptJSON(cipher, spec)
+		require.NoError(t, err)
+		require.NoError(t, conn.Model(&db.OIDCClientConfig{}).Where("id = ?", configs[i].ID.String()).Update("data", data).Error)
+	}
+
+	_, err := db.EnablePKCEForExistingOIDCClientConfigs(ctx, conn, cipher, 10)
+	require.NoError(t, err)
+
+	for _, expected := range []struct {
+		ID      uuid.UUID
+		UsePKCE bool
+	}{
+		{ID: configs[0].ID, UsePKCE: true},
+		{ID: configs[1].ID, UsePKCE: false},
+		{ID: configs[2].ID, UsePKCE: false},
+	} {
+		_, spec, err := db.GetDecodedOIDCClientConfigForOrganization(ctx, conn, cipher, expected.ID, orgID)
+		require.NoError(t, err)
+		require.Equal(t, expected.UsePKCE, spec.PKCEEnabled())
+	}
+
+	// re-running does not change configs again
+	_, err = db.EnablePKCEForExistingOIDCClientConfigs(ctx, conn, cipher, 10)
+	require.NoError(t, err)
+}
+
+func TestCreateOIDCClientConfig_ReportsAllValidationProblems(t *testing.T) {
+	conn := dbtest.ConnectForTests(t)
+
+	_, err := db.CreateOIDCClientConfig(context.Background(), conn, dbtest.CipherSet(t), db.OIDCClientConfig{}, 0)
+
+	var validationErr *db.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Equal(t, []db.FieldError{
+		{Field: "id", Message: "must be set"},
+		{Field: "issuer", Message: "must be set"},
+		{Field: "data", Message: "must be set"},
+	}, validationErr.Fields)
+}
+
+func TestCreateOIDCClientConfig_ReportsSpecValidationProblems(t *testing.T) {
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+
+	metadata := map[string]string{}
+	for i := 0; i <= db.MaxOIDCSpecMetadataKeys; i++ {
+		metadata[fmt.Sprintf("key-%d", i)] = "value"
+	}
+	data, err := db.EncryptJSON(cipher, db.OIDCSpec{Metadata: metadata})
+	require.NoError(t, err)
+
+	_, err = db.CreateOIDCClientConfig(context.Background(), conn, cipher, db.OIDCClientConfig{
+		ID:             uuid.New(),
+		OrganizationID: uuid.New(),
+		Data:           data,
+	}, 0)
+
+	var validationErr *db.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Equal(t, []db.FieldError{
+		{Field: "issuer", Message: "must be set"},
+		{Field: "metadata", Message: fmt.Sprintf("must not have more than %d keys, got %d", db.MaxOIDCSpecMetadataKeys, db.MaxOIDCSpecMetadataKeys+1)},
+	}, validationErr.Fields)
+}
+
+func TestCreateOIDCClientConfigAutoID_ReportsAllValidationProblems(t *testing.T) {
+	conn := dbtest.ConnectForTests(t)
+
+	_, err := db.CreateOIDCClientConfigAutoID(context.Background(), conn, dbtest.CipherSet(t), uuid.New(), "", db.OIDCSpec{
+		Metadata: map[string]string{
+			"":    "value",
+			"key": strings.Repeat("a", db.MaxOIDCSpecMetadataValueLength+1),
+		},
+	})
+
+	var validationErr *db.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Len(t, validationErr.Fields, 3)
+	require.Equal(t, "issuer", validationErr.Fields[0].Field)
+	require.Equal(t, "metadata", validationErr.Fields[1].Field)
+	require.Equal(t, "metadata.key", validationErr.Fields[2].Field)
+}
+
+func TestUpdateOIDCClientConfig(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	orgID := uuid.New()
+
+	created := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+	)[0]
+
+	t.Run("updates issuer and spec", func(t *testing.T) {
+		spec := db.OIDCSpec{ClientID: "updated", RedirectURL: "https://gitpod.io/iam/oidc/callback"}
+
+		updated, err := db.UpdateOIDCClientConfig(ctx, conn, cipher, created.ID, orgID, "https://updated.example.com", spec)
+		require.NoError(t, err)
+		require.Equal(t, "https://updated.example.com", updated.Issuer)
+
+		decrypted, err := updated.Data.Decrypt(cipher)
+		require.NoError(t, err)
+		usePKCE := true
+		spec.UsePKCE = &usePKCE
+		require.Equal(t, spec, decrypted, "PKCE defaults as on create")
+	})
+
+	t.Run("reports all validation problems", func(t *testing.T) {
+		_, err := db.UpdateOIDCClientConfig(ctx, conn, cipher, created.ID, orgID, "", db.OIDCSpec{
+			Metadata: map[string]string{"key": strings.Repeat("a", db.MaxOIDCSpecMetadataValueLength+1)},
+		})
+
+		var validationErr *db.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+		require.Len(t, validationErr.Fields, 2)
+	})
+
+	t.Run("rejects conflicting redirect url", func(t *testing.T) {
+		other := dbtest.CreateOIDCClientConfigs(t, conn,
+			dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+		)[0]
+
+		_, err := db.UpdateOIDCClientConfig(ctx, conn, cipher, other.ID, orgID, "https://other.example.com", db.OIDCSpec{
+			RedirectURL: "https://gitpod.io/iam/oidc/callback",
+		})
+		require.ErrorIs(t, err, db.ErrRedirectURLConflict)
+	})
+
+	t.Run("not found for another organization", func(t *testing.T) {
+		_, err := db.UpdateOIDCClientConfig(ctx, conn, cipher, created.ID, uuid.New(), "https://updated.example.com", db.OIDCSpec{})
+		require.ErrorIs(t, err, db.ErrorNotFound)
+	})
+}
+
+func TestEncryptOIDCSpec_Metadata(t *testing.T) {
+
+	t.Run("metadata round-trips through the database", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+		cipher := dbtest.CipherSet(t)
+
+		spec := db.OIDCSpec{
+			ClientID: "client-id",
+			Metadata: map[string]string{
+				"gitops/commit": "4f2a9c1",
+				"gitops/path":   "orgs/acme/sso.yaml",
+			},
+		}
+		data, err := db.EncryptOIDCSpec(cipher, spec)
+		require.NoError(t, err)
+
+		created := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+			OrganizationID: uuid.New(),
+			Data:           data,
+		})[0]
+
+		retrieved, err := db.GetOIDCClientConfig(context.Background(), conn, created.ID)
+		require.NoError(t, err)
+
+		decrypted, err := retrieved.Data.Decrypt(cipher)
+		require.NoError(t, err)
+		require.Equal(t, spec.Metadata, decrypted.Metadata)
+	})
+
+	t.Run("rejects too many keys", func(t *testing.T) {
+		metadata := map[string]string{}
+		for i := 0; i <= db.MaxOIDCSpecMetadataKeys; i++ {
+			metadata[fmt.Sprintf("key-%d", i)] = "value"
+		}
+
+		_, err := db.EncryptOIDCSpec(dbtest.CipherSet(t), db.OIDCSpec{Metadata: metadata})
+		require.Error(t, err)
+	})
+
+	t.Run("rejects too long values", func(t *testing.T) {
+		_, err := db.EncryptOIDCSpec(dbtest.CipherSet(t), db.OIDCSpec{Metadata: map[string]string{
+			"key": strings.Repeat("a", db.MaxOIDCSpecMetadataValueLength+1),
+		}})
+		require.Error(t, err)
+	})
+
+}
+
+func TestValidateOIDCSpec_PromptAndResponseType(t *testing.T) {
+	t.Run("empty values default to the authorization code flow", func(t *testing.T) {
+		spec := db.OIDCSpec{}
+		require.NoError(t, db.ValidateOIDCSpec(spec))
+		require.Equal(t, "code", spec.EffectiveResponseType())
+	})
+
+	for _, spec := range []db.OIDCSpec{
+		{Prompt: "login"},
+		{Prompt: "login consent"},
+		{Prompt: "none"},
+		{ResponseType: "code"},
+		{ResponseType: "code id_token"},
+	} {
+		t.Run(fmt.Sprintf("valid %+v", spec), func(t *testing.T) {
+			require.NoError(t, db.ValidateOIDCSpec(spec))
+		})
+	}
+
+	for _, spec := range []db.OIDCSpec{
+		{Prompt: "sometimes"},
+		{Prompt: "none login"},
+		{ResponseType: "token code"},
+		{ResponseType: "magic"},
+	} {
+		t.Run(fmt.Sprintf("invalid %+v", spec), func(t *testing.T) {
+			var validationErr *db.ValidationError
+			require.ErrorAs(t, db.ValidateOIDCSpec(spec), &validationErr)
+		})
+	}
+
+	t.Run("configured response type is used", func(t *testing.T) {
+		require.Equal(t, "id_token", db.OIDCSpec{ResponseType: "id_token"}.EffectiveResponseType())
+	})
+}
+
+func TestNormalizeRedirectURL(t *testing.T) {
+	for _, s := range []struct {
+		Input    string
+		Expected string
+	}{
+		{Input: "https://gitpod.io/iam/oidc/callback", Expected: "https://gitpod.io/iam/oidc/callback"},
+		{Input: "HTTPS://Gitpod.IO:443/iam/oidc/callback/", Expected: "https://gitpod.io/iam/oidc/callback"},
+		{Input: "http://localhost:8080/callback", Expected: "http://localhost:8080/callback"},
+	} {
+		t.Run(s.Input, func(t *testing.T) {
+			normalized, err := db.NormalizeRedirectURL(s.Input)
+			require.NoError(t, err)
+			require.Equal(t, s.Expected, normalized)
+		})
+	}
+}
+
+func TestCheckRedirectURLConflict(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	orgID := uuid.New()
+
+	data, err := db.EncryptJSON(cipher, db.OIDCSpec{RedirectURL: "https://gitpod.io/iam/oidc/callback"})
+	require.NoError(t, err)
+	existing := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+		OrganizationID: orgID,
+		Data:           data,
+	})[0]
+
+	t.Run("colliding redirect url is rejected", func(t *testing.T) {
+		err := db.CheckRedirectURLConflict(ctx, conn, cipher, orgID, uuid.Nil, "https://GITPOD.io/iam/oidc/callback/")
+		require.ErrorIs(t, err, db.ErrRedirectURLConflict)
+	})
+
+	t.Run("distinct redirect url is accepted", func(t *testing.T) {
+		err := db.CheckRedirectURLConflict(ctx, conn, cipher, orgID, uuid.Nil, "https://gitpod.io/iam/oidc/other-callback")
+		require.NoError(t, err)
+	})
+
+	t.Run("same redirect url in another organization is accepted", func(t *testing.T) {
+		err := db.CheckRedirectURLConflict(ctx, conn, cipher, uuid.New(), uuid.Nil, "https://gitpod.io/iam/oidc/callback")
+		require.NoError(t, err)
+	})
+
+	t.Run("config being updated does not collide with itself", func(t *testing.T) {
+		err := db.CheckRedirectURLConflict(ctx, conn, cipher, orgID, existing.ID, "https://gitpod.io/iam/oidc/callback")
+		require.NoError(t, err)
+	})
+}
+
 func TestListOIDCClientConfigsForOrganization(t *testing.T) {
 	ctx := context.Background()
 	conn := dbtest.ConnectForTests(t)
 
-	orgA, orgB := uuid.New(), uuid.New()
+	orgA, orgB := uuid.New(), uuid.New()
+
+	dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{
+			OrganizationID: orgA,
+		}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{
+			OrganizationID: orgA,
+		}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{
+			OrganizationID: orgB,
+		}),
+	)
+
+	configsForOrgA, err := db.ListOIDCClientConfigsForOrganization(ctx, conn, orgA)
+	require.NoError(t, err)
+	require.Len(t, configsForOrgA, 2)
+
+	configsForOrgB, err := db.ListOIDCClientConfigsForOrganization(ctx, conn, orgB)
+	require.NoError(t, err)
+	require.Len(t, configsForOrgB, 1)
+
+	configsForRandomOrg, err := db.ListOIDCClientConfigsForOrganization(ctx, conn, uuid.New())
+	require.NoError(t, err)
+	require.Len(t, configsForRandomOrg, 0)
+}
+
+func TestListDecodedOIDCClientConfigsForOrganization(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	orgID := uuid.New()
+
+	usePKCE := true
+	spec := db.OIDCSpec{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://gitpod.io/iam/oidc/callback",
+		UsePKCE:      &usePKCE,
+	}
+	data, err := db.EncryptJSON(cipher, spec)
+	require.NoError(t, err)
+	created := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: orgID, Data: data})[0]
+
+	t.Run("secret-free by default", func(t *testing.T) {
+		results, err := db.ListDecodedOIDCClientConfigsForOrganization(ctx, conn, cipher, orgID, false)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+
+		require.Equal(t, created.ID, results[0].ID)
+		require.Nil(t, results[0].Data)
+		require.Empty(t, results[0].Spec.ClientSecret)
+		require.Equal(t, "client-id", results[0].Spec.ClientID)
+		require.Equal(t, spec.RedirectURL, results[0].Spec.RedirectURL)
+	})
+
+	t.Run("includes secrets on request", func(t *testing.T) {
+		results, err := db.ListDecodedOIDCClientConfigsForOrganization(ctx, conn, cipher, orgID, true)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+
+		require.Equal(t, created.Data, results[0].Data)
+		require.Equal(t, spec, results[0].Spec)
+	})
+}
+
+func TestExportOIDCClientConfigsSanitized(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	orgID := uuid.New()
+
+	spec := db.OIDCSpec{
+		ClientID:     "client-id",
+		ClientSecret: "very-secret-client-secret",
+		RedirectURL:  "https://gitpod.io/iam/oidc/callback",
+		Scopes:       []string{"openid", "email"},
+	}
+	data, err := db.EncryptJSON(cipher, spec)
+	require.NoError(t, err)
+	created := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: orgID, Data: data, Active: true})[0]
+
+	exported, err := db.ExportOIDCClientConfigsSanitized(ctx, conn, cipher, orgID)
+	require.NoError(t, err)
+	require.NotContains(t, string(exported), spec.ClientSecret)
+	encrypted, err := created.Data.EncryptedData()
+	require.NoError(t, err)
+	require.NotContains(t, string(exported), encrypted.EncodedData)
+
+	var configs []map[string]interface{}
+	require.NoError(t, json.Unmarshal(exported, &configs))
+	require.Len(t, configs, 1)
+	require.Equal(t, created.ID.String(), configs[0]["id"])
+	require.Equal(t, created.Issuer, configs[0]["issuer"])
+	require.Equal(t, spec.ClientID, configs[0]["clientId"])
+	require.Equal(t, spec.RedirectURL, configs[0]["redirectUrl"])
+	require.Equal(t, []interface{}{"openid", "email"}, configs[0]["scopes"])
+	require.Equal(t, true, configs[0]["active"])
+	require.NotEmpty(t, configs[0]["clientSecretFingerprint"])
+}
+
+func TestGroupOIDCClientConfigsByIssuer(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	orgID := uuid.New()
+
+	dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Issuer: "https://accounts.google.com"}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Issuer: "https://accounts.google.com"}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Issuer: "https://gitlab.com"}),
+		// other organizations are not counted
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Issuer: "https://gitlab.com"}),
+	)
+
+	deleted := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Issuer: "https://gitlab.com"}),
+	)[0]
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, deleted.ID, orgID))
+
+	counts, err := db.GroupOIDCClientConfigsByIssuer(ctx, conn, orgID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{
+		"https://accounts.google.com": 2,
+		"https://gitlab.com":          1,
+	}, counts)
+}
+
+func TestCountOIDCConfigsByIssuerHost(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	// other tests share the database, assert on the changes of the counts only
+	before, err := db.CountOIDCConfigsByIssuerHost(ctx, conn)
+	require.NoError(t, err)
+
+	googleHost := fmt.Sprintf("%s.google.example.com", uuid.New().String())
+	microsoftHost := fmt.Sprintf("%s.microsoft.example.com", uuid.New().String())
+
+	dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Issuer: "https://" + googleHost}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Issuer: "https://" + strings.ToUpper(googleHost) + "/"}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Issuer: "https://" + microsoftHost + ":443/tenant/v2.0"}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Issuer: "not a url"}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Issuer: "https://%zz"}),
+	)
+
+	deleted := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Issuer: "https://" + microsoftHost}),
+	)[0]
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, deleted.ID, deleted.OrganizationID))
+
+	after, err := db.CountOIDCConfigsByIssuerHost(ctx, conn)
+	require.NoError(t, err)
+	require.Equal(t, 2, after[googleHost])
+	require.Equal(t, 1, after[microsoftHost])
+	require.Equal(t, 2, after[db.UnknownIssuerHost]-before[db.UnknownIssuerHost])
+}
+
+func TestActiveOIDCStatusForOrganizations(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	withActive, inactiveOnly, activeDeleted, withoutConfig := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: withActive, Active: true}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: withActive}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: inactiveOnly}),
+	)
+
+	deleted := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: activeDeleted, Active: true}),
+	)[0]
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, deleted.ID, activeDeleted))
+
+	status, err := db.ActiveOIDCStatusForOrganizations(ctx, conn, []uuid.UUID{withActive, inactiveOnly, activeDeleted, withoutConfig})
+	require.NoError(t, err)
+	require.Equal(t, map[uuid.UUID]bool{
+		withActive:    true,
+		inactiveOnly:  false,
+		activeDeleted: false,
+		withoutConfig: false,
+	}, status)
+
+	status, err = db.ActiveOIDCStatusForOrganizations(ctx, conn, nil)
+	require.NoError(t, err)
+	require.Empty(t, status)
+}
+
+func TestLatestModifiedOIDCClientConfigPerOrganization(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	orgA, orgB, withoutConfig := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgA, LastModified: now.Add(-3 * time.Hour)}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgA, LastModified: now.Add(-1 * time.Hour)}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgA, LastModified: now.Add(-2 * time.Hour)}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgB, LastModified: now.Add(-5 * time.Hour)}),
+	)
+
+	// deleted configs are ignored, even when modified last
+	deleted := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgB}),
+	)[0]
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, deleted.ID, orgB))
+
+	latest, err := db.LatestModifiedOIDCClientConfigPerOrganization(ctx, conn, []uuid.UUID{orgA, orgB, withoutConfig})
+	require.NoError(t, err)
+	require.Len(t, latest, 2)
+	require.Equal(t, configs[1].ID, latest[orgA].ID)
+	require.Equal(t, configs[3].ID, latest[orgB].ID)
+	require.NotContains(t, latest, withoutConfig)
+}
+
+func TestListOIDCClientConfigsWithSecretExpiringBefore(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+
+	cutoff := time.Now().Add(7 * 24 * time.Hour).UTC().Truncate(time.Second)
+	expiresBefore := cutoff.Add(-time.Hour)
+	expiresAfter := cutoff.Add(time.Hour)
+
+	encrypt := func(spec db.OIDCSpec) db.EncryptedJSON[db.OIDCSpec] {
+		data, err := db.EncryptJSON(cipher, spec)
+		require.NoError(t, err)
+		return data
+	}
+
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{
+			OrganizationID: uuid.New(),
+			Data:           encrypt(db.OIDCSpec{ClientID: "expires-before", SecretExpiresAt: &expiresBefore}),
+		}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{
+			OrganizationID: uuid.New(),
+			Data:           encrypt(db.OIDCSpec{ClientID: "expires-after", SecretExpiresAt: &expiresAfter}),
+		}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{
+			OrganizationID: uuid.New(),
+			Data:           encrypt(db.OIDCSpec{ClientID: "no-expiry"}),
+		}),
+	)
+
+	results, err := db.ListOIDCClientConfigsWithSecretExpiringBefore(ctx, conn, cipher, cutoff, 100)
+	require.NoError(t, err)
+
+	var ids []uuid.UUID
+	for _, r := range results {
+		ids = append(ids, r.ID)
+	}
+	require.Contains(t, ids, configs[0].ID)
+	require.NotContains(t, ids, configs[1].ID)
+	require.NotContains(t, ids, configs[2].ID)
+}
+
+func TestReencryptOIDCClientConfig(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+
+	// the non-primary key of the cipher set
+	oldKey, err := base64.StdEncoding.DecodeString("A3iUCT27LVbN67Fa+yfcMmLgNFdUWEl22JcdoER44gA=")
+	require.NoError(t, err)
+	oldCipher, err := db.NewAES256CBCCipher(string(oldKey), db.CipherMetadata{Name: "secondary", Version: 1})
+	require.NoError(t, err)
+
+	// an explicit UsePKCE keeps CreateOIDCClientConfig from re-encrypting the spec with the primary key
+	usePKCE := true
+	spec := db.OIDCSpec{ClientID: "client-id", ClientSecret: "client-secret", UsePKCE: &usePKCE}
+	data, err := db.EncryptJSON(oldCipher, spec)
+	require.NoError(t, err)
+	config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: uuid.New(), Data: data})[0]
+
+	reencrypted, err := db.ReencryptOIDCClientConfig(ctx, conn, cipher, config.ID)
+	require.NoError(t, err)
+
+	retrieved, err := db.GetOIDCClientConfig(ctx, conn, config.ID)
+	require.NoError(t, err)
+	require.Equal(t, reencrypted.Data, retrieved.Data)
+
+	// readable with the primary key alone
+	primaryCipher, _ := dbtest.GetTestCipher(t)
+	decrypted, err := retrieved.Data.Decrypt(primaryCipher)
+	require.NoError(t, err)
+	require.Equal(t, spec, decrypted)
+
+	t.Run("fails when the spec cannot be decrypted", func(t *testing.T) {
+		undecryptableData, err := db.NewEncryptedJSON[db.OIDCSpec](db.EncryptedData{
+			EncodedData: "garbage",
+			Metadata:    db.CipherMetadata{Name: "unknown", Version: 99},
+		})
+		require.NoError(t, err)
+		config := dbtest.CreateUndecryptableOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: uuid.New(), Data: undecryptableData})[0]
+
+		_, err = db.ReencryptOIDCClientConfig(ctx, conn, cipher, config.ID)
+		require.ErrorIs(t, err, db.ErrDataDecryption)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := db.ReencryptOIDCClientConfig(ctx, conn, cipher, uuid.New())
+		require.ErrorIs(t, err, db.ErrorNotFound)
+	})
+}
+
+func TestRewriteRedirectURLHostForOrganization(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+
+	createWithRedirectURL := func(t *testing.T, orgID uuid.UUID, redirectURL string) db.OIDCClientConfig {
+		data, err := db.EncryptJSON(cipher, db.OIDCSpec{ClientID: "client-id", RedirectURL: redirectURL})
+		require.NoError(t, err)
+		return dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: orgID, Data: data})[0]
+	}
+	redirectURLOf := func(t *testing.T, id uuid.UUID) string {
+		config, err := db.GetOIDCClientConfig(ctx, conn, id)
+		require.NoError(t, err)
+		spec, err := db.DecodeSpec(cipher, config)
+		require.NoError(t, err)
+		return spec.RedirectURL
+	}
+
+	t.Run("rewrites matching hosts only", func(t *testing.T) {
+		orgID := uuid.New()
+		matching := createWithRedirectURL(t, orgID, "https://old.example.com/iam/oidc/callback")
+		matchingWithPort := createWithRedirectURL(t, orgID, "https://OLD.example.com:8443/iam/oidc/callback")
+		other := createWithRedirectURL(t, orgID, "https://other.example.com/iam/oidc/callback")
+		otherOrg := createWithRedirectURL(t, uuid.New(), "https://old.example.com/iam/oidc/callback")
+		require.NoError(t, db.MarkOIDCClientConfigVerified(ctx, conn, matching.ID, orgID))
+
+		changed, err := db.RewriteRedirectURLHostForOrganization(ctx, conn, cipher, orgID, "old.example.com", "new.example.com")
+		require.NoError(t, err)
+		require.Equal(t, 2, changed)
+
+		require.Equal(t, "https://new.example.com/iam/oidc/callback", redirectURLOf(t, matching.ID))
+		require.Equal(t, "https://new.example.com:8443/iam/oidc/callback", redirectURLOf(t, matchingWithPort.ID))
+		require.Equal(t, "https://other.example.com/iam/oidc/callback", redirectURLOf(t, other.ID))
+		require.Equal(t, "https://old.example.com/iam/oidc/callback", redirectURLOf(t, otherOrg.ID))
+
+		retrieved, err := db.GetOIDCClientConfig(ctx, conn, matching.ID)
+		require.NoError(t, err)
+		require.Nil(t, retrieved.VerifiedAt)
+	})
+
+	t.Run("no changes when no host matches", func(t *testing.T) {
+		orgID := uuid.New()
+		config := createWithRedirectURL(t, orgID, "https://other.example.com/iam/oidc/callback")
+
+		changed, err := db.RewriteRedirectURLHostForOrganization(ctx, conn, cipher, orgID, "old.example.com", "new.example.com")
+		require.NoError(t, err)
+		require.Zero(t, changed)
+		require.Equal(t, "https://other.example.com/iam/oidc/callback", redirectURLOf(t, config.ID))
+	})
+
+	t.Run("rolls back on conflicts", func(t *testing.T) {
+		orgID := uuid.New()
+		config := createWithRedirectURL(t, orgID, "https://old.example.com/iam/oidc/callback")
+		createWithRedirectURL(t, orgID, "https://new.example.com/iam/oidc/callback")
+
+		_, err := db.RewriteRedirectURLHostForOrganization(ctx, conn, cipher, orgID, "old.example.com", "new.example.com")
+		require.ErrorIs(t, err, db.ErrRedirectURLConflict)
+		require.Equal(t, "https://old.example.com/iam/oidc/callback", redirectURLOf(t, config.ID))
+	})
+}
+
+func TestForEachOIDCClientConfig(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	orgID := uuid.New()
+
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+	)
+	deleted := dbtest.CreateOIDCClientConfigs(t, conn, dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}))[0]
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, deleted.ID, orgID))
+
+	t.Run("visits all non-deleted configs", func(t *testing.T) {
+		visited := map[uuid.UUID]int{}
+		err := db.ForEachOIDCClientConfig(ctx, conn, 2, func(config db.OIDCClientConfig) error {
+			if config.OrganizationID == orgID {
+				visited[config.ID]++
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, map[uuid.UUID]int{configs[0].ID: 1, configs[1].ID: 1, configs[2].ID: 1}, visited)
+	})
+
+	t.Run("error stops iteration", func(t *testing.T) {
+		errStop := errors.New("stop")
+		calls := 0
+		err := db.ForEachOIDCClientConfig(ctx, conn, 2, func(config db.OIDCClientConfig) error {
+			calls++
+			return errStop
+		})
+		require.ErrorIs(t, err, errStop)
+		require.Equal(t, 1, calls)
+	})
+}
+
+func TestValidateAllActiveOIDCClientConfigs(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+
+	validData, err := db.EncryptJSON(cipher, db.OIDCSpec{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://gitpod.io/iam/oidc/callback",
+		Scopes:       []string{"openid"},
+	})
+	require.NoError(t, err)
+	invalidData, err := db.EncryptJSON(cipher, db.OIDCSpec{})
+	require.NoError(t, err)
+	undecryptableData, err := db.NewEncryptedJSON[db.OIDCSpec](db.EncryptedData{
+		EncodedData: "garbage",
+		Metadata:    db.CipherMetadata{Name: "unknown", Version: 99},
+	})
+	require.NoError(t, err)
+
+	configs := append(
+		dbtest.CreateOIDCClientConfigs(t, conn,
+			dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Data: validData, Active: true}),
+			dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Data: invalidData, Active: true}),
+		),
+		dbtest.CreateUndecryptableOIDCClientConfigs(t, conn,
+			dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Data: undecryptableData, Active: true}),
+			// inactive configs are not validated
+			dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Data: undecryptableData}),
+		)...,
+	)
+
+	results, err := db.ValidateAllActiveOIDCClientConfigs(ctx, conn, cipher)
+	require.NoError(t, err)
+
+	byID := map[uuid.UUID]db.OIDCClientConfigValidationResult{}
+	for _, result := range results {
+		byID[result.ID] = result
+	}
+
+	valid := byID[configs[0].ID]
+	require.True(t, valid.OK)
+	require.NoError(t, valid.Err)
+	require.Equal(t, configs[0].OrganizationID, valid.OrganizationID)
+
+	invalid := byID[configs[1].ID]
+	require.False(t, invalid.OK)
+	var validationErr *db.ValidationError
+	require.ErrorAs(t, invalid.Err, &validationErr)
+
+	undecryptable := byID[configs[2].ID]
+	require.False(t, undecryptable.OK)
+	require.ErrorIs(t, undecryptable.Err, db.ErrDataDecryption)
+
+	require.NotContains(t, byID, configs[3].ID)
+}
+
+func TestListUndecryptableOIDCClientConfigs(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	orgID := uuid.New()
+
+	undecryptableData, err := db.NewEncryptedJSON[db.OIDCSpec](db.EncryptedData{
+		EncodedData: "garbage",
+		Metadata:    db.CipherMetadata{Name: "unknown", Version: 99},
+	})
+	require.NoError(t, err)
+
+	dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+	)
+	undecryptableConfigs := dbtest.CreateUndecryptableOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Data: undecryptableData}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Data: undecryptableData}),
+	)
+
+	undecryptable, err := db.ListUndecryptableOIDCClientConfigs(ctx, conn, cipher, 1000)
+	require.NoError(t, err)
+
+	found := map[uuid.UUID]bool{}
+	for _, config := range undecryptable {
+		require.ErrorIs(t, config.Err, db.ErrDataDecryption)
+		if config.OrganizationID == orgID {
+			found[config.ID] = true
+		}
+	}
+	require.Equal(t, map[uuid.UUID]bool{undecryptableConfigs[0].ID: true, undecryptableConfigs[1].ID: true}, found)
+
+	limited, err := db.ListUndecryptableOIDCClientConfigs(ctx, conn, cipher, 1)
+	require.NoError(t, err)
+	require.Len(t, limited, 1)
+}
+
+func TestListOIDCClientConfigsWithUnresolvableIssuer(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	orgID := uuid.New()
+	goneHost := fmt.Sprintf("gone-%s.example.com", uuid.New())
+
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Issuer: "https://accounts.google.com"}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Issuer: "https://" + goneHost}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Issuer: "not a url"}),
+	)
+
+	resolver := fakeResolver(t, goneHost)
+
+	unresolvable, err := db.ListOIDCClientConfigsWithUnresolvableIssuer(ctx, conn, resolver, 1000)
+	require.NoError(t, err)
+
+	var found []uuid.UUID
+	for _, config := range unresolvable {
+		if config.OrganizationID == orgID {
+			found = append(found, config.ID)
+		}
+	}
+	require.Equal(t, []uuid.UUID{configs[1].ID}, found)
+
+	_, err = db.ListOIDCClientConfigsWithUnresolvableIssuer(ctx, conn, resolver, 0)
+	require.Error(t, err)
+}
+
+// fakeResolver returns a resolver which answers NXDOMAIN for the given hosts, and 127.0.0.1 for any other host.
+func fakeResolver(t *testing.T, notFound ...string) *net.Resolver {
+	t.Helper()
+
+	missing := map[string]bool{}
+	for _, host := range notFound {
+		missing[host+"."] = true
+	}
+
+	answer := func(query []byte) ([]byte, error) {
+		var msg dnsmessage.Message
+		if err := msg.Unpack(query); err != nil {
+			return nil, err
+		}
+
+		response := dnsmessage.Message{
+			Header: dnsmessage.Header{
+				ID:                 msg.ID,
+				Response:           true,
+				RecursionDesired:   msg.RecursionDesired,
+				RecursionAvailable: true,
+			},
+			Questions: msg.Questions,
+		}
+		for _, q := range msg.Questions {
+			if missing[q.Name.String()] {
+				response.RCode = dnsmessage.RCodeNameError
+				continue
+			}
+			if q.Type == dnsmessage.TypeA {
+				response.Answers = append(response.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+				})
+			}
+		}
+		return response.Pack()
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				for {
+					// the connection is not a net.PacketConn, so messages are framed as over TCP
+					var length [2]byte
+					if _, err := io.ReadFull(server, length[:]); err != nil {
+						return
+					}
+					query := make([]byte, binary.BigEndian.Uint16(length[:]))
+					if _, err := io.ReadFull(server, query); err != nil {
+						return
+					}
+
+					response, err := answer(query)
+					if err != nil {
+						return
+					}
+					binary.BigEndian.PutUint16(length[:], uint16(len(response)))
+					if _, err := server.Write(append(length[:], response...)); err != nil {
+						return
+					}
+				}
+			}()
+			return client, nil
+		},
+	}
+}
+
+func TestDiffOIDCSpecs(t *testing.T) {
+	old := db.OIDCSpec{
+		ClientID:     "client-id",
+		ClientSecret: "old-secret",
+		RedirectURL:  "https://old.gitpod.io/iam/oidc/callback",
+		Scopes:       []string{"openid", "email"},
+		Metadata:     map[string]string{"team": "platform"},
+	}
+
+	t.Run("unchanged specs have no diff", func(t *testing.T) {
+		require.Empty(t, db.DiffOIDCSpecs(old, old))
+	})
+
+	t.Run("changed fields", func(t *testing.T) {
+		changed := old
+		changed.RedirectURL = "https://new.gitpod.io/iam/oidc/callback"
+		changed.Scopes = []string{"openid", "email", "profile"}
+
+		require.Equal(t, []db.FieldDiff{
+			{Field: "redirectUrl", Old: "https://old.gitpod.io/iam/oidc/callback", New: "https://new.gitpod.io/iam/oidc/callback"},
+			{Field: "scopes", Old: "openid email", New: "openid email profile"},
+		}, db.DiffOIDCSpecs(old, changed), "unchanged fields must be omitted")
+	})
+
+	t.Run("secret as fingerprint", func(t *testing.T) {
+		changed := old
+		changed.ClientSecret = "new-secret"
+		changed.Metadata = map[string]string{"team": "platform", "source": "terraform"}
+
+		diffs := db.DiffOIDCSpecs(old, changed)
+		require.Len(t, diffs, 2)
+
+		require.Equal(t, "clientSecret", diffs[0].Field)
+		require.Len(t, diffs[0].Old, 64)
+		require.Len(t, diffs[0].New, 64)
+		require.NotEqual(t, diffs[0].Old, diffs[0].New)
+		for _, value := range []string{diffs[0].Old, diffs[0].New} {
+			require.NotContains(t, value, "secret")
+		}
+
+		require.Equal(t, db.FieldDiff{Field: "metadata.source", New: "terraform"}, diffs[1])
+	})
+}
+
+func TestListOIDCClientConfigsMissingScopes(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	orgID := uuid.New()
+
+	withScopes := func(scopes ...string) db.OIDCClientConfig {
+		data, err := db.EncryptJSON(cipher, db.OIDCSpec{ClientID: "client-id", Scopes: scopes})
+		require.NoError(t, err)
+		return dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Data: data})
+	}
+
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		withScopes("openid", "email", "profile"),
+		withScopes("openid", "email"),
+		withScopes("openid"),
+		withScopes(" profile", "openid", "email "),
+		withScopes(),
+	)
+
+	missing, err := db.ListOIDCClientConfigsMissingScopes(ctx, conn, cipher, orgID, []string{"email", "profile"})
+	require.NoError(t, err)
+
+	var ids []uuid.UUID
+	for _, config := range missing {
+		ids = append(ids, config.ID)
+	}
+	require.ElementsMatch(t, []uuid.UUID{configs[1].ID, configs[2].ID, configs[4].ID}, ids)
+
+	none, err := db.ListOIDCClientConfigsMissingScopes(ctx, conn, cipher, orgID, nil)
+	require.NoError(t, err)
+	require.Empty(t, none)
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	require.Nil(t, db.NormalizeScopes(nil))
+	require.Equal(t, []string{"openid", "email", "profile"}, db.NormalizeScopes([]string{"openid", " email", "", "openid", "profile", "email "}))
+}
+
+func TestOIDCSpec_Fingerprint(t *testing.T) {
+	expiresAt := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	spec := db.OIDCSpec{
+		ClientID:        "client-id",
+		ClientSecret:    "client-secret",
+		RedirectURL:     "https://gitpod.io/iam/oidc/callback",
+		Scopes:          []string{"openid", "email", "profile"},
+		SecretExpiresAt: &expiresAt,
+		Metadata:        map[string]string{"team": "platform", "source": "terraform"},
+		Prompt:          "login consent",
+	}
+	fingerprint := spec.Fingerprint()
+	require.Len(t, fingerprint, 64)
+	require.NotContains(t, fingerprint, spec.ClientSecret)
+
+	equivalent := spec
+	equivalent.Scopes = []string{"profile", " email", "openid", "email"}
+	equivalent.RedirectURL = "HTTPS://Gitpod.io:443/iam/oidc/callback/"
+	equivalent.Prompt = "consent login"
+	equivalent.ResponseType = db.DefaultOIDCResponseType
+	localExpiresAt := expiresAt.In(time.FixedZone("CEST", 2*60*60))
+	equivalent.SecretExpiresAt = &localExpiresAt
+	require.Equal(t, fingerprint, equivalent.Fingerprint())
+
+	otherExpiresAt := expiresAt.Add(time.Hour)
+	usePKCE := true
+	for name, modify := range map[string]func(s *db.OIDCSpec){
+		"client id":         func(s *db.OIDCSpec) { s.ClientID = "other" },
+		"client secret":     func(s *db.OIDCSpec) { s.ClientSecret = "other" },
+		"redirect url":      func(s *db.OIDCSpec) { s.RedirectURL = "https://gitpod.io/iam/oidc/other-callback" },
+		"scopes":            func(s *db.OIDCSpec) { s.Scopes = []string{"openid"} },
+		"secret expires at": func(s *db.OIDCSpec) { s.SecretExpiresAt = &otherExpiresAt },
+		"no secret expiry":  func(s *db.OIDCSpec) { s.SecretExpiresAt = nil },
+		"metadata":          func(s *db.OIDCSpec) { s.Metadata = map[string]string{"team": "other"} },
+		"prompt":            func(s *db.OIDCSpec) { s.Prompt = "login" },
+		"response type":     func(s *db.OIDCSpec) { s.ResponseType = "id_token" },
+		"use pkce":          func(s *db.OIDCSpec) { s.UsePKCE = &usePKCE },
+	} {
+		t.Run(name, func(t *testing.T) {
+			changed := spec
+			modify(&changed)
+			require.NotEqual(t, fingerprint, changed.Fingerprint())
+		})
+	}
+}
+
+func TestNormalizeExistingOIDCClientConfigs(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	orgID := uuid.New()
+
+	usePKCE := true
+	unnormalizedData, err := db.EncryptJSON(cipher, db.OIDCSpec{
+		ClientID:    "client-id",
+		RedirectURL: "HTTPS://Gitpod.io:443/iam/oidc/callback/",
+		Scopes:      []string{"openid", "email", "openid"},
+		UsePKCE:     &usePKCE,
+	})
+	require.NoError(t, err)
+	normalizedData, err := db.EncryptJSON(cipher, db.OIDCSpec{
+		ClientID:    "client-id",
+		RedirectURL: "https://gitpod.io/iam/oidc/other-callback",
+		Scopes:      []string{"openid"},
+	})
+	require.NoError(t, err)
+	undecryptableData, err := db.NewEncryptedJSON[db.OIDCSpec](db.EncryptedData{
+		EncodedData: "garbage",
+		Metadata:    db.CipherMetadata{Name: "unknown", Version: 99},
+	})
+	require.NoError(t, err)
+
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Issuer: "HTTPS://Accounts.Google.com/", Data: unnormalizedData}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Issuer: "https://accounts.google.com", Data: normalizedData}),
+	)
+	undecryptable := dbtest.CreateUndecryptableOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Issuer: "https://accounts.google.com", Data: undecryptableData}),
+	)[0]
+	unnormalized, normalized := configs[0], configs[1]
+
+	report, err := db.NormalizeExistingOIDCClientConfigs(ctx, conn, cipher, 2)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, report.Scanned, 3)
+	require.GreaterOrEqual(t, report.Changed, 1)
+	require.GreaterOrEqual(t, report.Failed, 1)
+
+	converged, err := db.GetOIDCClientConfig(ctx, conn, unnormalized.ID)
+	require.NoError(t, err)
+	require.Equal(t, "https://accounts.google.com", converged.Issuer)
+	spec, err := converged.Data.Decrypt(cipher)
+	require.NoError(t, err)
+	require.Equal(t, db.OIDCSpec{
+		ClientID:    "client-id",
+		RedirectURL: "https://gitpod.io/iam/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+		UsePKCE:     &usePKCE,
+	}, spec)
+
+	untouched, err := db.GetOIDCClientConfig(ctx, conn, normalized.ID)
+	require.NoError(t, err)
+	require.Equal(t, normalized.Data, untouched.Data)
+	require.Equal(t, normalized.LastModified, untouched.LastModified)
+
+	failed, err := db.GetOIDCClientConfig(ctx, conn, undecryptable.ID)
+	require.NoError(t, err)
+	require.Equal(t, undecryptable.Data, failed.Data)
+
+	// a second run finds nothing left to change for the seeded configs
+	_, err = db.NormalizeExistingOIDCClientConfigs(ctx, conn, cipher, 2)
+	require.NoError(t, err)
+	again, err := db.GetOIDCClientConfig(ctx, conn, unnormalized.ID)
+	require.NoError(t, err)
+	require.Equal(t, converged.Data, again.Data)
+}
+
+func TestDeleteOIDCClientConfig(t *testing.T) {
+
+	t.Run("returns not found, when record does not exist", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+		orgID := uuid.New()
+
+		err := db.DeleteOIDCClientConfig(context.Background(), conn, uuid.New(), orgID)
+		require.Error(t, err)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+	})
+
+	t.Run("marks record deleted", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+		orgID := uuid.New()
+
+		created := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+			OrganizationID: orgID,
+		})[0]
+
+		err := db.DeleteOIDCClientConfig(context.Background(), conn, created.ID, created.OrganizationID)
+		require.NoError(t, err)
+
+		// Delete only sets the `deleted` field, verify that's true
+		var retrieved db.OIDCClientConfig
+		tx := conn.Where("id = ?", created.ID).Where("deleted = 1").First(&retrieved)
+		require.NoError(t, tx.Error)
+	})
+
+}
+
+func TestHardDeleteOIDCClientConfig(t *testing.T) {
+
+	t.Run("returns not found, when record does not exist", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		err := db.HardDeleteOIDCClientConfig(context.Background(), conn, uuid.New(), uuid.New())
+		require.Error(t, err)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+	})
+
+	t.Run("returns not found, when record belongs to another organization", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		created := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+			OrganizationID: uuid.New(),
+		})[0]
+
+		err := db.HardDeleteOIDCClientConfig(context.Background(), conn, created.ID, uuid.New())
+		require.Error(t, err)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+	})
+
+	t.Run("physically removes record", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		created := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+			OrganizationID: uuid.New(),
+		})[0]
+
+		err := db.HardDeleteOIDCClientConfig(context.Background(), conn, created.ID, created.OrganizationID)
+		require.NoError(t, err)
+
+		var count int64
+		tx := conn.Model(&db.OIDCClientConfig{}).Where("id = ?", created.ID).Count(&count)
+		require.NoError(t, tx.Error)
+		require.EqualValues(t, 0, count)
+
+		_, err = db.GetOIDCClientConfig(context.Background(), conn, created.ID)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+	})
+
+}
+
+func TestGetOIDCClientConfigForOrganization(t *testing.T) {
+
+	t.Run("not found when config does not exist", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		_, err := db.GetOIDCClientConfigForOrganization(context.Background(), conn, uuid.New(), uuid.New())
+		require.Error(t, err)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+	})
+
+	t.Run("retrieves config which exists", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		orgID := uuid.New()
+
+		created := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+			OrganizationID: orgID,
+		})[0]
+
+		retrieved, err := db.GetOIDCClientConfigForOrganization(context.Background(), conn, created.ID, created.OrganizationID)
+		require.NoError(t, err)
+
+		require.Equal(t, created, retrieved)
+	})
+
+}
+
+func TestGetDecodedOIDCClientConfigForOrganization(t *testing.T) {
+
+	t.Run("not found when config does not exist", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		_, _, err := db.GetDecodedOIDCClientConfigForOrganization(context.Background(), conn, dbtest.CipherSet(t), uuid.New(), uuid.New())
+		require.Error(t, err)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+		require.NotErrorIs(t, err, db.ErrDataDecryption)
+	})
+
+	t.Run("retrieves config and decrypted spec", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		usePKCE := true
+		spec := db.OIDCSpec{
+			ClientID:     "client-id",
+			ClientSecret: "client-secret",
+			RedirectURL:  "https://gitpod.io/iam/oidc/callback",
+			Scopes:       []string{"openid", "email"},
+			UsePKCE:      &usePKCE,
+		}
+		data, err := db.EncryptJSON(dbtest.CipherSet(t), spec)
+		require.NoError(t, err)
+
+		created := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+			OrganizationID: uuid.New(),
+			Data:           data,
+		})[0]
+
+		config, decrypted, err := db.GetDecodedOIDCClientConfigForOrganization(context.Background(), conn, dbtest.CipherSet(t), created.ID, created.OrganizationID)
+		require.NoError(t, err)
+		require.Equal(t, created, config)
+		require.Equal(t, spec, decrypted)
+	})
+
+	t.Run("reports decryption failure distinctly", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		data, err := db.NewEncryptedJSON[db.OIDCSpec](db.EncryptedData{
+			EncodedData: "bm90LWVuY3J5cHRlZA==",
+			Params:      db.KeyParams{InitializationVector: "aXY="},
+			Metadata:    db.CipherMetadata{Name: "unknown", Version: 99},
+		})
+		require.NoError(t, err)
+
+		created := dbtest.CreateUndecryptableOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+			OrganizationID: uuid.New(),
+			Data:           data,
+		})[0]
+
+		_, _, err = db.GetDecodedOIDCClientConfigForOrganization(context.Background(), conn, dbtest.CipherSet(t), created.ID, created.OrganizationID)
+		require.Error(t, err)
+		require.ErrorIs(t, err, db.ErrDataDecryption)
+		require.NotErrorIs(t, err, db.ErrorNotFound)
+	})
+
+}
+
+func TestGetOIDCClientConfigRedirectURL(t *testing.T) {
+
+	t.Run("not found when config does not exist", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		_, err := db.GetOIDCClientConfigRedirectURL(context.Background(), conn, dbtest.CipherSet(t), uuid.New())
+		require.Error(t, err)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+	})
+
+	t.Run("returns only the redirect url", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		data, err := db.EncryptJSON(dbtest.CipherSet(t), db.OIDCSpec{
+			ClientID:     "client-id",
+			ClientSecret: "super-secret",
+			RedirectURL:  "https://gitpod.io/iam/oidc/callback",
+		})
+		require.NoError(t, err)
+
+		created := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+			OrganizationID: uuid.New(),
+			Data:           data,
+		})[0]
+
+		redirectURL, err := db.GetOIDCClientConfigRedirectURL(context.Background(), conn, dbtest.CipherSet(t), created.ID)
+		require.NoError(t, err)
+		require.Equal(t, "https://gitpod.io/iam/oidc/callback", redirectURL)
+		require.NotContains(t, redirectURL, "super-secret")
+	})
+
+}
+
+func TestActivateClientConfig(t *testing.T) {
+
+	t.Run("not found when config does not exist", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		err := db.ActivateClientConfig(context.Background(), conn, uuid.New())
+		require.Error(t, err)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+	})
+
+	t.Run("config which exists is marked as active", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+			OrganizationID: uuid.New(),
+			Active:         false,
+		})[0]
+		configID := config.ID
+
+		config, err := db.GetOIDCClientConfig(context.Background(), conn, configID)
+		require.NoError(t, err)
+		require.Equal(t, false, config.Active)
+
+		err = db.ActivateClientConfig(context.Background(), conn, configID)
+		require.NoError(t, err)
+
+		config2, err := db.GetOIDCClientConfig(context.Background(), conn, configID)
+		require.NoError(t, err)
+		require.Equal(t, true, config2.Active)
+
+		err = db.ActivateClientConfig(context.Background(), conn, configID)
+		require.NoError(t, err)
+	})
+
+}
+
+func TestOIDCClientConfigVerification(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	orgID := uuid.New()
+
+	t.Run("marks config as verified", func(t *testing.T) {
+		config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: orgID})[0]
+		require.Nil(t, config.VerifiedAt)
+
+		require.NoError(t, db.MarkOIDCClientConfigVerified(ctx, conn, config.ID, orgID))
+
+		retrieved, err := db.GetOIDCClientConfig(ctx, conn, config.ID)
+		require.NoError(t, err)
+		require.NotNil(t, retrieved.VerifiedAt)
+
+		require.NoError(t, db.ClearOIDCClientConfigVerification(ctx, conn, config.ID, orgID))
+
+		retrieved, err = db.GetOIDCClientConfig(ctx, conn, config.ID)
+		require.NoError(t, err)
+		require.Nil(t, retrieved.VerifiedAt)
+	})
+
+	t.Run("keeps last modified", func(t *testing.T) {
+		lastModified := time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+		config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: orgID, LastModified: lastModified})[0]
+
+		require.NoError(t, db.MarkOIDCClientConfigVerified(ctx, conn, config.ID, orgID))
+
+		retrieved, err := db.GetOIDCClientConfig(ctx, conn, config.ID)
+		require.NoError(t, err)
+		require.NotNil(t, retrieved.VerifiedAt)
+		require.True(t, lastModified.Equal(retrieved.LastModified), "verification must not invalidate cached specs")
+	})
+
+	t.Run("not found for another organization", func(t *testing.T) {
+		config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: orgID})[0]
+
+		require.ErrorIs(t, db.MarkOIDCClientConfigVerified(ctx, conn, config.ID, uuid.New()), db.ErrorNotFound)
+	})
+
+	t.Run("update clears verification", func(t *testing.T) {
+		config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: orgID})[0]
+		require.NoError(t, db.MarkOIDCClientConfigVerified(ctx, conn, config.ID, orgID))
+
+		updated, err := db.UpdateOIDCClientConfig(ctx, conn, cipher, config.ID, orgID, "https://updated.example.com", db.OIDCSpec{ClientID: "updated"})
+		require.NoError(t, err)
+		require.Nil(t, updated.VerifiedAt)
+	})
+
+	t.Run("activation of unverified config is rejected when required", func(t *testing.T) {
+		config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: orgID})[0]
+
+		err := db.ActivateVerifiedClientConfig(ctx, conn, config.ID)
+		require.ErrorIs(t, err, db.ErrOIDCClientConfigNotVerified)
+
+		retrieved, err := db.GetOIDCClientConfig(ctx, conn, config.ID)
+		require.NoError(t, err)
+		require.False(t, retrieved.Active)
+
+		require.NoError(t, db.MarkOIDCClientConfigVerified(ctx, conn, config.ID, orgID))
+		require.NoError(t, db.ActivateVerifiedClientConfig(ctx, conn, config.ID))
+
+		retrieved, err = db.GetOIDCClientConfig(ctx, conn, config.ID)
+		require.NoError(t, err)
+		require.True(t, retrieved.Active)
+	})
+
+	t.Run("activation of unverified config is allowed when not required", func(t *testing.T) {
+		config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: orgID})[0]
+
+		require.NoError(t, db.ActivateClientConfig(ctx, conn, config.ID))
+	})
+}
+
+func TestListStaleUnactivatedOIDCClientConfigs(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	old := now.Add(-30 * 24 * time.Hour)
 
-	dbtest.CreateOIDCClientConfigs(t, conn,
-		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{
-			OrganizationID: orgA,
-		}),
-		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{
-			OrganizationID: orgA,
-		}),
-		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{
-			OrganizationID: orgB,
-		}),
+	orgID := uuid.New()
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), CreatedAt: old}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), CreatedAt: now.Add(-time.Hour)}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), CreatedAt: old, Active: true}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, CreatedAt: old}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), CreatedAt: old}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
 	)
+	stale, recent, active, deactivated, deleted, replacement := configs[0], configs[1], configs[2], configs[3], configs[4], configs[5]
 
-	configsForOrgA, err := db.ListOIDCClientConfigsForOrganization(ctx, conn, orgA)
+	// activated, and deactivated again by activating another config of the organization
+	require.NoError(t, db.ActivateClientConfig(ctx, conn, deactivated.ID))
+	require.NoError(t, db.SwapActiveOIDCClientConfig(ctx, conn, orgID, replacement.ID))
+
+	retrieved, err := db.GetOIDCClientConfig(ctx, conn, deactivated.ID)
 	require.NoError(t, err)
-	require.Len(t, configsForOrgA, 2)
+	require.False(t, retrieved.Active)
+	require.NotNil(t, retrieved.FirstActivatedAt)
 
-	configsForOrgB, err := db.ListOIDCClientConfigsForOrganization(ctx, conn, orgB)
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, deleted.ID, deleted.OrganizationID))
+
+	listed, err := db.ListStaleUnactivatedOIDCClientConfigs(ctx, conn, 7*24*time.Hour)
 	require.NoError(t, err)
-	require.Len(t, configsForOrgB, 1)
 
-	configsForRandomOrg, err := db.ListOIDCClientConfigsForOrganization(ctx, conn, uuid.New())
+	ids := map[uuid.UUID]bool{}
+	for _, config := range listed {
+		ids[config.ID] = true
+	}
+	require.True(t, ids[stale.ID], "never activated config created before the cutoff is stale")
+	require.False(t, ids[recent.ID], "config created after the cutoff is not stale")
+	require.False(t, ids[active.ID], "active config is not stale")
+	require.False(t, ids[deactivated.ID], "config which was active before is not stale")
+	require.False(t, ids[deleted.ID], "deleted config is not listed")
+
+	_, err = db.ListStaleUnactivatedOIDCClientConfigs(ctx, conn, 0)
+	require.Error(t, err)
+}
+
+func TestScheduleOIDCClientConfigDeletion(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	config := dbtest.CreateOIDCClientConfigs(t, conn, dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New()}))[0]
+
+	t.Run("schedules the deletion", func(t *testing.T) {
+		before := time.Now().UTC().Add(7 * 24 * time.Hour).Add(-time.Second)
+		require.NoError(t, db.ScheduleOIDCClientConfigDeletion(ctx, conn, config.ID, config.OrganizationID, 7*24*time.Hour))
+
+		retrieved, err := db.GetOIDCClientConfig(ctx, conn, config.ID)
+		require.NoError(t, err, "scheduled config is not deleted yet")
+		require.NotNil(t, retrieved.DeleteScheduledAt)
+		require.True(t, retrieved.DeleteScheduledAt.After(before))
+	})
+
+	t.Run("cancels the deletion", func(t *testing.T) {
+		require.NoError(t, db.CancelScheduledDeletion(ctx, conn, config.ID, config.OrganizationID))
+
+		retrieved, err := db.GetOIDCClientConfig(ctx, conn, config.ID)
+		require.NoError(t, err)
+		require.Nil(t, retrieved.DeleteScheduledAt)
+	})
+
+	t.Run("config of another organization is not found", func(t *testing.T) {
+		err := db.ScheduleOIDCClientConfigDeletion(ctx, conn, config.ID, uuid.New(), time.Hour)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+
+		err = db.CancelScheduledDeletion(ctx, conn, config.ID, uuid.New())
+		require.ErrorIs(t, err, db.ErrorNotFound)
+	})
+
+	t.Run("rejects a grace period which is not positive", func(t *testing.T) {
+		err := db.ScheduleOIDCClientConfigDeletion(ctx, conn, config.ID, config.OrganizationID, 0)
+		require.Error(t, err)
+	})
+}
+
+func TestProcessScheduledOIDCDeletions(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), DeleteScheduledAt: &past}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), DeleteScheduledAt: &future}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New()}),
+	)
+	due, notDue, unscheduled := configs[0], configs[1], configs[2]
+
+	deleted, err := db.ProcessScheduledOIDCDeletions(ctx, conn)
 	require.NoError(t, err)
-	require.Len(t, configsForRandomOrg, 0)
+	require.GreaterOrEqual(t, deleted, int64(1))
+
+	_, err = db.GetOIDCClientConfig(ctx, conn, due.ID)
+	require.ErrorIs(t, err, db.ErrorNotFound, "due config is soft-deleted")
+
+	var retrieved db.OIDCClientConfig
+	tx := conn.Where("id = ?", due.ID).Where("deleted = 1").First(&retrieved)
+	require.NoError(t, tx.Error)
+
+	for _, config := range []db.OIDCClientConfig{notDue, unscheduled} {
+		_, err := db.GetOIDCClientConfig(ctx, conn, config.ID)
+		require.NoError(t, err, "config %s is not due", config.ID)
+	}
 }
 
-func TestDeleteOIDCClientConfig(t *testing.T) {
+func TestOrphanedOIDCClientConfigs(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
 
-	t.Run("returns not found, when record does not exist", func(t *testing.T) {
+	team, err := db.CreateTeam(ctx, conn, db.Team{
+		ID:            uuid.New(),
+		Name:          "Org with OIDC",
+		Slug:          uuid.New().String(),
+		MarkedDeleted: true,
+	})
+	require.NoError(t, err)
+
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		db.OIDCClientConfig{OrganizationID: uuid.New()},
+		db.OIDCClientConfig{OrganizationID: team.ID},
+	)
+	orphaned, owned := configs[0], configs[1]
+
+	listed, err := db.ListOrphanedOIDCClientConfigs(ctx, conn, 1000)
+	require.NoError(t, err)
+
+	ids := map[uuid.UUID]bool{}
+	for _, config := range listed {
+		ids[config.ID] = true
+	}
+	require.True(t, ids[orphaned.ID])
+	require.False(t, ids[owned.ID], "configs of teams marked as deleted are not orphaned")
+
+	deleted, err := db.DeleteOrphanedOIDCClientConfigs(ctx, conn)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, deleted, int64(1))
+
+	_, err = db.GetOIDCClientConfig(ctx, conn, orphaned.ID)
+	require.ErrorIs(t, err, db.ErrorNotFound)
+
+	_, err = db.GetOIDCClientConfig(ctx, conn, owned.ID)
+	require.NoError(t, err)
+}
+
+func TestGetOIDCClientConfigByOrgSlug(t *testing.T) {
+
+	t.Run("not found when team has no config", func(t *testing.T) {
 		conn := dbtest.ConnectForTests(t)
-		orgID := uuid.New()
 
-		err := db.DeleteOIDCClientConfig(context.Background(), conn, uuid.New(), orgID)
+		team, err := db.CreateTeam(context.Background(), conn, db.Team{
+			ID:   uuid.New(),
+			Name: "Org without OIDC",
+			Slug: uuid.New().String(),
+		})
+		require.NoError(t, err)
+
+		_, err = db.GetOIDCClientConfigByOrgSlug(context.Background(), conn, team.Slug)
 		require.Error(t, err)
 		require.ErrorIs(t, err, db.ErrorNotFound)
 	})
 
-	t.Run("marks record deleted", func(t *testing.T) {
+	t.Run("retrieves config for team slug", func(t *testing.T) {
 		conn := dbtest.ConnectForTests(t)
-		orgID := uuid.New()
 
+		team, err := db.CreateTeam(context.Background(), conn, db.Team{
+			ID:   uuid.New(),
+			Name: "Org with OIDC",
+			Slug: uuid.New().String(),
+		})
+		require.NoError(t, err)
+
+		dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+			OrganizationID: team.ID,
+		})
 		created := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
-			OrganizationID: orgID,
+			OrganizationID: team.ID,
+			Active:         true,
 		})[0]
 
-		err := db.DeleteOIDCClientConfig(context.Background(), conn, created.ID, created.OrganizationID)
+		retrieved, err := db.GetOIDCClientConfigByOrgSlug(context.Background(), conn, team.Slug)
 		require.NoError(t, err)
-
-		// Delete only sets the `deleted` field, verify that's true
-		var retrieved db.OIDCClientConfig
-		tx := conn.Where("id = ?", created.ID).Where("deleted = 1").First(&retrieved)
-		require.NoError(t, tx.Error)
+		require.Equal(t, created, retrieved)
 	})
 
-}
+	t.Run("not found when config is inactive", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
 
-func TestGetOIDCClientConfigForOrganization(t *testing.T) {
+		team, err := db.CreateTeam(context.Background(), conn, db.Team{
+			ID:   uuid.New(),
+			Name: "Org with inactive OIDC",
+			Slug: uuid.New().String(),
+		})
+		require.NoError(t, err)
 
-	t.Run("not found when config does not exist", func(t *testing.T) {
-		conn := dbtest.ConnectForTests(t)
+		created := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+			OrganizationID: team.ID,
+		})[0]
 
-		_, err := db.GetOIDCClientConfigForOrganization(context.Background(), conn, uuid.New(), uuid.New())
-		require.Error(t, err)
+		_, err = db.GetOIDCClientConfigByOrgSlug(context.Background(), conn, team.Slug)
 		require.ErrorIs(t, err, db.ErrorNotFound)
+
+		retrieved, err := db.GetAnyOIDCClientConfigByOrgSlug(context.Background(), conn, team.Slug)
+		require.NoError(t, err)
+		require.Equal(t, created, retrieved)
 	})
 
-	t.Run("retrieves config which exists", func(t *testing.T) {
+	t.Run("not found when config is deleted", func(t *testing.T) {
 		conn := dbtest.ConnectForTests(t)
 
-		orgID := uuid.New()
+		team, err := db.CreateTeam(context.Background(), conn, db.Team{
+			ID:   uuid.New(),
+			Name: "Org with deleted OIDC",
+			Slug: uuid.New().String(),
+		})
+		require.NoError(t, err)
 
 		created := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
-			OrganizationID: orgID,
+			OrganizationID: team.ID,
+			Active:         true,
 		})[0]
+		require.NoError(t, db.DeleteOIDCClientConfig(context.Background(), conn, created.ID, team.ID))
 
-		retrieved, err := db.GetOIDCClientConfigForOrganization(context.Background(), conn, created.ID, created.OrganizationID)
+		_, err = db.GetAnyOIDCClientConfigByOrgSlug(context.Background(), conn, team.Slug)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+	})
+
+	t.Run("not found when team is deleted", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		team, err := db.CreateTeam(context.Background(), conn, db.Team{
+			ID:            uuid.New(),
+			Name:          "Deleted org with OIDC",
+			Slug:          uuid.New().String(),
+			MarkedDeleted: true,
+		})
 		require.NoError(t, err)
 
-		require.Equal(t, created, retrieved)
+		dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+			OrganizationID: team.ID,
+			Active:         true,
+		})
+
+		_, err = db.GetOIDCClientConfigByOrgSlug(context.Background(), conn, team.Slug)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+
+		_, err = db.GetAnyOIDCClientConfigByOrgSlug(context.Background(), conn, team.Slug)
+		require.ErrorIs(t, err, db.ErrorNotFound)
 	})
 
 }
 
-func TestActivateClientConfig(t *testing.T) {
+func TestDiagnoseOIDCClientConfigForOrganization(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
 
-	t.Run("not found when config does not exist", func(t *testing.T) {
-		conn := dbtest.ConnectForTests(t)
+	team, err := db.CreateTeam(ctx, conn, db.Team{
+		ID:   uuid.New(),
+		Name: "Org with OIDC",
+		Slug: uuid.New().String(),
+	})
+	require.NoError(t, err)
 
-		err := db.ActivateClientConfig(context.Background(), conn, uuid.New())
-		require.Error(t, err)
-		require.ErrorIs(t, err, db.ErrorNotFound)
+	undecryptableData, err := db.NewEncryptedJSON[db.OIDCSpec](db.EncryptedData{
+		EncodedData: "garbage",
+		Metadata:    db.CipherMetadata{Name: "unknown", Version: 99},
 	})
+	require.NoError(t, err)
 
-	t.Run("config which exists is marked as active", func(t *testing.T) {
+	// the slug join resolves to the lowest ID, which is the inactive config
+	ids := []uuid.UUID{uuid.New(), uuid.New()}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	inactiveID, activeID := ids[0], ids[1]
+
+	dbtest.CreateUndecryptableOIDCClientConfigs(t, conn, db.OIDCClientConfig{ID: inactiveID, OrganizationID: team.ID, Data: undecryptableData})
+	dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{ID: activeID, OrganizationID: team.ID, Active: true})
+
+	diagnosis, err := db.DiagnoseOIDCClientConfigForOrganization(ctx, conn, cipher, team.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, diagnosis.ActiveConfigs)
+	require.Equal(t, activeID, diagnosis.ActiveConfigID)
+	require.Equal(t, inactiveID, diagnosis.SlugConfigID)
+	require.False(t, diagnosis.SlugConfigActive)
+	require.False(t, diagnosis.SlugMatchesActive)
+	require.Len(t, diagnosis.DecryptionFailures, 1)
+	require.Equal(t, inactiveID, diagnosis.DecryptionFailures[0].ID)
+	require.ErrorIs(t, diagnosis.DecryptionFailures[0].Err, db.ErrDataDecryption)
+
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, inactiveID, team.ID))
+
+	diagnosis, err = db.DiagnoseOIDCClientConfigForOrganization(ctx, conn, cipher, team.ID)
+	require.NoError(t, err)
+	require.Equal(t, activeID, diagnosis.SlugConfigID)
+	require.True(t, diagnosis.SlugMatchesActive)
+	require.Empty(t, diagnosis.DecryptionFailures)
+
+	_, err = db.DiagnoseOIDCClientConfigForOrganization(ctx, conn, cipher, uuid.New())
+	require.ErrorIs(t, err, db.ErrorNotFound)
+}
+
+func TestActivateOIDCClientConfigs(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	orgA, orgB, orgC := uuid.New(), uuid.New(), uuid.New()
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgA}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgA}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgB}),
+	)
+	previouslyActive, toActivateA, toActivateB := configs[0], configs[1], configs[2]
+	require.NoError(t, db.ActivateClientConfig(ctx, conn, previouslyActive.ID))
+
+	results := db.ActivateOIDCClientConfigs(ctx, conn, []db.OIDCClientConfigActivation{
+		{ID: toActivateA.ID, OrganizationID: orgA},
+		// does not exist
+		{ID: uuid.New(), OrganizationID: orgC},
+		// belongs to another organization
+		{ID: toActivateB.ID, OrganizationID: orgC},
+		{ID: toActivateB.ID, OrganizationID: orgB},
+		// second activation for the same organization within the batch
+		{ID: previouslyActive.ID, OrganizationID: orgA},
+	})
+	require.Len(t, results, 5)
+
+	require.NoError(t, results[0].Err)
+	require.ErrorIs(t, results[1].Err, db.ErrorNotFound)
+	require.ErrorIs(t, results[2].Err, db.ErrorNotFound)
+	require.NoError(t, results[3].Err)
+	require.Error(t, results[4].Err)
+
+	for _, expected := range []struct {
+		ID     uuid.UUID
+		Active bool
+	}{
+		{ID: previouslyActive.ID, Active: false},
+		{ID: toActivateA.ID, Active: true},
+		{ID: toActivateB.ID, Active: true},
+	} {
+		retrieved, err := db.GetOIDCClientConfig(ctx, conn, expected.ID)
+		require.NoError(t, err)
+		require.Equal(t, expected.Active, retrieved.Active)
+	}
+}
+
+func TestAutoActivateSingleConfigPerOrganization(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	single, none, multiple, deletedSibling := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: single}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: multiple}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: multiple}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: deletedSibling}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: deletedSibling}),
+	)
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, configs[4].ID, deletedSibling))
+
+	results := db.AutoActivateSingleConfigPerOrganization(ctx, conn, []uuid.UUID{single, none, multiple, deletedSibling, uuid.Nil})
+	require.Len(t, results, 5)
+
+	require.Equal(t, db.OIDCClientConfigAutoActivationResult{
+		OrganizationID: single,
+		Outcome:        db.OIDCClientConfigAutoActivationActivated,
+		ID:             configs[0].ID,
+	}, results[0])
+	require.Equal(t, db.OIDCClientConfigAutoActivationResult{
+		OrganizationID: none,
+		Outcome:        db.OIDCClientConfigAutoActivationSkippedZero,
+	}, results[1])
+	require.Equal(t, db.OIDCClientConfigAutoActivationResult{
+		OrganizationID: multiple,
+		Outcome:        db.OIDCClientConfigAutoActivationSkippedMultiple,
+	}, results[2])
+	require.Equal(t, db.OIDCClientConfigAutoActivationActivated, results[3].Outcome, "deleted configs must not count")
+	require.Equal(t, configs[3].ID, results[3].ID)
+	require.Equal(t, db.OIDCClientConfigAutoActivationError, results[4].Outcome)
+	require.Error(t, results[4].Err)
+
+	for _, expected := range []struct {
+		ID     uuid.UUID
+		Active bool
+	}{
+		{ID: configs[0].ID, Active: true},
+		{ID: configs[1].ID, Active: false},
+		{ID: configs[2].ID, Active: false},
+		{ID: configs[3].ID, Active: true},
+	} {
+		retrieved, err := db.GetOIDCClientConfig(ctx, conn, expected.ID)
+		require.NoError(t, err)
+		require.Equal(t, expected.Active, retrieved.Active)
+	}
+}
+
+func TestSetSoleActiveOIDCClientConfig(t *testing.T) {
+	ctx := context.Background()
+	cipher := dbtest.CipherSet(t)
+
+	spec := db.OIDCSpec{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://gitpod.io/iam/oidc/callback",
+	}
+
+	requireSole := func(t *testing.T, conn *gorm.DB, orgID, expected uuid.UUID) {
+		t.Helper()
+
+		listed, err := db.ListOIDCClientConfigsForOrganization(ctx, conn, orgID)
+		require.NoError(t, err)
+		require.Len(t, listed, 1)
+		require.Equal(t, expected, listed[0].ID)
+		require.True(t, listed[0].Active)
+	}
+
+	t.Run("keeps the active config and soft-deletes the others", func(t *testing.T) {
 		conn := dbtest.ConnectForTests(t)
+		orgID := uuid.New()
 
-		config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
-			OrganizationID: uuid.New(),
-			Active:         false,
-		})[0]
-		configID := config.ID
+		configs := dbtest.CreateOIDCClientConfigs(t, conn,
+			dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+			dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Active: true}),
+			dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+		)
+		active := configs[1]
 
-		config, err := db.GetOIDCClientConfig(context.Background(), conn, configID)
+		result, err := db.SetSoleActiveOIDCClientConfig(ctx, conn, cipher, orgID, "https://accounts.google.com", spec)
 		require.NoError(t, err)
-		require.Equal(t, false, config.Active)
+		require.Equal(t, active.ID, result.ID)
+		require.True(t, result.Active)
+		require.Equal(t, "https://accounts.google.com", result.Issuer)
 
-		err = db.ActivateClientConfig(context.Background(), conn, configID)
+		decoded, err := db.DecodeSpec(cipher, result)
 		require.NoError(t, err)
+		require.Equal(t, spec.Fingerprint(), decoded.Fingerprint())
 
-		config2, err := db.GetOIDCClientConfig(context.Background(), conn, configID)
+		requireSole(t, conn, orgID, active.ID)
+
+		for _, config := range []db.OIDCClientConfig{configs[0], configs[2]} {
+			_, err := db.GetOIDCClientConfig(ctx, conn, config.ID)
+			require.ErrorIs(t, err, db.ErrorNotFound)
+
+			var retrieved db.OIDCClientConfig
+			tx := conn.Where("id = ?", config.ID).Where("deleted = 1").First(&retrieved)
+			require.NoError(t, tx.Error)
+		}
+	})
+
+	t.Run("keeps a config with the same issuer, when none is active", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+		orgID := uuid.New()
+
+		configs := dbtest.CreateOIDCClientConfigs(t, conn,
+			dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Issuer: "https://other.example.com"}),
+			dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Issuer: "https://accounts.google.com"}),
+		)
+
+		result, err := db.SetSoleActiveOIDCClientConfig(ctx, conn, cipher, orgID, "https://accounts.google.com", spec)
 		require.NoError(t, err)
-		require.Equal(t, true, config2.Active)
+		require.Equal(t, configs[1].ID, result.ID)
 
-		err = db.ActivateClientConfig(context.Background(), conn, configID)
+		requireSole(t, conn, orgID, configs[1].ID)
+	})
+
+	t.Run("creates a config, when the organization has none", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+		orgID := uuid.New()
+
+		result, err := db.SetSoleActiveOIDCClientConfig(ctx, conn, cipher, orgID, "https://accounts.google.com", spec)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			dbtest.HardDeleteOIDCClientConfigs(t, result.ID.String())
+		})
+
+		require.NotEqual(t, uuid.Nil, result.ID)
+		requireSole(t, conn, orgID, result.ID)
+	})
+
+	t.Run("rejects an invalid spec", func(t *testing.T) {
+		conn := dbtest.ConnectForTests(t)
+
+		_, err := db.SetSoleActiveOIDCClientConfig(ctx, conn, cipher, uuid.New(), "", spec)
+		var validationErr *db.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+}
+
+func TestSwapActiveOIDCClientConfig(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	orgID := uuid.New()
+
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID, Active: true}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: orgID}),
+	)
+	previous, target := configs[0], configs[1]
+
+	requireActive := func(t *testing.T, expected uuid.UUID) {
+		t.Helper()
+
+		listed, err := db.ListOIDCClientConfigsForOrganization(ctx, conn, orgID)
 		require.NoError(t, err)
+		for _, config := range listed {
+			require.Equal(t, config.ID == expected, config.Active, "config %s", config.ID)
+		}
+	}
+
+	t.Run("target becomes the only active config", func(t *testing.T) {
+		require.NoError(t, db.SwapActiveOIDCClientConfig(ctx, conn, orgID, target.ID))
+		requireActive(t, target.ID)
+	})
+
+	t.Run("target of another organization is not found", func(t *testing.T) {
+		other := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: uuid.New()})[0]
+
+		err := db.SwapActiveOIDCClientConfig(ctx, conn, orgID, other.ID)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+		requireActive(t, target.ID)
+	})
+
+	t.Run("deleted target is not found", func(t *testing.T) {
+		require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, previous.ID, orgID))
+
+		err := db.SwapActiveOIDCClientConfig(ctx, conn, orgID, previous.ID)
+		require.ErrorIs(t, err, db.ErrorNotFound)
+		requireActive(t, target.ID)
 	})
+}
 
+func TestFindDuplicateClientSecrets(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+
+	encrypt := func(secret string) db.EncryptedJSON[db.OIDCSpec] {
+		data, err := db.EncryptJSON(cipher, db.OIDCSpec{ClientID: "client-id", ClientSecret: secret})
+		require.NoError(t, err)
+		return data
+	}
+
+	sharedSecret := "shared-" + uuid.New().String()
+	configs := dbtest.CreateOIDCClientConfigs(t, conn,
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Data: encrypt(sharedSecret)}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Data: encrypt(sharedSecret)}),
+		dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{OrganizationID: uuid.New(), Data: encrypt("unique-" + uuid.New().String())}),
+	)
+
+	groups, err := db.FindDuplicateClientSecrets(ctx, conn, cipher, 100)
+	require.NoError(t, err)
+
+	var group *db.DuplicateClientSecretGroup
+	for i, g := range groups {
+		require.NotContains(t, g.SecretHash, sharedSecret)
+		if len(g.ConfigIDs) > 0 && (g.ConfigIDs[0] == configs[0].ID || g.ConfigIDs[0] == configs[1].ID) {
+			group = &groups[i]
+		}
+		require.NotContains(t, g.ConfigIDs, configs[2].ID)
+	}
+	require.NotNil(t, group, "configs sharing a secret must be grouped")
+	require.ElementsMatch(t, []uuid.UUID{configs[0].ID, configs[1].ID}, group.ConfigIDs)
+}
+
+func TestOIDCClientConfig_CanceledContext(t *testing.T) {
+	conn := dbtest.ConnectForTests(t)
+	cipher := dbtest.CipherSet(t)
+	config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: uuid.New()})[0]
+
+	for name, fn := range map[string]func(ctx context.Context) error{
+		"CreateOIDCClientConfig": func(ctx context.Context) error {
+			_, err := db.CreateOIDCClientConfig(ctx, conn, cipher, dbtest.NewOIDCClientConfig(t, db.OIDCClientConfig{}), 0)
+			return err
+		},
+		"CreateOIDCClientConfigAutoID": func(ctx context.Context) error {
+			_, err := db.CreateOIDCClientConfigAutoID(ctx, conn, cipher, uuid.New(), "issuer", db.OIDCSpec{})
+			return err
+		},
+		"UpdateOIDCClientConfig": func(ctx context.Context) error {
+			_, err := db.UpdateOIDCClientConfig(ctx, conn, cipher, config.ID, config.OrganizationID, "issuer", db.OIDCSpec{})
+			return err
+		},
+		"GetOIDCClientConfig": func(ctx context.Context) error {
+			_, err := db.GetOIDCClientConfig(ctx, conn, config.ID)
+			return err
+		},
+		"GetOIDCClientConfigForOrganization": func(ctx context.Context) error {
+			_, err := db.GetOIDCClientConfigForOrganization(ctx, conn, config.ID, config.OrganizationID)
+			return err
+		},
+		"GetDecodedOIDCClientConfigForOrganization": func(ctx context.Context) error {
+			_, _, err := db.GetDecodedOIDCClientConfigForOrganization(ctx, conn, cipher, config.ID, config.OrganizationID)
+			return err
+		},
+		"GetOIDCClientConfigRedirectURL": func(ctx context.Context) error {
+			_, err := db.GetOIDCClientConfigRedirectURL(ctx, conn, cipher, config.ID)
+			return err
+		},
+		"ListOIDCClientConfigsForOrganization": func(ctx context.Context) error {
+			_, err := db.ListOIDCClientConfigsForOrganization(ctx, conn, config.OrganizationID)
+			return err
+		},
+		"GroupOIDCClientConfigsByIssuer": func(ctx context.Context) error {
+			_, err := db.GroupOIDCClientConfigsByIssuer(ctx, conn, config.OrganizationID)
+			return err
+		},
+		"ListOIDCClientConfigsWithSecretExpiringBefore": func(ctx context.Context) error {
+			_, err := db.ListOIDCClientConfigsWithSecretExpiringBefore(ctx, conn, cipher, time.Now(), 10)
+			return err
+		},
+		"DeleteOIDCClientConfig": func(ctx context.Context) error {
+			return db.DeleteOIDCClientConfig(ctx, conn, config.ID, config.OrganizationID)
+		},
+		"HardDeleteOIDCClientConfig": func(ctx context.Context) error {
+			return db.HardDeleteOIDCClientConfig(ctx, conn, config.ID, config.OrganizationID)
+		},
+		"GetOIDCClientConfigByOrgSlug": func(ctx context.Context) error {
+			_, err := db.GetOIDCClientConfigByOrgSlug(ctx, conn, "slug")
+			return err
+		},
+		"ActivateClientConfig": func(ctx context.Context) error {
+			return db.ActivateClientConfig(ctx, conn, config.ID)
+		},
+		"ActivateOIDCClientConfigs": func(ctx context.Context) error {
+			return db.ActivateOIDCClientConfigs(ctx, conn, []db.OIDCClientConfigActivation{{ID: config.ID, OrganizationID: config.OrganizationID}})[0].Err
+		},
+		"FindDuplicateClientSecrets": func(ctx context.Context) error {
+			_, err := db.FindDuplicateClientSecrets(ctx, conn, cipher, 10)
+			return err
+		},
+		"CheckRedirectURLConflict": func(ctx context.Context) error {
+			return db.CheckRedirectURLConflict(ctx, conn, cipher, config.OrganizationID, uuid.Nil, "https://gitpod.io/callback")
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			start := time.Now()
+			err := fn(ctx)
+			require.ErrorIs(t, err, context.Canceled)
+			require.Less(t, time.Since(start), time.Second, "canceled context must not block")
+		})
+	}
+
+	// the config is still present, no operation went through
+	_, err := db.GetOIDCClientConfig(context.Background(), conn, config.ID)
+	require.NoError(t, err)
 }
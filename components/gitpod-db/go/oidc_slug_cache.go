@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// slugConfigCacheMaxEntries bounds the number of slugs a SlugConfigCache holds.
+const slugConfigCacheMaxEntries = 1000
+
+// SlugConfigCache resolves the active OIDC Client Config of an organization by its slug, and caches it for a TTL.
+// Cached configs keep their encrypted Data, callers decrypt it like for configs read from the database.
+type SlugConfigCache struct {
+	conn    *gorm.DB
+	configs *ttlCache[string, OIDCClientConfig]
+}
+
+func NewSlugConfigCache(conn *gorm.DB, ttl time.Duration) *SlugConfigCache {
+	return &SlugConfigCache{
+		conn:    conn,
+		configs: newTTLCache[string, OIDCClientConfig](ttl, slugConfigCacheMaxEntries),
+	}
+}
+
+// Get returns the active config of the organization with the slug. Lookup failures, including ErrorNotFound, are not
+// cached.
+func (c *SlugConfigCache) Get(ctx context.Context, slug string) (OIDCClientConfig, error) {
+	if config, ok := c.configs.get(slug); ok {
+		return config, nil
+	}
+
+	config, err := getOIDCClientConfigByOrgSlug(ctx, c.conn, slug, true)
+	if err != nil {
+		return OIDCClientConfig{}, err
+	}
+
+	c.configs.set(slug, config)
+	return config, nil
+}
+
+// Invalidate removes the slug from the cache. Call it after changing the configs of the organization.
+func (c *SlugConfigCache) Invalidate(slug string) {
+	c.configs.delete(slug)
+}
+
+// InvalidateOrganization removes the slug of the organization from the cache, like Invalidate. The slug is read from the
+// organization, an organization which does not exist has nothing cached.
+func (c *SlugConfigCache) InvalidateOrganization(ctx context.Context, organizationID uuid.UUID) error {
+	team, err := GetTeam(ctx, c.conn, organizationID)
+	if errors.Is(err, ErrorNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Invalidate(team.Slug)
+	return nil
+}
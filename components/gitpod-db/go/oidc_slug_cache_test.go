@@ -0,0 +1,108 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	db "github.com/gitpod-io/gitpod/components/gitpod-db/go"
+	"github.com/gitpod-io/gitpod/components/gitpod-db/go/dbtest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupSlugConfigCacheTest(t *testing.T) (*gorm.DB, db.Team, db.OIDCClientConfig) {
+	t.Helper()
+
+	conn := dbtest.ConnectForTests(t)
+	team, err := db.CreateTeam(context.Background(), conn, db.Team{
+		ID:   uuid.New(),
+		Name: "Org with OIDC",
+		Slug: uuid.New().String(),
+	})
+	require.NoError(t, err)
+
+	config := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{
+		OrganizationID: team.ID,
+		Active:         true,
+	})[0]
+
+	return conn, team, config
+}
+
+func TestSlugConfigCache_HitWithinTTL(t *testing.T) {
+	ctx := context.Background()
+	conn, team, config := setupSlugConfigCacheTest(t)
+	cache := db.NewSlugConfigCache(conn, time.Minute)
+
+	first, err := cache.Get(ctx, team.Slug)
+	require.NoError(t, err)
+	require.Equal(t, config.ID, first.ID)
+	require.Equal(t, config.Data, first.Data, "cached configs keep the encrypted spec")
+
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, config.ID, team.ID))
+
+	second, err := cache.Get(ctx, team.Slug)
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+}
+
+func TestSlugConfigCache_Expiry(t *testing.T) {
+	ctx := context.Background()
+	conn, team, config := setupSlugConfigCacheTest(t)
+	cache := db.NewSlugConfigCache(conn, 20*time.Millisecond)
+
+	_, err := cache.Get(ctx, team.Slug)
+	require.NoError(t, err)
+
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, config.ID, team.ID))
+	time.Sleep(40 * time.Millisecond)
+
+	_, err = cache.Get(ctx, team.Slug)
+	require.ErrorIs(t, err, db.ErrorNotFound)
+}
+
+func TestSlugConfigCache_Invalidate(t *testing.T) {
+	ctx := context.Background()
+	conn, team, config := setupSlugConfigCacheTest(t)
+	cache := db.NewSlugConfigCache(conn, time.Minute)
+
+	_, err := cache.Get(ctx, team.Slug)
+	require.NoError(t, err)
+
+	replacement := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: team.ID})[0]
+	results := db.ActivateOIDCClientConfigs(ctx, conn, []db.OIDCClientConfigActivation{{ID: replacement.ID, OrganizationID: team.ID}})
+	require.NoError(t, results[0].Err)
+
+	cached, err := cache.Get(ctx, team.Slug)
+	require.NoError(t, err)
+	require.Equal(t, config.ID, cached.ID)
+
+	cache.Invalidate(team.Slug)
+
+	fresh, err := cache.Get(ctx, team.Slug)
+	require.NoError(t, err)
+	require.Equal(t, replacement.ID, fresh.ID)
+}
+
+func TestSlugConfigCache_InvalidateOrganization(t *testing.T) {
+	ctx := context.Background()
+	conn, team, config := setupSlugConfigCacheTest(t)
+	cache := db.NewSlugConfigCache(conn, time.Minute)
+
+	_, err := cache.Get(ctx, team.Slug)
+	require.NoError(t, err)
+
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, config.ID, team.ID))
+	require.NoError(t, cache.InvalidateOrganization(ctx, team.ID))
+
+	_, err = cache.Get(ctx, team.Slug)
+	require.ErrorIs(t, err, db.ErrorNotFound)
+
+	require.NoError(t, cache.InvalidateOrganization(ctx, uuid.New()), "unknown organizations have nothing cached")
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package db
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OIDCSpecCache keeps decrypted specs in memory, to avoid decrypting the same config on every request.
+// Entries are keyed by config ID and only used while the _lastModified of the config is unchanged and the TTL has not passed.
+// A nil *OIDCSpecCache is valid and disables caching.
+type OIDCSpecCache struct {
+	enabled bool
+	entries *ttlCache[uuid.UUID, oidcSpecCacheEntry]
+}
+
+type oidcSpecCacheEntry struct {
+	lastModified time.Time
+	spec         OIDCSpec
+}
+
+// NewOIDCSpecCache returns a cache holding at most maxEntries specs, each for at most ttl.
+func NewOIDCSpecCache(ttl time.Duration, maxEntries int) *OIDCSpecCache {
+	return &OIDCSpecCache{
+		enabled: ttl > 0 && maxEntries > 0,
+		entries: newTTLCache[uuid.UUID, oidcSpecCacheEntry](ttl, maxEntries),
+	}
+}
+
+// DecodeSpec returns the decrypted spec of the config, from the cache if possible.
+func (c *OIDCSpecCache) DecodeSpec(decryptor Decryptor, config OIDCClientConfig) (OIDCSpec, error) {
+	if c == nil || !c.enabled {
+		return DecodeSpec(decryptor, config)
+	}
+
+	if entry, ok := c.entries.get(config.ID); ok && entry.lastModified.Equal(config.LastModified) {
+		return copyOIDCSpec(entry.spec), nil
+	}
+
+	spec, err := DecodeSpec(decryptor, config)
+	if err != nil {
+		return OIDCSpec{}, err
+	}
+
+	c.entries.set(config.ID, oidcSpecCacheEntry{
+		lastModified: config.LastModified,
+		spec:         copyOIDCSpec(spec),
+	})
+
+	return spec, nil
+}
+
+// copyOIDCSpec returns a copy of the spec, which does not share slices or maps with the original.
+func copyOIDCSpec(spec OIDCSpec) OIDCSpec {
+	if spec.Scopes != nil {
+		spec.Scopes = append([]string(nil), spec.Scopes...)
+	}
+	if spec.Metadata != nil {
+		metadata := make(map[string]string, len(spec.Metadata))
+		for k, v := range spec.Metadata {
+			metadata[k] = v
+		}
+		spec.Metadata = metadata
+	}
+	if spec.SecretExpiresAt != nil {
+		expiresAt := *spec.SecretExpiresAt
+		spec.SecretExpiresAt = &expiresAt
+	}
+	if spec.UsePKCE != nil {
+		usePKCE := *spec.UsePKCE
+		spec.UsePKCE = &usePKCE
+	}
+
+	return spec
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package db_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	db "github.com/gitpod-io/gitpod/components/gitpod-db/go"
+	"github.com/gitpod-io/gitpod/components/gitpod-db/go/dbtest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type countingDecryptor struct {
+	db.Decryptor
+	calls int
+}
+
+func (d *countingDecryptor) Decrypt(data db.EncryptedData) ([]byte, error) {
+	d.calls++
+	return d.Decryptor.Decrypt(data)
+}
+
+func newCachedConfig(t *testing.T, spec db.OIDCSpec) db.OIDCClientConfig {
+	t.Helper()
+
+	data, err := db.EncryptJSON(dbtest.CipherSet(t), spec)
+	require.NoError(t, err)
+
+	return db.OIDCClientConfig{
+		ID:           uuid.New(),
+		Data:         data,
+		LastModified: time.Now().UTC(),
+	}
+}
+
+func TestOIDCSpecCache_Hit(t *testing.T) {
+	decryptor := &countingDecryptor{Decryptor: dbtest.CipherSet(t)}
+	cache := db.NewOIDCSpecCache(time.Minute, 10)
+	usePKCE := true
+	expiresAt := time.Now().UTC().Truncate(time.Second)
+	spec := func() db.OIDCSpec {
+		usePKCE, expiresAt := usePKCE, expiresAt
+		return db.OIDCSpec{
+			ClientID:        "client",
+			Scopes:          []string{"openid"},
+			Metadata:        map[string]string{"key": "value"},
+			SecretExpiresAt: &expiresAt,
+			UsePKCE:         &usePKCE,
+		}
+	}
+	config := newCachedConfig(t, spec())
+
+	first, err := cache.DecodeSpec(decryptor, config)
+	require.NoError(t, err)
+	first.Scopes[0] = "mutated"
+	first.Metadata["key"] = "mutated"
+	*first.SecretExpiresAt = first.SecretExpiresAt.Add(time.Hour)
+	*first.UsePKCE = false
+
+	second, err := cache.DecodeSpec(decryptor, config)
+	require.NoError(t, err)
+	require.Equal(t, spec(), second)
+	require.Equal(t, 1, decryptor.calls)
+}
+
+func TestOIDCSpecCache_InvalidatedOnLastModifiedChange(t *testing.T) {
+	decryptor := &countingDecryptor{Decryptor: dbtest.CipherSet(t)}
+	cache := db.NewOIDCSpecCache(time.Minute, 10)
+	config := newCachedConfig(t, db.OIDCSpec{ClientID: "before"})
+
+	_, err := cache.DecodeSpec(decryptor, config)
+	require.NoError(t, err)
+
+	updated := newCachedConfig(t, db.OIDCSpec{ClientID: "after"})
+	updated.ID = config.ID
+	updated.LastModified = config.LastModified.Add(time.Second)
+
+	spec, err := cache.DecodeSpec(decryptor, updated)
+	require.NoError(t, err)
+	require.Equal(t, "after", spec.ClientID)
+	require.Equal(t, 2, decryptor.calls)
+}
+
+func TestOIDCSpecCache_TTLExpiry(t *testing.T) {
+	decryptor := &countingDecryptor{Decryptor: dbtest.CipherSet(t)}
+	cache := db.NewOIDCSpecCache(20*time.Millisecond, 10)
+	config := newCachedConfig(t, db.OIDCSpec{ClientID: "client"})
+
+	_, err := cache.DecodeSpec(decryptor, config)
+	require.NoError(t, err)
+
+	time.Sleep(40 * time.Millisecond)
+
+	_, err = cache.DecodeSpec(decryptor, config)
+	require.NoError(t, err)
+	require.Equal(t, 2, decryptor.calls)
+}
+
+func TestOIDCSpecCache_Bounded(t *testing.T) {
+	decryptor := &countingDecryptor{Decryptor: dbtest.CipherSet(t)}
+	cache := db.NewOIDCSpecCache(time.Minute, 1)
+	a := newCachedConfig(t, db.OIDCSpec{ClientID: "a"})
+	b := newCachedConfig(t, db.OIDCSpec{ClientID: "b"})
+
+	for _, config := range []db.OIDCClientConfig{a, b, a} {
+		_, err := cache.DecodeSpec(decryptor, config)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, decryptor.calls, "a must have been evicted when b was added")
+}
+
+func TestOIDCSpecCache_DisabledWhenNil(t *testing.T) {
+	decryptor := &countingDecryptor{Decryptor: dbtest.CipherSet(t)}
+	var cache *db.OIDCSpecCache
+	config := newCachedConfig(t, db.OIDCSpec{ClientID: "client"})
+
+	for i := 0; i < 2; i++ {
+		spec, err := cache.DecodeSpec(decryptor, config)
+		require.NoError(t, err)
+		require.Equal(t, "client", spec.ClientID)
+	}
+	require.Equal(t, 2, decryptor.calls)
+}
+
+func TestOIDCSpecCache_ConcurrentUse(t *testing.T) {
+	cipher := dbtest.CipherSet(t)
+	cache := db.NewOIDCSpecCache(time.Minute, 2)
+	configs := []db.OIDCClientConfig{
+		newCachedConfig(t, db.OIDCSpec{ClientID: "a"}),
+		newCachedConfig(t, db.OIDCSpec{ClientID: "b"}),
+		newCachedConfig(t, db.OIDCSpec{ClientID: "c"}),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			config := configs[i%len(configs)]
+			spec, err := cache.DecodeSpec(cipher, config)
+			require.NoError(t, err)
+			require.NotEmpty(t, spec.ClientID)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -75,3 +75,54 @@ func GetTeamBySlug(ctx context.Context, conn *gorm.DB, slug string) (Team, error
 
 	return team, nil
 }
+
+func GetTeam(ctx context.Context, conn *gorm.DB, id uuid.UUID) (Team, error) {
+	if id == uuid.Nil {
+		return Team{}, fmt.Errorf("ID is required")
+	}
+
+	var team Team
+
+	tx := conn.WithContext(ctx).
+		Where("id = ?", id.String()).
+		First(&team)
+
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return Team{}, fmt.Errorf("Team with ID %s does not exist: %w", id.String(), ErrorNotFound)
+		}
+		return Team{}, fmt.Errorf("Failed to retrieve team: %v", tx.Error)
+	}
+
+	return team, nil
+}
+
+// ListOrganizationsWithoutOIDCConfig returns a page of organizations, which are not deleted and have no non-deleted OIDC Client Config.
+func ListOrganizationsWithoutOIDCConfig(ctx context.Context, conn *gorm.DB, offset, limit int) ([]Team, error) {
+	if offset < 0 {
+		return nil, errors.New("offset must not be negative")
+	}
+	if limit <= 0 {
+		return nil, errors.New("limit must be positive")
+	}
+
+	var teams []Team
+
+	tx := conn.
+		WithContext(ctx).
+		Table(fmt.Sprintf("%s AS team", (&Team{}).TableName())).
+		Select("team.*").
+		Joins(fmt.Sprintf("LEFT JOIN %s AS config ON config.organizationId = team.id AND config.deleted = ?", (&OIDCClientConfig{}).TableName()), 0).
+		Where("config.id IS NULL").
+		Where("team.markedDeleted = ?", 0).
+		Where("team.deleted = ?", 0).
+		Order("team.id").
+		Offset(offset).
+		Limit(limit).
+		Find(&teams)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to list organizations without oidc client config: %w", tx.Error)
+	}
+
+	return teams, nil
+}
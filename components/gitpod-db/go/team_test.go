@@ -56,3 +56,46 @@ func Test_GetTeamBySlug(t *testing.T) {
 	require.Equal(t, team.Name, read.Name)
 	require.Equal(t, team.Slug, read.Slug)
 }
+
+func TestListOrganizationsWithoutOIDCConfig(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.ConnectForTests(t)
+
+	createTeam := func(markedDeleted bool) db.Team {
+		team, err := db.CreateTeam(ctx, conn, db.Team{
+			ID:            uuid.New(),
+			Name:          "Team",
+			Slug:          uuid.New().String(),
+			MarkedDeleted: markedDeleted,
+		})
+		require.NoError(t, err)
+		return team
+	}
+
+	withoutConfig := createTeam(false)
+	withConfig := createTeam(false)
+	withDeletedConfig := createTeam(false)
+	markedDeleted := createTeam(true)
+
+	dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: withConfig.ID})
+	deleted := dbtest.CreateOIDCClientConfigs(t, conn, db.OIDCClientConfig{OrganizationID: withDeletedConfig.ID})[0]
+	require.NoError(t, db.DeleteOIDCClientConfig(ctx, conn, deleted.ID, withDeletedConfig.ID))
+
+	// page through all results, other tests create teams too
+	found := map[uuid.UUID]bool{}
+	for offset := 0; ; offset += 100 {
+		page, err := db.ListOrganizationsWithoutOIDCConfig(ctx, conn, offset, 100)
+		require.NoError(t, err)
+		for _, team := range page {
+			found[team.ID] = true
+		}
+		if len(page) < 100 {
+			break
+		}
+	}
+
+	require.True(t, found[withoutConfig.ID])
+	require.True(t, found[withDeletedConfig.ID])
+	require.False(t, found[withConfig.ID])
+	require.False(t, found[markedDeleted.ID])
+}
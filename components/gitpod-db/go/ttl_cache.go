@@ -0,0 +1,92 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCache holds at most maxEntries values, each for at most ttl. It is safe for concurrent use.
+type ttlCache[K comparable, V any] struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[K]ttlCacheEntry[V]
+}
+
+type ttlCacheEntry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+func newTTLCache[K comparable, V any](ttl time.Duration, maxEntries int) *ttlCache[K, V] {
+	return &ttlCache[K, V]{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[K]ttlCacheEntry[V]),
+	}
+}
+
+// get returns the value of the key, unless it is missing or has expired.
+func (c *ttlCache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expiresAt) {
+		var zero V
+		return zero, false
+	}
+
+	return entry.value, true
+}
+
+// set stores the value of the key for the TTL, evicting entries if the cache is full.
+func (c *ttlCache[K, V]) set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = ttlCacheEntry[V]{
+		value:     value,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+// delete removes the key from the cache.
+func (c *ttlCache[K, V]) delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+// evict removes expired entries, or the entry closest to expiry if none has expired. Callers must hold c.mu.
+func (c *ttlCache[K, V]) evict(now time.Time) {
+	var (
+		oldestKey K
+		oldestExp time.Time
+	)
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldestExp.IsZero() || entry.expiresAt.Before(oldestExp) {
+			oldestKey, oldestExp = key, entry.expiresAt
+		}
+	}
+
+	if len(c.entries) >= c.maxEntries {
+		delete(c.entries, oldestKey)
+	}
+}
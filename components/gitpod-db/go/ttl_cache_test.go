@@ -0,0 +1,132 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTTLCache(t *testing.T, ttl time.Duration, maxEntries int) (*ttlCache[string, int], func(time.Duration)) {
+	t.Helper()
+
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	cache := newTTLCache[string, int](ttl, maxEntries)
+	cache.now = func() time.Time { return now }
+
+	return cache, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestTTLCache_GetWithinTTL(t *testing.T) {
+	cache, advance := newTestTTLCache(t, time.Minute, 10)
+
+	_, ok := cache.get("a")
+	require.False(t, ok)
+
+	cache.set("a", 1)
+	advance(59 * time.Second)
+
+	value, ok := cache.get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, value)
+}
+
+func TestTTLCache_Expiry(t *testing.T) {
+	cache, advance := newTestTTLCache(t, time.Minute, 10)
+
+	cache.set("a", 1)
+	advance(time.Minute)
+
+	_, ok := cache.get("a")
+	require.False(t, ok)
+}
+
+func TestTTLCache_SetRefreshesTTL(t *testing.T) {
+	cache, advance := newTestTTLCache(t, time.Minute, 10)
+
+	cache.set("a", 1)
+	advance(30 * time.Second)
+	cache.set("a", 2)
+	advance(45 * time.Second)
+
+	value, ok := cache.get("a")
+	require.True(t, ok)
+	require.Equal(t, 2, value)
+}
+
+func TestTTLCache_Delete(t *testing.T) {
+	cache, _ := newTestTTLCache(t, time.Minute, 10)
+
+	cache.set("a", 1)
+	cache.delete("a")
+
+	_, ok := cache.get("a")
+	require.False(t, ok)
+}
+
+func TestTTLCache_Bounded(t *testing.T) {
+	t.Run("evicts the entry closest to expiry", func(t *testing.T) {
+		cache, advance := newTestTTLCache(t, time.Minute, 2)
+
+		cache.set("a", 1)
+		advance(time.Second)
+		cache.set("b", 2)
+		cache.set("c", 3)
+
+		_, ok := cache.get("a")
+		require.False(t, ok)
+		for _, key := range []string{"b", "c"} {
+			_, ok := cache.get(key)
+			require.True(t, ok, key)
+		}
+	})
+
+	t.Run("evicts expired entries first", func(t *testing.T) {
+		cache, advance := newTestTTLCache(t, time.Minute, 2)
+
+		cache.set("a", 1)
+		advance(30 * time.Second)
+		cache.set("b", 2)
+		advance(45 * time.Second)
+		cache.set("c", 3)
+
+		require.Len(t, cache.entries, 2)
+		for _, key := range []string{"b", "c"} {
+			_, ok := cache.get(key)
+			require.True(t, ok, key)
+		}
+	})
+
+	t.Run("updating a key does not evict", func(t *testing.T) {
+		cache, _ := newTestTTLCache(t, time.Minute, 2)
+
+		cache.set("a", 1)
+		cache.set("b", 2)
+		cache.set("a", 3)
+
+		require.Len(t, cache.entries, 2)
+	})
+}
+
+func TestTTLCache_ConcurrentUse(t *testing.T) {
+	cache := newTTLCache[int, int](time.Minute, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cache.set(i%3, i)
+			cache.get(i % 3)
+			cache.delete((i + 1) % 3)
+		}(i)
+	}
+	wg.Wait()
+
+	require.LessOrEqual(t, len(cache.entries), 2)
+}
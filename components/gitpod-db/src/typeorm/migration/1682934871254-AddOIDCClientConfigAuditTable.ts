@@ -0,0 +1,19 @@
+/**
+ * Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+ * Licensed under the GNU Affero General Public License (AGPL).
+ * See License.AGPL.txt in the project root for license information.
+ */
+
+import { MigrationInterface, QueryRunner } from "typeorm";
+
+export class AddOIDCClientConfigAuditTable1682934871254 implements MigrationInterface {
+    public async up(queryRunner: QueryRunner): Promise<void> {
+        await queryRunner.query(
+            "CREATE TABLE IF NOT EXISTS `d_b_oidc_client_config_audit` (`id` char(36) NOT NULL, `oidcClientConfigId` char(36) NOT NULL, `organizationId` char(36) NOT NULL, `actorId` char(36) NOT NULL, `action` varchar(255) NOT NULL, `timestamp` timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6), `_lastModified` timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6), PRIMARY KEY (`id`), KEY `ind_oidcClientConfigId_timestamp` (`oidcClientConfigId`, `timestamp`), KEY `ind_actorId_timestamp` (`actorId`, `timestamp`), KEY `ind_dbsync` (`_lastModified`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+        );
+    }
+
+    public async down(queryRunner: QueryRunner): Promise<void> {
+        await queryRunner.query("DROP TABLE IF EXISTS `d_b_oidc_client_config_audit`");
+    }
+}
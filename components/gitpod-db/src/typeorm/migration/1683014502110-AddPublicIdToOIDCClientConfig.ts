@@ -0,0 +1,34 @@
+/**
+ * Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+ * Licensed under the GNU Affero General Public License (AGPL).
+ * See License.AGPL.txt in the project root for license information.
+ */
+
+import { MigrationInterface, QueryRunner } from "typeorm";
+import { columnExists, indexExists } from "./helper/helper";
+
+const table = "d_b_oidc_client_config";
+const column = "publicId";
+const index = "ind_publicId";
+
+export class AddPublicIdToOIDCClientConfig1683014502110 implements MigrationInterface {
+    public async up(queryRunner: QueryRunner): Promise<void> {
+        if (!(await columnExists(queryRunner, table, column))) {
+            await queryRunner.query(
+                `ALTER TABLE ${table} ADD COLUMN ${column} varchar(16) NULL, ALGORITHM=INPLACE, LOCK=NONE`,
+            );
+        }
+        if (!(await indexExists(queryRunner, table, index))) {
+            await queryRunner.query(`CREATE UNIQUE INDEX ${index} ON ${table} (${column})`);
+        }
+    }
+
+    public async down(queryRunner: QueryRunner): Promise<void> {
+        if (await indexExists(queryRunner, table, index)) {
+            await queryRunner.query(`DROP INDEX ${index} ON ${table}`);
+        }
+        if (await columnExists(queryRunner, table, column)) {
+            await queryRunner.query(`ALTER TABLE ${table} DROP COLUMN ${column}`);
+        }
+    }
+}
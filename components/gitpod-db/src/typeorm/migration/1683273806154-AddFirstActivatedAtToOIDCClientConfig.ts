@@ -0,0 +1,32 @@
+/**
+ * Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+ * Licensed under the GNU Affero General Public License (AGPL).
+ * See License.AGPL.txt in the project root for license information.
+ */
+
+import { MigrationInterface, QueryRunner } from "typeorm";
+import { columnExists } from "./helper/helper";
+
+const table = "d_b_oidc_client_config";
+const column = "firstActivatedAt";
+
+export class AddFirstActivatedAtToOIDCClientConfig1683273806154 implements MigrationInterface {
+    public async up(queryRunner: QueryRunner): Promise<void> {
+        if (!(await columnExists(queryRunner, table, column))) {
+            await queryRunner.query(
+                `ALTER TABLE ${table} ADD COLUMN ${column} timestamp(6) NULL, ALGORITHM=INPLACE, LOCK=NONE`,
+            );
+            // active configs were activated at the latest when they were last modified, assigning _lastModified
+            // explicitly keeps it from being bumped by the update
+            await queryRunner.query(
+                `UPDATE ${table} SET ${column} = _lastModified, _lastModified = _lastModified WHERE active = 1`,
+            );
+        }
+    }
+
+    public async down(queryRunner: QueryRunner): Promise<void> {
+        if (await columnExists(queryRunner, table, column)) {
+            await queryRunner.query(`ALTER TABLE ${table} DROP COLUMN ${column}`);
+        }
+    }
+}
@@ -29,12 +29,13 @@ import (
 	"gorm.io/gorm"
 )
 
-func NewOIDCService(connPool proxy.ServerConnectionPool, expClient experiments.Client, dbConn *gorm.DB, cipher db.Cipher) *OIDCService {
+func NewOIDCService(connPool proxy.ServerConnectionPool, expClient experiments.Client, dbConn *gorm.DB, cipher db.Cipher, slugConfigs *db.SlugConfigCache) *OIDCService {
 	return &OIDCService{
 		connectionPool: connPool,
 		expClient:      expClient,
 		cipher:         cipher,
 		dbConn:         dbConn,
+		slugConfigs:    slugConfigs,
 	}
 }
 
@@ -45,6 +46,9 @@ type OIDCService struct {
 	cipher db.Cipher
 	dbConn *gorm.DB
 
+	// slugConfigs is shared with the OIDC sign-in handlers, and invalidated when the active config of an organization changes
+	slugConfigs *db.SlugConfigCache
+
 	v1connect.UnimplementedOIDCServiceHandler
 }
 
@@ -70,7 +74,7 @@ func (s *OIDCService) CreateClientConfig(ctx context.Context, req *connect.Reque
 		return nil, err
 	}
 
-	_, _, err = s.getUser(ctx, conn)
+	_, userID, err := s.getUser(ctx, conn)
 	if err != nil {
 		return nil, err
 	}
@@ -84,20 +88,32 @@ func (s *OIDCService) CreateClientConfig(ctx context.Context, req *connect.Reque
 
 	active := config.GetActive()
 
-	created, err := db.CreateOIDCClientConfig(ctx, s.dbConn, db.OIDCClientConfig{
+	created, err := db.CreateOIDCClientConfigWithAudit(ctx, s.dbConn, s.cipher, db.OIDCClientConfig{
 		ID:             uuid.New(),
 		OrganizationID: organizationID,
 		Issuer:         oidcConfig.GetIssuer(),
 		Data:           data,
 		Active:         active,
-	})
+	}, 0, userID)
 	if err != nil {
+		if errors.Is(err, db.ErrRedirectURLConflict) {
+			return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("Redirect URL is already used by another OIDC Client Config of Organization %s", organizationID.String()))
+		}
+		var validationErr *db.ValidationError
+		if errors.As(err, &validationErr) {
+			return nil, connect.NewError(connect.CodeInvalidArgument, validationErr)
+		}
+
 		log.Extract(ctx).WithError(err).Error("Failed to store oidc client config in the database.")
 		return nil, status.Errorf(codes.Internal, "Failed to store OIDC client config.")
 	}
 
 	log.AddFields(ctx, log.OIDCClientConfigID(created.ID.String()))
 
+	if created.Active {
+		s.invalidateSlugConfigs(ctx, organizationID)
+	}
+
 	converted, err := dbOIDCClientConfigToAPI(created, s.cipher)
 	if err != nil {
 		log.Extract(ctx).WithError(err).Error("Failed to convert OIDC Client config to response.")
@@ -213,12 +229,12 @@ func (s *OIDCService) DeleteClientConfig(ctx context.Context, req *connect.Reque
 		return nil, err
 	}
 
-	_, _, err = s.getUser(ctx, conn)
+	_, userID, err := s.getUser(ctx, conn)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.DeleteOIDCClientConfig(ctx, s.dbConn, clientConfigID, organizationID)
+	err = db.DeleteOIDCClientConfigWithAudit(ctx, s.dbConn, clientConfigID, organizationID, userID)
 	if err != nil {
 		if errors.Is(err, db.ErrorNotFound) {
 			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("OIDC Client Config %s for Organization %s does not exist", clientConfigID.String(), organizationID.String()))
@@ -228,9 +244,20 @@ func (s *OIDCService) DeleteClientConfig(ctx context.Context, req *connect.Reque
 		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("Failed to delete OIDC Client Config %s for Organization %s", clientConfigID.String(), organizationID.String()))
 	}
 
+	s.invalidateSlugConfigs(ctx, organizationID)
+
 	return connect.NewResponse(&v1.DeleteClientConfigResponse{}), nil
 }
 
+// invalidateSlugConfigs drops the cached config of the organization, such that logins by slug do not keep using a
+// deactivated or deleted config until the entry expires.
+func (s *OIDCService) invalidateSlugConfigs(ctx context.Context, organizationID uuid.UUID) {
+	err := s.slugConfigs.InvalidateOrganization(ctx, organizationID)
+	if err != nil {
+		log.Extract(ctx).WithError(err).Warn("Failed to invalidate cached OIDC Client Config of organization.")
+	}
+}
+
 func (s *OIDCService) getConnection(ctx context.Context) (protocol.APIInterface, error) {
 	token, err := auth.TokenFromContext(ctx)
 	if err != nil {
@@ -11,6 +11,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"gorm.io/gorm"
 
@@ -175,15 +176,57 @@ func TestOIDCService_CreateClientConfig_FeatureFlagEnabled(t *testing.T) {
 
 		t.Cleanup(func() {
 			dbtest.HardDeleteOIDCClientConfigs(t, response.Msg.Config.GetId())
+			dbtest.HardDeleteOIDCClientConfigAudits(t, response.Msg.Config.GetId())
 		})
 
 		retrieved, err := db.GetOIDCClientConfig(context.Background(), dbConn, uuid.MustParse(response.Msg.Config.Id))
 		require.NoError(t, err)
 
+		audit, err := db.ListOIDCClientConfigAudit(context.Background(), dbConn, retrieved.ID, db.Pagination{})
+		require.NoError(t, err)
+		require.EqualValues(t, 2, audit.Total, "creation and activation must be recorded")
+		for _, record := range audit.Results {
+			require.Equal(t, user.ID, record.ActorID.String())
+		}
+
 		decrypted, err := retrieved.Data.Decrypt(dbtest.CipherSet(t))
 		require.NoError(t, err)
+		require.True(t, decrypted.PKCEEnabled(), "PKCE is enabled by default")
+		decrypted.UsePKCE = nil
 		require.Equal(t, toDbOIDCSpec(config.Oauth2Config, config.OidcConfig), decrypted)
 	})
+
+	t.Run("returns already exists when redirect url is used by another config", func(t *testing.T) {
+		serverMock, client, _ := setupOIDCService(t, withOIDCFeatureEnabled)
+		issuer := newFakeIdP(t, true)
+		orgID := uuid.New()
+
+		serverMock.EXPECT().GetLoggedInUser(gomock.Any()).Return(user, nil).Times(2)
+
+		config := &v1.OIDCClientConfig{
+			OrganizationId: orgID.String(),
+			OidcConfig:     &v1.OIDCConfig{Issuer: issuer},
+			Oauth2Config: &v1.OAuth2Config{
+				ClientId:              "test-id",
+				ClientSecret:          "test-secret",
+				AuthorizationEndpoint: "https://gitpod.io/iam/oidc/callback",
+			},
+		}
+		response, err := client.CreateClientConfig(context.Background(), connect.NewRequest(&v1.CreateClientConfigRequest{
+			Config: config,
+		}))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			dbtest.HardDeleteOIDCClientConfigs(t, response.Msg.Config.GetId())
+			dbtest.HardDeleteOIDCClientConfigAudits(t, response.Msg.Config.GetId())
+		})
+
+		_, err = client.CreateClientConfig(context.Background(), connect.NewRequest(&v1.CreateClientConfigRequest{
+			Config: config,
+		}))
+		require.Error(t, err)
+		require.Equal(t, connect.CodeAlreadyExists, connect.CodeOf(err))
+	})
 }
 
 func TestOIDCService_GetClientConfig_WithFeatureFlagDisabled(t *testing.T) {
@@ -426,12 +469,22 @@ func TestOIDCService_DeleteClientConfig_WithFeatureFlagEnabled(t *testing.T) {
 
 		serverMock.EXPECT().GetLoggedInUser(gomock.Any()).Return(user, nil)
 
+		t.Cleanup(func() {
+			dbtest.HardDeleteOIDCClientConfigAudits(t, created.ID.String())
+		})
+
 		resp, err := client.DeleteClientConfig(context.Background(), connect.NewRequest(&v1.DeleteClientConfigRequest{
 			Id:             created.ID.String(),
 			OrganizationId: created.OrganizationID.String(),
 		}))
 		require.NoError(t, err)
 		requireEqualProto(t, &v1.DeleteClientConfigResponse{}, resp.Msg)
+
+		audit, err := db.ListOIDCClientConfigAudit(context.Background(), dbConn, created.ID, db.Pagination{})
+		require.NoError(t, err)
+		require.Len(t, audit.Results, 1)
+		require.Equal(t, db.OIDCClientConfigAuditActionDeleted, audit.Results[0].Action)
+		require.Equal(t, user.ID, audit.Results[0].ActorID.String())
 	})
 }
 
@@ -445,7 +498,7 @@ func setupOIDCService(t *testing.T, expClient experiments.Client) (*protocol.Moc
 
 	serverMock := protocol.NewMockAPIInterface(ctrl)
 
-	svc := NewOIDCService(&FakeServerConnPool{api: serverMock}, expClient, dbConn, dbtest.CipherSet(t))
+	svc := NewOIDCService(&FakeServerConnPool{api: serverMock}, expClient, dbConn, dbtest.CipherSet(t), db.NewSlugConfigCache(dbConn, time.Minute))
 
 	_, handler := v1connect.NewOIDCServiceHandler(svc, connect.WithInterceptors(auth.NewServerInterceptor()))
 
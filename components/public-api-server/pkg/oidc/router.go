@@ -122,6 +122,12 @@ func (s *Service) getCallbackHandler() http.HandlerFunc {
 		log.WithField("id_token", result.IDToken).Trace("user verification was successful")
 
 		if state.Activate {
+			// the test login of a config verifies it, regular logins do not need to record anything
+			err = s.MarkClientConfigVerified(r.Context(), config)
+			if err != nil {
+				log.Warn("Failed to mark config as verified: " + err.Error())
+			}
+
 			err = s.ActivateClientConfig(r.Context(), config)
 			if err != nil {
 				log.Warn("Failed to mark config as active: " + err.Error())
@@ -31,6 +31,9 @@ type Service struct {
 	dbConn *gorm.DB
 	cipher db.Cipher
 
+	// slugConfigs resolves login requests by organization slug, which are not authenticated and can be repeated freely
+	slugConfigs *db.SlugConfigCache
+
 	// jwts
 	stateExpiry    time.Duration
 	signerVerifier jws.SignerVerifier
@@ -60,12 +63,16 @@ type AuthFlowResult struct {
 	Claims  map[string]interface{} `json:"claims"`
 }
 
+// slugConfigCacheTTL bounds how long a login by organization slug uses a config after it was deactivated or changed.
+const slugConfigCacheTTL = 30 * time.Second
+
 func NewService(sessionServiceAddress string, dbConn *gorm.DB, cipher db.Cipher, signerVerifier jws.SignerVerifier, stateExpiry time.Duration) *Service {
 	return &Service{
 		sessionServiceAddress: sessionServiceAddress,
 
-		dbConn: dbConn,
-		cipher: cipher,
+		dbConn:      dbConn,
+		cipher:      cipher,
+		slugConfigs: db.NewSlugConfigCache(dbConn, slugConfigCacheTTL),
 
 		signerVerifier: signerVerifier,
 		stateExpiry:    stateExpiry,
@@ -129,7 +136,7 @@ func randString(size int) (string, error) {
 func (s *Service) GetClientConfigFromStartRequest(r *http.Request) (*ClientConfig, error) {
 	orgSlug := r.URL.Query().Get("orgSlug")
 	if orgSlug != "" {
-		dbEntry, err := db.GetOIDCClientConfigByOrgSlug(r.Context(), s.dbConn, orgSlug)
+		dbEntry, err := s.slugConfigs.Get(r.Context(), orgSlug)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to find OIDC clients: %w", err)
 		}
@@ -177,11 +184,39 @@ func (s *Service) GetClientConfigFromCallbackRequest(r *http.Request) (*ClientCo
 }
 
 func (s *Service) ActivateClientConfig(ctx context.Context, config *ClientConfig) error {
-	uuid, err := uuid.Parse(config.ID)
+	id, err := uuid.Parse(config.ID)
+	if err != nil {
+		return err
+	}
+	orgID, err := uuid.Parse(config.OrganizationID)
+	if err != nil {
+		return err
+	}
+	err = db.ActivateClientConfig(ctx, s.dbConn, id)
+	if err != nil {
+		return err
+	}
+
+	// logins by slug must not keep using the config, which was active before
+	return s.slugConfigs.InvalidateOrganization(ctx, orgID)
+}
+
+// SlugConfigCache returns the cache of the configs resolved by organization slug. Entries of an organization must be
+// invalidated, when its configs are activated, deactivated or deleted elsewhere.
+func (s *Service) SlugConfigCache() *db.SlugConfigCache {
+	return s.slugConfigs
+}
+
+func (s *Service) MarkClientConfigVerified(ctx context.Context, config *ClientConfig) error {
+	id, err := uuid.Parse(config.ID)
+	if err != nil {
+		return err
+	}
+	orgID, err := uuid.Parse(config.OrganizationID)
 	if err != nil {
 		return err
 	}
-	return db.ActivateClientConfig(ctx, s.dbConn, uuid)
+	return db.MarkOIDCClientConfigVerified(ctx, s.dbConn, id, orgID)
 }
 
 func (s *Service) getConfigById(ctx context.Context, id string) (*ClientConfig, error) {
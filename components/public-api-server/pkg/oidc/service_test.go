@@ -127,6 +127,65 @@ func TestGetClientConfigFromStartRequest(t *testing.T) {
 	})
 }
 
+func TestGetClientConfigFromStartRequest_CachesSlugLookups(t *testing.T) {
+	issuer := newFakeIdP(t)
+	service, dbConn := setupOIDCServiceForTests(t)
+	config, team := createConfig(t, dbConn, &ClientConfig{
+		Issuer:         issuer,
+		VerifierConfig: &oidc.Config{},
+		OAuth2Config:   &oauth2.Config{},
+	})
+	t.Cleanup(func() {
+		require.NoError(t, dbConn.Where("slug = ?", team.Slug).Delete(&db.Team{}).Error)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/start?orgSlug="+team.Slug, nil)
+	first, err := service.GetClientConfigFromStartRequest(request)
+	require.NoError(t, err)
+	require.Equal(t, config.ID.String(), first.ID)
+
+	second, err := service.GetClientConfigFromStartRequest(request)
+	require.NoError(t, err, "the slug lookup is served from the cache")
+	require.Equal(t, first.ID, second.ID)
+	require.Equal(t, first.OAuth2Config.ClientID, second.OAuth2Config.ClientID)
+
+	// deleting the config invalidates the cached entry, as the apiv1 OIDCService does
+	require.NoError(t, db.DeleteOIDCClientConfig(context.Background(), dbConn, config.ID, team.ID))
+	require.NoError(t, service.SlugConfigCache().InvalidateOrganization(context.Background(), team.ID))
+
+	_, err = service.GetClientConfigFromStartRequest(request)
+	require.Error(t, err, "a deleted config must no longer be served")
+}
+
+func TestActivateClientConfig_InvalidatesSlugLookups(t *testing.T) {
+	issuer := newFakeIdP(t)
+	service, dbConn := setupOIDCServiceForTests(t)
+	config, team := createConfig(t, dbConn, &ClientConfig{
+		Issuer:         issuer,
+		VerifierConfig: &oidc.Config{},
+		OAuth2Config:   &oauth2.Config{},
+	})
+	t.Cleanup(func() {
+		require.NoError(t, dbConn.Where("slug = ?", team.Slug).Delete(&db.Team{}).Error)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/start?orgSlug="+team.Slug, nil)
+	first, err := service.GetClientConfigFromStartRequest(request)
+	require.NoError(t, err)
+	require.Equal(t, config.ID.String(), first.ID)
+
+	replacement := dbtest.CreateOIDCClientConfigs(t, dbConn, db.OIDCClientConfig{OrganizationID: team.ID})[0]
+	require.NoError(t, db.DeleteOIDCClientConfig(context.Background(), dbConn, config.ID, team.ID))
+	require.NoError(t, service.ActivateClientConfig(context.Background(), &ClientConfig{
+		ID:             replacement.ID.String(),
+		OrganizationID: team.ID.String(),
+	}))
+
+	second, err := service.GetClientConfigFromStartRequest(request)
+	require.NoError(t, err)
+	require.Equal(t, replacement.ID.String(), second.ID, "the activated config must be served")
+}
+
 func TestGetClientConfigFromCallbackRequest(t *testing.T) {
 	issuer := newFakeIdP(t)
 	service, dbConn := setupOIDCServiceForTests(t)
@@ -262,6 +321,7 @@ func createConfig(t *testing.T, dbConn *gorm.DB, config *ClientConfig) (db.OIDCC
 		OrganizationID: orgID,
 		Issuer:         config.Issuer,
 		Data:           data,
+		Active:         true,
 	})[0]
 
 	return created, team
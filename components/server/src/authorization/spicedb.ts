@@ -5,6 +5,7 @@
  */
 
 import { v1 } from "@authzed/authzed-node";
+import * as grpc from "@grpc/grpc-js";
 import { log } from "@gitpod/gitpod-protocol/lib/util/logging";
 
 export const SpiceDBClient = Symbol("SpiceDBClient");
@@ -23,5 +24,28 @@ export function spicedbClientFromEnv(): SpiceDBClient {
         return;
     }
 
-    return v1.NewClient(token, address, v1.ClientSecurity.INSECURE_PLAINTEXT_CREDENTIALS).promises;
+    const security =
+        process.env["SPICEDB_TLS_ENABLED"] === "true"
+            ? v1.ClientSecurity.SECURE
+            : v1.ClientSecurity.INSECURE_PLAINTEXT_CREDENTIALS;
+
+    const options: grpc.ClientOptions = {};
+    const maxMessageSize = parseInt(process.env["SPICEDB_GRPC_MAX_MESSAGE_SIZE"] || "", 10);
+    if (!isNaN(maxMessageSize)) {
+        options["grpc.max_receive_message_length"] = maxMessageSize;
+        options["grpc.max_send_message_length"] = maxMessageSize;
+    }
+    const keepaliveTime = parseInt(process.env["SPICEDB_GRPC_KEEPALIVE_TIME_MS"] || "", 10);
+    if (!isNaN(keepaliveTime)) {
+        options["grpc.keepalive_time_ms"] = keepaliveTime;
+    }
+    const keepaliveTimeout = parseInt(process.env["SPICEDB_GRPC_KEEPALIVE_TIMEOUT_MS"] || "", 10);
+    if (!isNaN(keepaliveTimeout)) {
+        options["grpc.keepalive_timeout_ms"] = keepaliveTimeout;
+    }
+    if (process.env["SPICEDB_GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM"] === "true") {
+        options["grpc.keepalive_permit_without_calls"] = 1;
+    }
+
+    return v1.NewClient(token, address, security, undefined, options).promises;
 }
@@ -710,6 +710,10 @@ var (
 		APIVersion: "networking.k8s.io/v1",
 		Kind:       "NetworkPolicy",
 	}
+	TypeMetaIngress = metav1.TypeMeta{
+		APIVersion: "networking.k8s.io/v1",
+		Kind:       "Ingress",
+	}
 	TypeMetaDeployment = metav1.TypeMeta{
 		APIVersion: "apps/v1",
 		Kind:       "Deployment",
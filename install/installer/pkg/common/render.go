@@ -66,6 +66,7 @@ type GeneratedValues struct {
 	InternalRegistryUsername     string
 	InternalRegistryPassword     string
 	InternalRegistrySharedSecret string
+	SpiceDBDatastorePassword     string
 }
 
 type RenderContext struct {
@@ -156,6 +157,12 @@ func (r *RenderContext) generateValues() error {
 	}
 	r.Values.InternalRegistrySharedSecret = internalRegistrySharedSecret
 
+	spiceDBDatastorePassword, err := RandomString(20)
+	if err != nil {
+		return err
+	}
+	r.Values.SpiceDBDatastorePassword = spiceDBDatastorePassword
+
 	return nil
 }
 
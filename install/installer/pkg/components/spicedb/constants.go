@@ -26,10 +26,30 @@ const (
 	RegistryImage = "authzed/spicedb"
 	ImageTag      = "v1.16.1"
 
-	ContainerName = "spicedb"
+	// the debug variant of the zed CLI image ships a shell, which the schema version readiness check needs
+	ZedRegistryImage = "authzed/zed"
+	ZedImageTag      = "v0.12.1-debug"
+
+	// the debug variant of the SpiceDB image ships a shell next to grpc_health_probe, so the binary can be copied out of it
+	HealthProbeImageTag   = ImageTag + "-debug"
+	HealthProbeBinaryPath = "/usr/local/bin/grpc_health_probe"
+
+	ContainerName          = "spicedb"
+	MigrationContainerName = "spicedb-migrations"
+
+	InitContainerImage = "library/alpine"
+	InitContainerTag   = "3.16"
 
 	CloudSQLProxyPort = 3306
 
+	MetricsServiceName     = "spicedb-metrics"
+	MetricsServiceLabelKey = "gitpod.io/metrics-service"
+
 	SecretPresharedKeyName = "presharedKey"
-	BootstrapConfigMapName = "spicedb-bootstrap"
+	SecretCABundleKeyName  = "ca.crt"
+	SecretIntegrityKeyName = "integrityKey"
+
+	DatastoreSecretName            = "spicedb-datastore"
+	SecretDatastorePasswordKeyName = "password"
+	BootstrapConfigMapName         = "spicedb-bootstrap"
 )
@@ -0,0 +1,367 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gitpod-io/gitpod/common-go/util"
+	"github.com/gitpod-io/gitpod/installer/pkg/common"
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	defaultDatastoreMaxOpenConns    = 100
+	defaultDatastoreMinOpenConns    = 10
+	defaultDatastoreConnMaxLifetime = util.Duration(30 * time.Minute)
+	defaultDatastoreConnMaxIdleTime = util.Duration(5 * time.Minute)
+)
+
+// datastoreEngine returns the configured datastore engine, defaulting to mysql.
+func datastoreEngine(cfg *experimental.SpiceDBConfig) experimental.SpiceDBDatastoreEngine {
+	if cfg.Datastore != nil && cfg.Datastore.Engine != "" {
+		return cfg.Datastore.Engine
+	}
+
+	return experimental.SpiceDBDatastoreEngineMySQL
+}
+
+// datastoreHost returns the configured datastore host, defaulting to the Gitpod database host.
+func datastoreHost(cfg *experimental.SpiceDBConfig) string {
+	if cfg.Datastore != nil && cfg.Datastore.Host != "" {
+		return cfg.Datastore.Host
+	}
+
+	return "$(DB_HOST)"
+}
+
+// datastorePort returns the configured datastore port, defaulting to the Gitpod database port.
+func datastorePort(cfg *experimental.SpiceDBConfig) string {
+	if cfg.Datastore != nil && cfg.Datastore.Port != 0 {
+		return strconv.Itoa(cfg.Datastore.Port)
+	}
+
+	return "$(DB_PORT)"
+}
+
+// engineSupportsWatch reports whether the datastore engine implements the Watch API in the pinned SpiceDB image.
+// CockroachDB additionally needs rangefeeds enabled on the cluster, which the installer cannot check.
+func engineSupportsWatch(engine experimental.SpiceDBDatastoreEngine) bool {
+	switch engine {
+	case experimental.SpiceDBDatastoreEngineMySQL,
+		experimental.SpiceDBDatastoreEnginePostgres,
+		experimental.SpiceDBDatastoreEngineCockroachDB,
+		experimental.SpiceDBDatastoreEngineMemory:
+		return true
+	default:
+		return false
+	}
+}
+
+// engineSupportsReadReplicas reports whether the datastore engine can route reads to replicas.
+func engineSupportsReadReplicas(engine experimental.SpiceDBDatastoreEngine) bool {
+	switch engine {
+	case experimental.SpiceDBDatastoreEngineMySQL,
+		experimental.SpiceDBDatastoreEnginePostgres:
+		return true
+	default:
+		return false
+	}
+}
+
+// usesDatastorePassword reports whether SpiceDB authenticates to the datastore with a password of its own, read from
+// spicedb.datastore.passwordSecretRef or generated, instead of the password of the Gitpod database.
+func usesDatastorePassword(cfg *experimental.SpiceDBConfig) bool {
+	return cfg.Datastore != nil && (cfg.Datastore.PasswordSecretRef != "" || cfg.Datastore.GeneratePassword)
+}
+
+// generatesDatastorePassword reports whether the installer renders the Secret of the datastore password. A configured
+// Secret is reused, a password is only generated without one.
+func generatesDatastorePassword(cfg *experimental.SpiceDBConfig) bool {
+	return cfg.Datastore != nil && cfg.Datastore.GeneratePassword && cfg.Datastore.PasswordSecretRef == ""
+}
+
+// datastorePasswordSecretName returns the name of the Secret holding the datastore password.
+func datastorePasswordSecretName(cfg *experimental.SpiceDBConfig) string {
+	if cfg.Datastore != nil && cfg.Datastore.PasswordSecretRef != "" {
+		return cfg.Datastore.PasswordSecretRef
+	}
+
+	return DatastoreSecretName
+}
+
+// datastoreConnURI returns the connection URI for the datastore at host:port, authenticating with the Gitpod database
+// user, and either the Gitpod database password or the datastore password.
+func datastoreConnURI(cfg *experimental.SpiceDBConfig, host, port string) string {
+	password := "$(DB_PASSWORD)"
+	if usesDatastorePassword(cfg) {
+		password = "$(SPICEDB_DATASTORE_PASSWORD)"
+	}
+
+	return fmt.Sprintf("$(DB_USERNAME):%s@tcp(%s:%s)/authorization?parseTime=true", password, host, port)
+}
+
+// datastorePasswordSecret renders the Secret holding the generated datastore password.
+func datastorePasswordSecret(ctx *common.RenderContext) ([]runtime.Object, error) {
+	cfg := getExperimentalSpiceDBConfig(ctx)
+	if cfg == nil || !cfg.Enabled || !generatesDatastorePassword(cfg) {
+		return nil, nil
+	}
+
+	password := ctx.Values.SpiceDBDatastorePassword
+	if password == "" {
+		return nil, fmt.Errorf("unknown value: spicedb datastore password")
+	}
+
+	return []runtime.Object{&corev1.Secret{
+		TypeMeta: common.TypeMetaSecret,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DatastoreSecretName,
+			Namespace: ctx.Namespace,
+			Labels:    common.DefaultLabels(Component),
+		},
+		Data: map[string][]byte{
+			SecretDatastorePasswordKeyName: []byte(password),
+		},
+	}}, nil
+}
+
+// datastorePasswordEnvVars returns the env var holding the datastore password, if SpiceDB uses one of its own.
+func datastorePasswordEnvVars(cfg *experimental.SpiceDBConfig) []corev1.EnvVar {
+	if !usesDatastorePassword(cfg) {
+		return nil
+	}
+
+	return []corev1.EnvVar{{
+		Name: "SPICEDB_DATASTORE_PASSWORD",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: datastorePasswordSecretName(cfg)},
+				Key:                  SecretDatastorePasswordKeyName,
+			},
+		},
+	}}
+}
+
+// readReplicaConnURIs returns the connection URIs of the configured read replicas.
+func readReplicaConnURIs(cfg *experimental.SpiceDBConfig) []string {
+	if cfg.Datastore == nil {
+		return nil
+	}
+
+	var uris []string
+	for _, conn := range cfg.Datastore.ReadReplicaConns {
+		host, port, err := net.SplitHostPort(conn)
+		if err != nil {
+			// rejected by config validation
+			continue
+		}
+		uris = append(uris, datastoreConnURI(cfg, host, port))
+	}
+
+	return uris
+}
+
+// datastoreConnPoolArgs returns the flags configuring the datastore connection pool.
+func datastoreConnPoolArgs(cfg *experimental.SpiceDBConfig) []string {
+	maxOpen := defaultDatastoreMaxOpenConns
+	minOpen := defaultDatastoreMinOpenConns
+	maxLifetime := defaultDatastoreConnMaxLifetime
+	maxIdleTime := defaultDatastoreConnMaxIdleTime
+
+	if ds := cfg.Datastore; ds != nil {
+		if ds.MaxOpenConns != nil {
+			maxOpen = *ds.MaxOpenConns
+		}
+		if ds.MinOpenConns != nil {
+			minOpen = *ds.MinOpenConns
+		}
+		if ds.ConnMaxLifetime != nil {
+			maxLifetime = *ds.ConnMaxLifetime
+		}
+		if ds.ConnMaxIdleTime != nil {
+			maxIdleTime = *ds.ConnMaxIdleTime
+		}
+	}
+
+	return []string{
+		fmt.Sprintf("--datastore-conn-max-open=%d", maxOpen),
+		fmt.Sprintf("--datastore-conn-min-open=%d", minOpen),
+		fmt.Sprintf("--datastore-conn-max-lifetime=%s", maxLifetime),
+		fmt.Sprintf("--datastore-conn-max-idletime=%s", maxIdleTime),
+	}
+}
+
+// engineSupportsGC reports whether the datastore engine garbage collects old revisions through SpiceDB.
+func engineSupportsGC(engine experimental.SpiceDBDatastoreEngine) bool {
+	switch engine {
+	case experimental.SpiceDBDatastoreEngineMySQL,
+		experimental.SpiceDBDatastoreEnginePostgres:
+		return true
+	default:
+		return false
+	}
+}
+
+func hasGCConfig(ds *experimental.SpiceDBDatastoreConfig) bool {
+	return ds != nil && (ds.GCWindow != nil || ds.GCInterval != nil || ds.GCMaxOperationTime != nil)
+}
+
+// gcArgs returns the flags tuning garbage collection of old revisions. Unset values keep the SpiceDB defaults.
+func gcArgs(cfg *experimental.SpiceDBConfig) []string {
+	ds := cfg.Datastore
+	if !hasGCConfig(ds) || !engineSupportsGC(datastoreEngine(cfg)) {
+		return nil
+	}
+
+	var args []string
+	if ds.GCWindow != nil {
+		args = append(args, fmt.Sprintf("--datastore-gc-window=%s", *ds.GCWindow))
+	}
+	if ds.GCInterval != nil {
+		args = append(args, fmt.Sprintf("--datastore-gc-interval=%s", *ds.GCInterval))
+	}
+	if ds.GCMaxOperationTime != nil {
+		args = append(args, fmt.Sprintf("--datastore-gc-max-operation-time=%s", *ds.GCMaxOperationTime))
+	}
+
+	return args
+}
+
+// hedgingArgs returns the flags configuring datastore request hedging, which is disabled unless enabled in config.
+func hedgingArgs(cfg *experimental.SpiceDBConfig) []string {
+	if cfg.Datastore == nil || cfg.Datastore.Hedging == nil || !cfg.Datastore.Hedging.Enabled {
+		return []string{"--datastore-request-hedging=false"}
+	}
+
+	hedging := cfg.Datastore.Hedging
+
+	args := []string{"--datastore-request-hedging=true"}
+	if hedging.InitialSlowValue != nil {
+		args = append(args, fmt.Sprintf("--datastore-request-hedging-initial-slow-value=%s", *hedging.InitialSlowValue))
+	}
+	if hedging.MaxRequests != nil {
+		args = append(args, fmt.Sprintf("--datastore-request-hedging-max-requests=%d", *hedging.MaxRequests))
+	}
+	if hedging.Quantile != nil {
+		args = append(args, fmt.Sprintf("--datastore-request-hedging-quantile=%s", strconv.FormatFloat(*hedging.Quantile, 'f', -1, 64)))
+	}
+
+	return args
+}
+
+// cockroachDBArgs returns the flags specific to the cockroachdb engine.
+func cockroachDBArgs(cfg *experimental.SpiceDBConfig) []string {
+	if cfg.Datastore == nil || cfg.Datastore.CockroachDB == nil || datastoreEngine(cfg) != experimental.SpiceDBDatastoreEngineCockroachDB {
+		return nil
+	}
+
+	crdb := cfg.Datastore.CockroachDB
+
+	var args []string
+	if crdb.MaxTxRetries != nil {
+		args = append(args, fmt.Sprintf("--datastore-max-tx-retries=%d", *crdb.MaxTxRetries))
+	}
+	if crdb.OverlapStrategy != "" {
+		args = append(args, fmt.Sprintf("--datastore-tx-overlap-strategy=%s", crdb.OverlapStrategy))
+	}
+	if crdb.OverlapKey != "" {
+		args = append(args, fmt.Sprintf("--datastore-tx-overlap-key=%s", crdb.OverlapKey))
+	}
+
+	return args
+}
+
+// engineSupportsRelationshipIntegrity reports whether the datastore engine can store relationship integrity data.
+func engineSupportsRelationshipIntegrity(engine experimental.SpiceDBDatastoreEngine) bool {
+	return engine == experimental.SpiceDBDatastoreEngineCockroachDB
+}
+
+func relationshipIntegrityEnabled(cfg *experimental.SpiceDBConfig) bool {
+	return cfg.Relationships != nil && cfg.Relationships.Integrity != nil && cfg.Relationships.Integrity.Enabled
+}
+
+const relationshipIntegrityKeyMountPath = "/etc/spicedb/relationship-integrity"
+
+// relationshipArgs returns the flags enabling experimental relationship features, which are all off by default.
+func relationshipArgs(cfg *experimental.SpiceDBConfig) []string {
+	if cfg.Relationships == nil {
+		return nil
+	}
+
+	var args []string
+	if cfg.Relationships.ExpirationEnabled {
+		args = append(args, "--enable-experimental-relationship-expiration=true")
+	}
+	if relationshipIntegrityEnabled(cfg) {
+		args = append(args,
+			"--datastore-relationship-integrity-enabled=true",
+			fmt.Sprintf("--datastore-relationship-integrity-current-key-id=%s", cfg.Relationships.Integrity.KeyID),
+			fmt.Sprintf("--datastore-relationship-integrity-current-key-filename=%s", filepath.Join(relationshipIntegrityKeyMountPath, SecretIntegrityKeyName)),
+		)
+	}
+
+	return args
+}
+
+func validateDatastoreConfig(cfg *experimental.SpiceDBConfig) error {
+	engine := datastoreEngine(cfg)
+
+	if cfg.WatchEnabled && !engineSupportsWatch(engine) {
+		return fmt.Errorf("spicedb.watchEnabled is not supported by datastore engine %q", engine)
+	}
+
+	if ds := cfg.Datastore; ds != nil && ds.CockroachDB != nil && engine != experimental.SpiceDBDatastoreEngineCockroachDB {
+		return fmt.Errorf("spicedb.datastore.cockroachdb is only supported by datastore engine %q, got %q", experimental.SpiceDBDatastoreEngineCockroachDB, engine)
+	}
+
+	if ds := cfg.Datastore; ds != nil && len(ds.ReadReplicaConns) > 0 && !engineSupportsReadReplicas(engine) {
+		return fmt.Errorf("spicedb.datastore.readReplicaConns is not supported by datastore engine %q", engine)
+	}
+
+	if usesDatastorePassword(cfg) && engine == experimental.SpiceDBDatastoreEngineMemory {
+		return fmt.Errorf("spicedb.datastore.generatePassword and spicedb.datastore.passwordSecretRef are not supported by datastore engine %q", engine)
+	}
+
+	if startupRetryEnabled(cfg) {
+		if engine == experimental.SpiceDBDatastoreEngineMemory {
+			return fmt.Errorf("spicedb.datastore.startupRetry is not supported by datastore engine %q", engine)
+		}
+		if interval := cfg.Datastore.StartupRetry.Interval; interval != nil && time.Duration(*interval) < time.Second {
+			return fmt.Errorf("spicedb.datastore.startupRetry.interval must be at least 1s, got %s", time.Duration(*interval))
+		}
+	}
+
+	if hasGCConfig(cfg.Datastore) && !engineSupportsGC(engine) {
+		return fmt.Errorf("spicedb.datastore garbage collection settings are not supported by datastore engine %q", engine)
+	}
+
+	if relationshipIntegrityEnabled(cfg) {
+		integrity := cfg.Relationships.Integrity
+		if !engineSupportsRelationshipIntegrity(engine) {
+			return fmt.Errorf("spicedb.relationships.integrity is not supported by datastore engine %q", engine)
+		}
+		if integrity.KeySecretRef == "" || integrity.KeyID == "" {
+			return fmt.Errorf("spicedb.relationships.integrity requires keySecretRef and keyId to be set")
+		}
+	}
+
+	if relationshipArgs(cfg) != nil {
+		return errUnsupportedByImage("spicedb.relationships")
+	}
+
+	if ds := cfg.Datastore; ds != nil && ds.MaxOpenConns != nil && ds.MinOpenConns != nil && *ds.MinOpenConns > *ds.MaxOpenConns {
+		return fmt.Errorf("spicedb.datastore.minOpenConns (%d) must not exceed maxOpenConns (%d)", *ds.MinOpenConns, *ds.MaxOpenConns)
+	}
+
+	return nil
+}
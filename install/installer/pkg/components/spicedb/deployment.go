@@ -7,11 +7,15 @@ package spicedb
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/gitpod-io/gitpod/common-go/baseserver"
 	"github.com/gitpod-io/gitpod/installer/pkg/cluster"
 	"github.com/gitpod-io/gitpod/installer/pkg/common"
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -34,6 +38,33 @@ func deployment(ctx *common.RenderContext) ([]runtime.Object, error) {
 		return nil, errors.New("missing configuration for spicedb.secretRef")
 	}
 
+	if cfg.SchemaReadOnly && cfg.RequireSchemaVersion != "" {
+		return nil, errors.New("spicedb.schemaReadOnly disables reading the schema, which spicedb.requireSchemaVersion needs")
+	}
+
+	if err := validatePresharedKeys(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateDatastoreConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateGRPCKeepalive(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateAnnotations("spicedb.podAnnotations", cfg.PodAnnotations); err != nil {
+		return nil, err
+	}
+	if err := validateAnnotations("spicedb.deploymentAnnotations", cfg.DeploymentAnnotations); err != nil {
+		return nil, err
+	}
+
+	if cfg.EnableSchemaReflection {
+		return nil, errUnsupportedByImage("spicedb.enableSchemaReflection")
+	}
+
 	bootstrapVolume, bootstrapVolumeMount, bootstrapFiles, err := getBootstrapConfig(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get bootstrap config: %w", err)
@@ -41,14 +72,25 @@ func deployment(ctx *common.RenderContext) ([]runtime.Object, error) {
 
 	replicas := common.Replicas(ctx, Component)
 
+	if err := validateSecondaryDispatch(cfg, *replicas); err != nil {
+		return nil, err
+	}
+
+	args := serverArgs(ctx, cfg, *replicas, bootstrapFiles)
+	if err := validateExtraArgs("spicedb.extraArgs", args, cfg.ExtraArgs); err != nil {
+		return nil, err
+	}
+
 	return []runtime.Object{
 		&appsv1.Deployment{
 			TypeMeta: common.TypeMetaDeployment,
 			ObjectMeta: metav1.ObjectMeta{
-				Name:        Component,
-				Namespace:   ctx.Namespace,
-				Labels:      labels,
-				Annotations: common.CustomizeAnnotation(ctx, Component, common.TypeMetaDeployment),
+				Name:      Component,
+				Namespace: ctx.Namespace,
+				Labels:    labels,
+				Annotations: common.CustomizeAnnotation(ctx, Component, common.TypeMetaDeployment, func() map[string]string {
+					return cfg.DeploymentAnnotations
+				}),
 			},
 			Spec: appsv1.DeploymentSpec{
 				Selector: &metav1.LabelSelector{MatchLabels: common.DefaultLabels(Component)},
@@ -56,10 +98,12 @@ func deployment(ctx *common.RenderContext) ([]runtime.Object, error) {
 				Strategy: common.DeploymentStrategy,
 				Template: corev1.PodTemplateSpec{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:        Component,
-						Namespace:   ctx.Namespace,
-						Labels:      labels,
-						Annotations: common.CustomizeAnnotation(ctx, Component, common.TypeMetaDeployment),
+						Name:      Component,
+						Namespace: ctx.Namespace,
+						Labels:    labels,
+						Annotations: common.CustomizeAnnotation(ctx, Component, common.TypeMetaDeployment, func() map[string]string {
+							return cfg.PodAnnotations
+						}),
 					},
 					Spec: corev1.PodSpec{
 						Affinity:                      cluster.WithNodeAffinityHostnameAntiAffinity(Component, cluster.AffinityLabelMeta),
@@ -69,70 +113,69 @@ func deployment(ctx *common.RenderContext) ([]runtime.Object, error) {
 						EnableServiceLinks:            pointer.Bool(false),
 						DNSPolicy:                     corev1.DNSClusterFirst,
 						RestartPolicy:                 corev1.RestartPolicyAlways,
-						TerminationGracePeriodSeconds: pointer.Int64(30),
+						TerminationGracePeriodSeconds: pointer.Int64(30 + int64(dispatchDrainPeriod(cfg, *replicas).Seconds())),
 						SecurityContext: &corev1.PodSecurityContext{
 							RunAsNonRoot: pointer.Bool(false),
 						},
-						InitContainers: []corev1.Container{
-							dbWaiter(ctx),
-						},
-						Containers: []corev1.Container{
+						InitContainers: (func() []corev1.Container {
+							containers := []corev1.Container{
+								dbWaiter(ctx),
+							}
+
+							if cfg.HealthProbe != nil {
+								containers = append(containers, healthProbeInstaller(ctx, cfg))
+							}
+
+							if waitsForDatastore(cfg) {
+								containers = append(containers, datastoreWaiter(ctx, cfg))
+							}
+
+							return containers
+						})(),
+						Containers: append([]corev1.Container{
 							{
 								Name:            ContainerName,
 								Image:           ctx.ImageName(common.ThirdPartyContainerRepo(ctx.Config.Repository, RegistryRepo), RegistryImage, ImageTag),
 								ImagePullPolicy: corev1.PullIfNotPresent,
-								Args: (func() []string {
-									args := []string{
-										"serve",
-										"--log-format=json",
-										"--log-level=info",
-										"--datastore-engine=mysql",
-										"--datastore-conn-max-open=100",
-										"--telemetry-endpoint=", // disable telemetry to https://telemetry.authzed.com
-										fmt.Sprintf("--datastore-bootstrap-files=%s", strings.Join(bootstrapFiles, ",")),
-										"--dispatch-cluster-enabled=true",
-										"--datastore-bootstrap-overwrite=true",
-										fmt.Sprintf("--metrics-addr=127.0.0.1:%d", baseserver.BuiltinMetricsPort),
+								Args:            append(args, cfg.ExtraArgs...),
+								Env: common.CustomizeEnvvar(ctx, Component, common.MergeEnv(
+									common.DefaultEnv(&ctx.Config),
+									spicedbEnvVars(ctx),
+								)),
+								Ports: (func() []corev1.ContainerPort {
+									ports := []corev1.ContainerPort{
+										{
+											ContainerPort: ContainerGRPCPort,
+											Name:          ContainerGRPCName,
+											Protocol:      *common.TCPProtocol,
+										},
+										{
+											ContainerPort: ContainerDashboardPort,
+											Name:          ContainerDashboardName,
+											Protocol:      *common.TCPProtocol,
+										},
+										{
+											ContainerPort: ContainerDispatchPort,
+											Name:          ContainerDispatchName,
+											Protocol:      *common.TCPProtocol,
+										},
+										{
+											ContainerPort: ContainerPrometheusPort,
+											Name:          ContainterPrometheusName,
+											Protocol:      *common.TCPProtocol,
+										},
 									}
 
-									// Dispatching only makes sense, when we have more than one replica
-									if *replicas > 1 {
-										args = append(args, fmt.Sprintf("--dispatch-upstream-addr=kubernetes:///spicedb:%d", ContainerDispatchPort))
+									if cfg.HTTPEnabled {
+										ports = append(ports, corev1.ContainerPort{
+											ContainerPort: httpPort(cfg),
+											Name:          ContainerHTTPName,
+											Protocol:      *common.TCPProtocol,
+										})
 									}
 
-									return args
+									return ports
 								})(),
-								Env: common.CustomizeEnvvar(ctx, Component, common.MergeEnv(
-									common.DefaultEnv(&ctx.Config),
-									spicedbEnvVars(ctx),
-								)),
-								Ports: []corev1.ContainerPort{
-									{
-										ContainerPort: ContainerGRPCPort,
-										Name:          ContainerGRPCName,
-										Protocol:      *common.TCPProtocol,
-									},
-									{
-										ContainerPort: ContainerHTTPPort,
-										Name:          ContainerHTTPName,
-										Protocol:      *common.TCPProtocol,
-									},
-									{
-										ContainerPort: ContainerDashboardPort,
-										Name:          ContainerDashboardName,
-										Protocol:      *common.TCPProtocol,
-									},
-									{
-										ContainerPort: ContainerDispatchPort,
-										Name:          ContainerDispatchName,
-										Protocol:      *common.TCPProtocol,
-									},
-									{
-										ContainerPort: ContainerPrometheusPort,
-										Name:          ContainterPrometheusName,
-										Protocol:      *common.TCPProtocol,
-									},
-								},
 								Resources: common.ResourceRequirements(ctx, Component, ContainerName, corev1.ResourceRequirements{
 									Requests: corev1.ResourceList{
 										"cpu":    resource.MustParse("1"),
@@ -147,24 +190,25 @@ func deployment(ctx *common.RenderContext) ([]runtime.Object, error) {
 								ReadinessProbe: &corev1.Probe{
 									ProbeHandler: corev1.ProbeHandler{
 										Exec: &v1.ExecAction{
-											Command: []string{"grpc_health_probe", "-v", fmt.Sprintf("-addr=localhost:%d", ContainerGRPCPort)},
+											Command: []string{healthProbeBinary(cfg), "-v", fmt.Sprintf("-addr=localhost:%d", ContainerGRPCPort)},
 										},
 									},
-									InitialDelaySeconds: 5,
+									InitialDelaySeconds: readinessInitialDelaySeconds(cfg),
 									PeriodSeconds:       30,
 									FailureThreshold:    5,
 									SuccessThreshold:    1,
 									TimeoutSeconds:      3,
 								},
-								VolumeMounts: []v1.VolumeMount{
+								LivenessProbe: dispatchMembershipProbe(cfg, *replicas),
+								VolumeMounts: append([]v1.VolumeMount{
 									bootstrapVolumeMount,
-								},
+								}, append(healthProbeVolumeMounts(cfg), append(caBundleVolumeMounts(cfg), relationshipIntegrityVolumeMounts(cfg)...)...)...),
 							},
-							*common.KubeRBACProxyContainer(ctx),
-						},
-						Volumes: []v1.Volume{
+							metricsProxy(ctx, cfg),
+						}, schemaVersionCheckContainers(ctx, cfg)...),
+						Volumes: append([]v1.Volume{
 							bootstrapVolume,
-						},
+						}, append(healthProbeVolumes(cfg), append(caBundleVolumes(cfg), relationshipIntegrityVolumes(cfg)...)...)...),
 					},
 				},
 			},
@@ -172,6 +216,290 @@ func deployment(ctx *common.RenderContext) ([]runtime.Object, error) {
 	}, nil
 }
 
+// serverArgs returns the args of the SpiceDB server managed by the installer, without spicedb.extraArgs.
+func serverArgs(ctx *common.RenderContext, cfg *experimental.SpiceDBConfig, replicas int32, bootstrapFiles []string) []string {
+	args := []string{
+		"serve",
+		"--log-format=json",
+		"--log-level=info",
+		fmt.Sprintf("--datastore-engine=%s", datastoreEngine(cfg)),
+		"--telemetry-endpoint=", // disable telemetry to https://telemetry.authzed.com
+		"--dispatch-cluster-enabled=true",
+		fmt.Sprintf("--metrics-addr=127.0.0.1:%d", baseserver.BuiltinMetricsPort),
+	}
+
+	if cfg.ReadOnly {
+		// bootstrapping writes the schema, which a read-only datastore rejects
+		args = append(args, "--datastore-readonly=true")
+	} else {
+		args = append(args,
+			fmt.Sprintf("--datastore-bootstrap-files=%s", strings.Join(bootstrapFiles, ",")),
+			"--datastore-bootstrap-overwrite=true",
+		)
+	}
+
+	if cfg.SchemaReadOnly {
+		// bootstrapping writes the schema to the datastore directly, and is not affected
+		args = append(args, "--disable-v1-schema-api=true")
+	}
+
+	args = append(args, presharedKeyArgs(cfg)...)
+	args = append(args, datastoreConnPoolArgs(cfg)...)
+	args = append(args, hedgingArgs(cfg)...)
+	args = append(args, gcArgs(cfg)...)
+	args = append(args, cockroachDBArgs(cfg)...)
+	args = append(args, relationshipArgs(cfg)...)
+	args = append(args, tracingArgs(ctx, cfg)...)
+
+	// Dispatching only makes sense, when we have more than one replica
+	if replicas > 1 {
+		args = append(args,
+			fmt.Sprintf("--dispatch-upstream-addr=kubernetes:///spicedb:%d", ContainerDispatchPort),
+			fmt.Sprintf("--grpc-shutdown-grace-period=%s", dispatchDrainPeriod(cfg, replicas)),
+		)
+	}
+
+	if cfg.DetailedDispatchMetrics {
+		// exposed on --metrics-addr, which kube-rbac-proxy already serves to the scraper
+		args = append(args,
+			"--dispatch-cluster-metrics-enabled=true",
+			"--dispatch-cache-metrics=true",
+		)
+	}
+
+	args = append(args, fmt.Sprintf("--dispatch-max-depth=%d", maxDepth(cfg)))
+
+	if cfg.DispatchConcurrencyLimit != nil {
+		args = append(args, fmt.Sprintf("--dispatch-concurrency-limit=%d", *cfg.DispatchConcurrencyLimit))
+	}
+
+	args = append(args, secondaryDispatchArgs(cfg)...)
+
+	if cfg.WatchEnabled {
+		args = append(args, fmt.Sprintf("--datastore-revision-quantization-interval=%s", watchRevisionQuantizationInterval))
+	}
+
+	args = append(args, fmt.Sprintf("--disable-v0-api=%t", v0APIDisabled(cfg)))
+
+	if cfg.EnableSchemaReflection {
+		args = append(args, "--enable-experimental-schema-reflection=true")
+	}
+
+	if cfg.GRPCMaxMessageSize != nil {
+		args = append(args,
+			fmt.Sprintf("--grpc-max-recv-msg-size=%d", *cfg.GRPCMaxMessageSize),
+			fmt.Sprintf("--grpc-max-send-msg-size=%d", *cfg.GRPCMaxMessageSize),
+		)
+	}
+
+	if cfg.GRPCMaxConcurrentStreams != nil {
+		args = append(args, fmt.Sprintf("--grpc-max-concurrent-streams=%d", *cfg.GRPCMaxConcurrentStreams))
+	}
+
+	if cfg.HTTPEnabled {
+		args = append(args,
+			"--http-enabled=true",
+			fmt.Sprintf("--http-addr=:%d", httpPort(cfg)),
+		)
+	}
+
+	return args
+}
+
+// errUnsupportedByImage is returned for options, whose flags the pinned SpiceDB image does not know: SpiceDB exits on
+// unknown flags, so rendering them would only break the Deployment.
+func errUnsupportedByImage(field string) error {
+	return fmt.Errorf("%s is not supported by the pinned SpiceDB image %s", field, ImageTag)
+}
+
+// validateExtraArgs rejects extra args, which set a flag already set by the args managed by the component.
+func validateExtraArgs(field string, managed, extra []string) error {
+	managedFlags := make(map[string]struct{}, len(managed))
+	for _, arg := range managed {
+		if name, ok := flagName(arg); ok {
+			managedFlags[name] = struct{}{}
+		}
+	}
+
+	for _, arg := range extra {
+		name, ok := flagName(arg)
+		if !ok {
+			continue
+		}
+		if _, conflict := managedFlags[name]; conflict {
+			return fmt.Errorf("%s must not set --%s, it is managed by the installer", field, name)
+		}
+	}
+
+	return nil
+}
+
+// flagName returns the name of a flag argument like --name=value, and false for arguments which are not flags.
+func flagName(arg string) (string, bool) {
+	if !strings.HasPrefix(arg, "-") {
+		return "", false
+	}
+
+	name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+	return name, name != ""
+}
+
+// watchRevisionQuantizationInterval replaces the SpiceDB default of 5s when clients depend on the Watch API. SpiceDB
+// serves Watch unconditionally, but reads at quantized revisions trail the changes a Watch-fed cache has already seen.
+const watchRevisionQuantizationInterval = 1 * time.Second
+
+const defaultDispatchDrainPeriod = 5 * time.Second
+
+// dispatchDrainPeriod returns how long a terminating pod keeps serving, which only matters when requests are dispatched
+// between replicas. The SpiceDB image has neither a shell nor sleep for a preStop hook, SpiceDB drains in-process instead:
+// it keeps serving for the grace period after receiving SIGTERM, while its endpoint is removed from the dispatch ring.
+func dispatchDrainPeriod(cfg *experimental.SpiceDBConfig, replicas int32) time.Duration {
+	if replicas <= 1 {
+		return 0
+	}
+	if cfg.DispatchDrainPeriod != nil {
+		return time.Duration(*cfg.DispatchDrainPeriod)
+	}
+
+	return defaultDispatchDrainPeriod
+}
+
+const (
+	defaultMemoryReadinessInitialDelay    = 1 * time.Second
+	defaultDatastoreReadinessInitialDelay = 10 * time.Second
+)
+
+// readinessInitialDelaySeconds returns the initial delay of the readiness probe. Unless configured, it depends on the
+// datastore engine: probing too early a SpiceDB still connecting to its datastore only delays it becoming ready.
+func readinessInitialDelaySeconds(cfg *experimental.SpiceDBConfig) int32 {
+	delay := defaultDatastoreReadinessInitialDelay
+	if datastoreEngine(cfg) == experimental.SpiceDBDatastoreEngineMemory {
+		delay = defaultMemoryReadinessInitialDelay
+	}
+	if cfg.ReadinessInitialDelay != nil {
+		delay = time.Duration(*cfg.ReadinessInitialDelay)
+	}
+
+	return int32(delay.Seconds())
+}
+
+func secondaryDispatchEnabled(cfg *experimental.SpiceDBConfig) bool {
+	return cfg.SecondaryDispatch != nil && cfg.SecondaryDispatch.Enabled
+}
+
+// secondaryDispatchArgs returns one flag per upstream and expression, as SpiceDB merges repeated map flags. The
+// expressions are quoted, since SpiceDB splits map flags at commas, which CEL lists contain.
+func secondaryDispatchArgs(cfg *experimental.SpiceDBConfig) []string {
+	if !secondaryDispatchEnabled(cfg) {
+		return nil
+	}
+
+	var args []string
+	for _, upstream := range cfg.SecondaryDispatch.Upstreams {
+		args = append(args, fmt.Sprintf("--dispatch-secondary-upstream-addrs=%s=%s", upstream.Name, upstream.Address))
+	}
+
+	methods := make([]string, 0, len(cfg.SecondaryDispatch.Exprs))
+	for method := range cfg.SecondaryDispatch.Exprs {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		entry := fmt.Sprintf("%s=%s", method, cfg.SecondaryDispatch.Exprs[method])
+		args = append(args, fmt.Sprintf("--dispatch-secondary-upstream-exprs=\"%s\"", strings.ReplaceAll(entry, `"`, `""`)))
+	}
+
+	return args
+}
+
+func validateSecondaryDispatch(cfg *experimental.SpiceDBConfig, replicas int32) error {
+	if !secondaryDispatchEnabled(cfg) {
+		return nil
+	}
+
+	if replicas <= 1 {
+		return errors.New("spicedb.secondaryDispatch requires clustered mode, with more than one replica")
+	}
+	if len(cfg.SecondaryDispatch.Upstreams) == 0 {
+		return errors.New("spicedb.secondaryDispatch requires at least one upstream")
+	}
+
+	names := make(map[string]bool, len(cfg.SecondaryDispatch.Upstreams))
+	for _, upstream := range cfg.SecondaryDispatch.Upstreams {
+		if upstream.Name == "" || strings.ContainsAny(upstream.Name, "=,") {
+			return fmt.Errorf("spicedb.secondaryDispatch.upstreams name %q must be set, and must not contain '=' or ','", upstream.Name)
+		}
+		if names[upstream.Name] {
+			return fmt.Errorf("spicedb.secondaryDispatch.upstreams name %q is not unique", upstream.Name)
+		}
+		names[upstream.Name] = true
+	}
+
+	for method, expr := range cfg.SecondaryDispatch.Exprs {
+		if method == "" || strings.ContainsAny(method, "=,") || expr == "" {
+			return fmt.Errorf("spicedb.secondaryDispatch.exprs entry %q must have a method without '=' or ',', and an expression", method)
+		}
+	}
+
+	return errUnsupportedByImage("spicedb.secondaryDispatch")
+}
+
+// dispatchMembershipProbe returns a liveness probe, which fails while the dispatch server of the pod, which its peers
+// dispatch to, stops serving. Only the local server is checked: the Service has no endpoints while all pods are unready,
+// a probe through the Service would then fail on every pod, and restart all of them at once.
+func dispatchMembershipProbe(cfg *experimental.SpiceDBConfig, replicas int32) *corev1.Probe {
+	if !cfg.DispatchMembershipProbe || replicas <= 1 {
+		return nil
+	}
+
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &v1.ExecAction{
+				Command: []string{healthProbeBinary(cfg), "-v", fmt.Sprintf("-addr=localhost:%d", ContainerDispatchPort)},
+			},
+		},
+		InitialDelaySeconds: 60,
+		PeriodSeconds:       30,
+		FailureThreshold:    5,
+		SuccessThreshold:    1,
+		TimeoutSeconds:      3,
+	}
+}
+
+// v0APIDisabled reports whether the deprecated v0 API is turned off, which it is unless explicitly enabled.
+func v0APIDisabled(cfg *experimental.SpiceDBConfig) bool {
+	if cfg.DisableV0API != nil {
+		return *cfg.DisableV0API
+	}
+
+	return true
+}
+
+const defaultMaxDepth = 50
+
+// maxDepth returns the configured maximum depth of permission checks, falling back to the SpiceDB default.
+func maxDepth(cfg *experimental.SpiceDBConfig) int {
+	if cfg.MaxDepth != nil {
+		return *cfg.MaxDepth
+	}
+
+	return defaultMaxDepth
+}
+
+// reservedAnnotationPrefix marks annotations set by Gitpod itself, e.g. common.AnnotationConfigChecksum.
+const reservedAnnotationPrefix = "gitpod.io/"
+
+// validateAnnotations rejects configured annotations which would overwrite annotations reserved for Gitpod.
+func validateAnnotations(field string, annotations map[string]string) error {
+	for k := range annotations {
+		if strings.HasPrefix(k, reservedAnnotationPrefix) {
+			return fmt.Errorf("%s must not set reserved annotation %q", field, k)
+		}
+	}
+
+	return nil
+}
+
 func dbEnvVars(ctx *common.RenderContext) []corev1.EnvVar {
 	return common.DatabaseEnv(&ctx.Config)
 }
@@ -185,30 +513,396 @@ func dbWaiter(ctx *common.RenderContext) v1.Container {
 	return *databaseWaiter
 }
 
+const (
+	defaultStartupRetryMaxAttempts = 30
+	defaultStartupRetryInterval    = 2 * time.Second
+)
+
+func waitsForDatastore(cfg *experimental.SpiceDBConfig) bool {
+	return cfg.Datastore != nil && (cfg.Datastore.WaitForDatastore || startupRetryEnabled(cfg))
+}
+
+func startupRetryEnabled(cfg *experimental.SpiceDBConfig) bool {
+	return cfg.Datastore != nil && cfg.Datastore.StartupRetry != nil && cfg.Datastore.StartupRetry.Enabled
+}
+
+// datastoreWaiterScript waits until the datastore accepts TCP connections. With startup retry enabled it gives up after
+// the configured attempts, otherwise it waits indefinitely.
+func datastoreWaiterScript(cfg *experimental.SpiceDBConfig) string {
+	probe := fmt.Sprintf("nc -z -w 2 %s %s", datastoreHost(cfg), datastorePort(cfg))
+	if !startupRetryEnabled(cfg) {
+		return fmt.Sprintf("until %s; do echo waiting for datastore; sleep 2; done", probe)
+	}
+
+	retry := cfg.Datastore.StartupRetry
+	attempts := defaultStartupRetryMaxAttempts
+	if retry.MaxAttempts != nil {
+		attempts = *retry.MaxAttempts
+	}
+	interval := defaultStartupRetryInterval
+	if retry.Interval != nil {
+		interval = time.Duration(*retry.Interval)
+	}
+
+	// $$ escapes the arithmetic expansion from the variable expansion of kubernetes
+	return fmt.Sprintf(
+		"attempt=1; until %s; do if [ $attempt -ge %d ]; then echo datastore not reachable after %d attempts; exit 1; fi; attempt=$$((attempt+1)); echo waiting for datastore; sleep %d; done",
+		probe, attempts, attempts, int(interval.Seconds()),
+	)
+}
+
+// datastoreWaiter blocks until the datastore accepts TCP connections
+func datastoreWaiter(ctx *common.RenderContext, cfg *experimental.SpiceDBConfig) v1.Container {
+	return v1.Container{
+		Name:            "datastore-waiter",
+		Image:           ctx.ImageName(common.ThirdPartyContainerRepo(ctx.Config.Repository, common.DockerRegistryURL), InitContainerImage, InitContainerTag),
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command: []string{
+			"sh",
+			"-c",
+			datastoreWaiterScript(cfg),
+		},
+		Env: dbEnvVars(ctx),
+		SecurityContext: &corev1.SecurityContext{
+			Privileged:               pointer.Bool(false),
+			AllowPrivilegeEscalation: pointer.Bool(false),
+			RunAsUser:                pointer.Int64(65532),
+			RunAsNonRoot:             pointer.Bool(true),
+		},
+	}
+}
+
+// metricsProxy returns the kube-rbac-proxy container serving the metrics of SpiceDB, which skips authentication and
+// authorization of the metrics path when anonymous metrics are allowed.
+func metricsProxy(ctx *common.RenderContext, cfg *experimental.SpiceDBConfig) v1.Container {
+	proxy := common.KubeRBACProxyContainer(ctx)
+	if cfg.AllowAnonymousMetrics {
+		proxy.Args = append(proxy.Args, "--ignore-paths=/metrics")
+	}
+
+	return *proxy
+}
+
+const (
+	healthProbeVolumeName  = "grpc-health-probe"
+	healthProbeMountPath   = "/grpc-health-probe"
+	healthProbeMountBinary = healthProbeMountPath + "/grpc_health_probe"
+)
+
+// healthProbeBinary returns the grpc_health_probe binary the probes run. The SpiceDB image ships it on the PATH,
+// the copy of the installer init container is only used when spicedb.healthProbe is configured.
+func healthProbeBinary(cfg *experimental.SpiceDBConfig) string {
+	if cfg.HealthProbe == nil {
+		return "grpc_health_probe"
+	}
+
+	return healthProbeMountBinary
+}
+
+func healthProbeVolumes(cfg *experimental.SpiceDBConfig) []corev1.Volume {
+	if cfg.HealthProbe == nil {
+		return nil
+	}
+
+	return []corev1.Volume{
+		{
+			Name:         healthProbeVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+	}
+}
+
+func healthProbeVolumeMounts(cfg *experimental.SpiceDBConfig) []corev1.VolumeMount {
+	if cfg.HealthProbe == nil {
+		return nil
+	}
+
+	return []corev1.VolumeMount{
+		{
+			Name:      healthProbeVolumeName,
+			MountPath: healthProbeMountPath,
+			ReadOnly:  true,
+		},
+	}
+}
+
+// healthProbeInstaller copies the grpc_health_probe binary into a volume shared with the SpiceDB container,
+// which runs the binary as its readiness probe.
+func healthProbeInstaller(ctx *common.RenderContext, cfg *experimental.SpiceDBConfig) v1.Container {
+	image := ctx.ImageName(common.ThirdPartyContainerRepo(ctx.Config.Repository, RegistryRepo), RegistryImage, HealthProbeImageTag)
+	if cfg.HealthProbe.Image != "" {
+		image = cfg.HealthProbe.Image
+	}
+	binaryPath := HealthProbeBinaryPath
+	if cfg.HealthProbe.BinaryPath != "" {
+		binaryPath = cfg.HealthProbe.BinaryPath
+	}
+
+	return v1.Container{
+		Name:            "grpc-health-probe-installer",
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"cp", binaryPath, healthProbeMountBinary},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      healthProbeVolumeName,
+				MountPath: healthProbeMountPath,
+			},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			Privileged:               pointer.Bool(false),
+			AllowPrivilegeEscalation: pointer.Bool(false),
+			RunAsUser:                pointer.Int64(65532),
+			RunAsNonRoot:             pointer.Bool(true),
+		},
+	}
+}
+
+// schemaVersionCheckContainers returns a sidecar, which only becomes ready once SpiceDB serves a schema carrying the
+// required version marker. The pod, and with it the Service endpoint, is not ready before.
+func schemaVersionCheckContainers(ctx *common.RenderContext, cfg *experimental.SpiceDBConfig) []corev1.Container {
+	if cfg.RequireSchemaVersion == "" {
+		return nil
+	}
+
+	return []corev1.Container{
+		{
+			Name:            "schema-version-check",
+			Image:           ctx.ImageName(common.ThirdPartyContainerRepo(ctx.Config.Repository, RegistryRepo), ZedRegistryImage, ZedImageTag),
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         []string{"sh", "-c", "while true; do sleep 3600; done"},
+			Env: []corev1.EnvVar{
+				{
+					Name: "SPICEDB_GRPC_PRESHARED_KEY",
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{
+								Name: cfg.SecretRef,
+							},
+							Key: presharedKeyNames(cfg)[0],
+						},
+					},
+				},
+			},
+			ReadinessProbe: &corev1.Probe{
+				ProbeHandler: corev1.ProbeHandler{
+					Exec: &v1.ExecAction{
+						Command: []string{
+							"sh",
+							"-c",
+							fmt.Sprintf(`zed schema read --endpoint=localhost:%d --insecure --token="$SPICEDB_GRPC_PRESHARED_KEY" | grep -qF %q`, ContainerGRPCPort, schemaVersionMarker(cfg.RequireSchemaVersion)),
+						},
+					},
+				},
+				InitialDelaySeconds: 5,
+				PeriodSeconds:       30,
+				FailureThreshold:    5,
+				SuccessThreshold:    1,
+				TimeoutSeconds:      3,
+			},
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1m"),
+				corev1.ResourceMemory: resource.MustParse("16Mi"),
+			}},
+			SecurityContext: &corev1.SecurityContext{
+				AllowPrivilegeEscalation: pointer.Bool(false),
+				RunAsUser:                pointer.Int64(65532),
+				RunAsGroup:               pointer.Int64(65532),
+				RunAsNonRoot:             pointer.Bool(true),
+			},
+		},
+	}
+}
+
+// schemaVersionMarker is the doc comment a schema carries to declare its version, e.g. `/** gitpod-schema-version: 3 */`.
+// SpiceDB preserves doc comments, so the marker is part of the schema served by the API.
+func schemaVersionMarker(version string) string {
+	return fmt.Sprintf("gitpod-schema-version: %s", version)
+}
+
 func spicedbEnvVars(ctx *common.RenderContext) []corev1.EnvVar {
 	cfg := getExperimentalSpiceDBConfig(ctx)
 	if cfg == nil {
 		return nil
 	}
 
+	var readReplicaEnv []corev1.EnvVar
+	if uris := readReplicaConnURIs(cfg); len(uris) > 0 {
+		readReplicaEnv = append(readReplicaEnv, corev1.EnvVar{
+			Name:  "SPICEDB_DATASTORE_READ_REPLICA_CONN_URI",
+			Value: strings.Join(uris, ","),
+		})
+	}
+
+	var caBundleEnv []corev1.EnvVar
+	if cfg.CABundleSecretRef != "" {
+		// Go reads additional roots from SSL_CERT_FILE, which covers the datastore driver and the OpenTelemetry exporter alike
+		caBundleEnv = append(caBundleEnv, corev1.EnvVar{
+			Name:  "SSL_CERT_FILE",
+			Value: filepath.Join(caBundleMountPath, SecretCABundleKeyName),
+		})
+	}
+
 	return common.MergeEnv(
 		dbEnvVars(ctx),
+		// must precede the connection URIs, which reference it
+		datastorePasswordEnvVars(cfg),
 		[]corev1.EnvVar{
 			{
 				Name:  "SPICEDB_DATASTORE_CONN_URI",
-				Value: "$(DB_USERNAME):$(DB_PASSWORD)@tcp($(DB_HOST):$(DB_PORT))/authorization?parseTime=true",
+				Value: datastoreConnURI(cfg, datastoreHost(cfg), datastorePort(cfg)),
 			},
-			{
-				Name: "SPICEDB_GRPC_PRESHARED_KEY",
-				ValueFrom: &corev1.EnvVarSource{
-					SecretKeyRef: &corev1.SecretKeySelector{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: cfg.SecretRef,
-						},
-						Key: SecretPresharedKeyName,
+		},
+		presharedKeyEnvVars(cfg),
+		readReplicaEnv,
+		caBundleEnv,
+	)
+}
+
+// presharedKeyNames returns the keys of the secret holding the preshared keys, defaulting to the single "presharedKey".
+func presharedKeyNames(cfg *experimental.SpiceDBConfig) []string {
+	if len(cfg.PresharedKeys) == 0 {
+		return []string{SecretPresharedKeyName}
+	}
+
+	return cfg.PresharedKeys
+}
+
+func validatePresharedKeys(cfg *experimental.SpiceDBConfig) error {
+	if cfg.PresharedKeys != nil && len(cfg.PresharedKeys) == 0 {
+		return errors.New("spicedb.presharedKeys must list at least one key")
+	}
+
+	seen := make(map[string]struct{}, len(cfg.PresharedKeys))
+	for _, key := range cfg.PresharedKeys {
+		if key == "" {
+			return errors.New("spicedb.presharedKeys must not contain empty keys")
+		}
+		if _, ok := seen[key]; ok {
+			return fmt.Errorf("spicedb.presharedKeys contains %q more than once", key)
+		}
+		seen[key] = struct{}{}
+	}
+
+	return nil
+}
+
+// presharedKeyEnvVars returns the env vars holding the preshared keys. A single key is read by SpiceDB from
+// SPICEDB_GRPC_PRESHARED_KEY directly, multiple keys are passed through repeated flags, see presharedKeyArgs.
+func presharedKeyEnvVars(cfg *experimental.SpiceDBConfig) []corev1.EnvVar {
+	keys := presharedKeyNames(cfg)
+	if len(keys) == 1 {
+		return []corev1.EnvVar{presharedKeyEnvVar("SPICEDB_GRPC_PRESHARED_KEY", cfg.SecretRef, keys[0])}
+	}
+
+	envs := make([]corev1.EnvVar, 0, len(keys))
+	for i, key := range keys {
+		envs = append(envs, presharedKeyEnvVar(presharedKeyEnvName(i), cfg.SecretRef, key))
+	}
+
+	return envs
+}
+
+// presharedKeyArgs returns a --grpc-preshared-key flag per preshared key, when more than one is configured.
+func presharedKeyArgs(cfg *experimental.SpiceDBConfig) []string {
+	keys := presharedKeyNames(cfg)
+	if len(keys) == 1 {
+		return nil
+	}
+
+	args := make([]string, 0, len(keys))
+	for i := range keys {
+		// the kubelet expands $(VAR) references in args, so the keys never appear in the pod spec
+		args = append(args, fmt.Sprintf("--grpc-preshared-key=$(%s)", presharedKeyEnvName(i)))
+	}
+
+	return args
+}
+
+func presharedKeyEnvName(i int) string {
+	return fmt.Sprintf("SPICEDB_GRPC_PRESHARED_KEY_%d", i)
+}
+
+func presharedKeyEnvVar(name, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: secretName,
+				},
+				Key: key,
+			},
+		},
+	}
+}
+
+const caBundleMountPath = "/etc/spicedb/ca-bundle"
+
+func caBundleVolumes(cfg *experimental.SpiceDBConfig) []corev1.Volume {
+	if cfg.CABundleSecretRef == "" {
+		return nil
+	}
+
+	return []corev1.Volume{
+		{
+			Name: "ca-bundle",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: cfg.CABundleSecretRef,
+					Items: []corev1.KeyToPath{
+						{Key: SecretCABundleKeyName, Path: SecretCABundleKeyName},
 					},
 				},
 			},
 		},
-	)
+	}
+}
+
+func caBundleVolumeMounts(cfg *experimental.SpiceDBConfig) []corev1.VolumeMount {
+	if cfg.CABundleSecretRef == "" {
+		return nil
+	}
+
+	return []corev1.VolumeMount{
+		{
+			Name:      "ca-bundle",
+			MountPath: caBundleMountPath,
+			ReadOnly:  true,
+		},
+	}
+}
+
+func relationshipIntegrityVolumes(cfg *experimental.SpiceDBConfig) []corev1.Volume {
+	if !relationshipIntegrityEnabled(cfg) {
+		return nil
+	}
+
+	return []corev1.Volume{
+		{
+			Name: "relationship-integrity-key",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: cfg.Relationships.Integrity.KeySecretRef,
+					Items: []corev1.KeyToPath{
+						{Key: SecretIntegrityKeyName, Path: SecretIntegrityKeyName},
+					},
+				},
+			},
+		},
+	}
+}
+
+func relationshipIntegrityVolumeMounts(cfg *experimental.SpiceDBConfig) []corev1.VolumeMount {
+	if !relationshipIntegrityEnabled(cfg) {
+		return nil
+	}
+
+	return []corev1.VolumeMount{
+		{
+			Name:      "relationship-integrity-key",
+			MountPath: relationshipIntegrityKeyMountPath,
+			ReadOnly:  true,
+		},
+	}
 }
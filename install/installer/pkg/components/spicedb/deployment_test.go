@@ -0,0 +1,1307 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+
+	"github.com/gitpod-io/gitpod/common-go/util"
+	"github.com/gitpod-io/gitpod/installer/pkg/common"
+	config "github.com/gitpod-io/gitpod/installer/pkg/config/v1"
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
+)
+
+func TestDeployment_HTTPGatewayDisabledByDefault(t *testing.T) {
+	ctx := renderContextWithSpiceDBEnabled(t)
+
+	container := spicedbContainer(t, ctx)
+	require.NotContains(t, container.Args, "--http-enabled=true")
+	for _, port := range container.Ports {
+		require.NotEqual(t, ContainerHTTPName, port.Name)
+	}
+}
+
+func TestDeployment_HTTPGatewayEnabled(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:     true,
+		SecretRef:   "spicedb-secret",
+		HTTPEnabled: true,
+	})
+
+	container := spicedbContainer(t, ctx)
+	require.Contains(t, container.Args, "--http-enabled=true")
+	require.Contains(t, container.Args, "--http-addr=:8443")
+	require.Contains(t, container.Ports, corev1.ContainerPort{
+		ContainerPort: ContainerHTTPPort,
+		Name:          ContainerHTTPName,
+		Protocol:      *common.TCPProtocol,
+	})
+}
+
+func spicedbDeployment(t *testing.T, ctx *common.RenderContext) *appsv1.Deployment {
+	t.Helper()
+
+	objects, err := deployment(ctx)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+
+	dpl, ok := objects[0].(*appsv1.Deployment)
+	require.True(t, ok)
+
+	return dpl
+}
+
+func spicedbContainer(t *testing.T, ctx *common.RenderContext) corev1.Container {
+	t.Helper()
+
+	dpl := spicedbDeployment(t, ctx)
+	for _, c := range dpl.Spec.Template.Spec.Containers {
+		if c.Name == ContainerName {
+			return c
+		}
+	}
+
+	require.Fail(t, "spicedb container not found")
+	return corev1.Container{}
+}
+
+func TestDeployment_WatchEnabled(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:      true,
+		SecretRef:    "spicedb-secret",
+		WatchEnabled: true,
+	})
+
+	container := spicedbContainer(t, ctx)
+	require.Contains(t, container.Args, "--datastore-revision-quantization-interval=1s")
+	require.Contains(t, container.Args, "--datastore-engine=mysql")
+	for _, arg := range container.Args {
+		require.NotContains(t, arg, "--watch-api-enabled")
+	}
+
+	container = spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+	for _, arg := range container.Args {
+		require.NotContains(t, arg, "--datastore-revision-quantization-interval")
+	}
+}
+
+func TestDeployment_WatchEnabledEngines(t *testing.T) {
+	for _, engine := range []experimental.SpiceDBDatastoreEngine{
+		experimental.SpiceDBDatastoreEngineMySQL,
+		experimental.SpiceDBDatastoreEnginePostgres,
+		experimental.SpiceDBDatastoreEngineCockroachDB,
+		experimental.SpiceDBDatastoreEngineMemory,
+	} {
+		t.Run(string(engine), func(t *testing.T) {
+			require.True(t, engineSupportsWatch(engine))
+		})
+	}
+
+	t.Run("unknown engine", func(t *testing.T) {
+		err := validateDatastoreConfig(&experimental.SpiceDBConfig{
+			WatchEnabled: true,
+			Datastore:    &experimental.SpiceDBDatastoreConfig{Engine: "spanner"},
+		})
+		require.ErrorContains(t, err, "spicedb.watchEnabled")
+	})
+}
+
+func TestDeployment_DatastoreConnPoolDefaults(t *testing.T) {
+	ctx := renderContextWithSpiceDBEnabled(t)
+
+	container := spicedbContainer(t, ctx)
+	require.Contains(t, container.Args, "--datastore-conn-max-open=100")
+	require.Contains(t, container.Args, "--datastore-conn-min-open=10")
+	require.Contains(t, container.Args, "--datastore-conn-max-lifetime=30m0s")
+	require.Contains(t, container.Args, "--datastore-conn-max-idletime=5m0s")
+}
+
+func TestDeployment_DatastoreConnPoolFromConfig(t *testing.T) {
+	lifetime := util.Duration(5 * time.Minute)
+	idleTime := util.Duration(90 * time.Second)
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Datastore: &experimental.SpiceDBDatastoreConfig{
+			MaxOpenConns:    pointer.Int(20),
+			MinOpenConns:    pointer.Int(5),
+			ConnMaxLifetime: &lifetime,
+			ConnMaxIdleTime: &idleTime,
+		},
+	})
+
+	container := spicedbContainer(t, ctx)
+	require.Contains(t, container.Args, "--datastore-conn-max-open=20")
+	require.Contains(t, container.Args, "--datastore-conn-min-open=5")
+	require.Contains(t, container.Args, "--datastore-conn-max-lifetime=5m0s")
+	require.Contains(t, container.Args, "--datastore-conn-max-idletime=1m30s")
+}
+
+func TestDeployment_CockroachDBArgs(t *testing.T) {
+	crdb := &experimental.SpiceDBCockroachDBConfig{
+		MaxTxRetries:    pointer.Int(25),
+		OverlapStrategy: "prefix",
+	}
+
+	t.Run("rendered for cockroachdb engine", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				Engine:      experimental.SpiceDBDatastoreEngineCockroachDB,
+				CockroachDB: crdb,
+			},
+		})
+
+		container := spicedbContainer(t, ctx)
+		require.Contains(t, container.Args, "--datastore-engine=cockroachdb")
+		require.Contains(t, container.Args, "--datastore-max-tx-retries=25")
+		require.Contains(t, container.Args, "--datastore-tx-overlap-strategy=prefix")
+	})
+
+	t.Run("not rendered by default", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBEnabled(t)
+
+		container := spicedbContainer(t, ctx)
+		for _, arg := range container.Args {
+			require.NotContains(t, arg, "--datastore-max-tx-retries")
+			require.NotContains(t, arg, "--datastore-tx-overlap-strategy")
+		}
+	})
+
+	t.Run("rejected for other engines", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				Engine:      experimental.SpiceDBDatastoreEnginePostgres,
+				CockroachDB: crdb,
+			},
+		})
+
+		_, err := deployment(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spicedb.datastore.cockroachdb")
+	})
+}
+
+func TestDeployment_DatastoreWaiter(t *testing.T) {
+	t.Run("not rendered by default", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBEnabled(t)
+
+		dpl := spicedbDeployment(t, ctx)
+		for _, c := range dpl.Spec.Template.Spec.InitContainers {
+			require.NotEqual(t, "datastore-waiter", c.Name)
+		}
+	})
+
+	t.Run("targets configured datastore address", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				Host:             "spicedb-postgres",
+				Port:             5432,
+				WaitForDatastore: true,
+			},
+		})
+
+		dpl := spicedbDeployment(t, ctx)
+
+		var waiter *corev1.Container
+		for i, c := range dpl.Spec.Template.Spec.InitContainers {
+			if c.Name == "datastore-waiter" {
+				waiter = &dpl.Spec.Template.Spec.InitContainers[i]
+			}
+		}
+		require.NotNil(t, waiter, "datastore-waiter init container must be rendered")
+		require.Contains(t, waiter.Command[len(waiter.Command)-1], "nc -z -w 2 spicedb-postgres 5432")
+	})
+}
+
+func TestDeployment_StartupRetry(t *testing.T) {
+	datastoreWaiterCommand := func(t *testing.T, ctx *common.RenderContext) string {
+		t.Helper()
+
+		for _, c := range spicedbDeployment(t, ctx).Spec.Template.Spec.InitContainers {
+			if c.Name == "datastore-waiter" {
+				return c.Command[len(c.Command)-1]
+			}
+		}
+		require.Fail(t, "datastore-waiter init container must be rendered")
+		return ""
+	}
+
+	t.Run("waits indefinitely without startup retry", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				WaitForDatastore: true,
+			},
+		})
+
+		require.NotContains(t, datastoreWaiterCommand(t, ctx), "exit 1")
+	})
+
+	t.Run("gives up after default attempts", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				StartupRetry: &experimental.SpiceDBStartupRetryConfig{Enabled: true},
+			},
+		})
+
+		command := datastoreWaiterCommand(t, ctx)
+		require.Contains(t, command, "nc -z -w 2 $(DB_HOST) $(DB_PORT)")
+		require.Contains(t, command, "if [ $attempt -ge 30 ]; then")
+		require.Contains(t, command, "exit 1")
+		require.Contains(t, command, "attempt=$$((attempt+1))")
+		require.Contains(t, command, "sleep 2;")
+	})
+
+	t.Run("applies configured attempts and interval", func(t *testing.T) {
+		interval := util.Duration(5 * time.Second)
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				StartupRetry: &experimental.SpiceDBStartupRetryConfig{
+					Enabled:     true,
+					MaxAttempts: pointer.Int(10),
+					Interval:    &interval,
+				},
+			},
+		})
+
+		command := datastoreWaiterCommand(t, ctx)
+		require.Contains(t, command, "if [ $attempt -ge 10 ]; then")
+		require.Contains(t, command, "sleep 5;")
+	})
+
+	t.Run("rejects intervals below a second", func(t *testing.T) {
+		interval := util.Duration(500 * time.Millisecond)
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				StartupRetry: &experimental.SpiceDBStartupRetryConfig{Enabled: true, Interval: &interval},
+			},
+		})
+
+		_, err := deployment(ctx)
+		require.ErrorContains(t, err, "spicedb.datastore.startupRetry.interval must be at least 1s")
+	})
+
+	t.Run("rejects memory engine", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				Engine:       experimental.SpiceDBDatastoreEngineMemory,
+				StartupRetry: &experimental.SpiceDBStartupRetryConfig{Enabled: true},
+			},
+		})
+
+		_, err := deployment(ctx)
+		require.ErrorContains(t, err, "spicedb.datastore.startupRetry is not supported")
+	})
+}
+
+func TestDeployment_AllowAnonymousMetrics(t *testing.T) {
+	metricsProxyArgs := func(t *testing.T, ctx *common.RenderContext) []string {
+		t.Helper()
+
+		for _, c := range spicedbDeployment(t, ctx).Spec.Template.Spec.Containers {
+			if c.Name == "kube-rbac-proxy" {
+				return c.Args
+			}
+		}
+		require.Fail(t, "kube-rbac-proxy container must be rendered")
+		return nil
+	}
+
+	t.Run("requires authentication by default", func(t *testing.T) {
+		for _, arg := range metricsProxyArgs(t, renderContextWithSpiceDBEnabled(t)) {
+			require.NotContains(t, arg, "--ignore-paths")
+		}
+	})
+
+	t.Run("skips authentication when allowed", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:               true,
+			SecretRef:             "spicedb-secret",
+			AllowAnonymousMetrics: true,
+		})
+
+		require.Contains(t, metricsProxyArgs(t, ctx), "--ignore-paths=/metrics")
+	})
+}
+
+func TestDeployment_DetailedDispatchMetrics(t *testing.T) {
+	t.Run("not rendered by default", func(t *testing.T) {
+		container := spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+		require.NotContains(t, container.Args, "--dispatch-cluster-metrics-enabled=true")
+		require.NotContains(t, container.Args, "--dispatch-cache-metrics=true")
+	})
+
+	t.Run("rendered when enabled", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:                 true,
+			SecretRef:               "spicedb-secret",
+			DetailedDispatchMetrics: true,
+		})
+
+		container := spicedbContainer(t, ctx)
+		require.Contains(t, container.Args, "--dispatch-cluster-metrics-enabled=true")
+		require.Contains(t, container.Args, "--dispatch-cache-metrics=true")
+	})
+}
+
+func TestDeployment_GRPCMaxMessageSize(t *testing.T) {
+	t.Run("not rendered by default", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBEnabled(t)
+
+		container := spicedbContainer(t, ctx)
+		for _, arg := range container.Args {
+			require.NotContains(t, arg, "-msg-size=")
+		}
+		for _, env := range Env(ctx) {
+			require.NotEqual(t, "SPICEDB_GRPC_MAX_MESSAGE_SIZE", env.Name)
+		}
+	})
+
+	t.Run("rendered on server and client", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:            true,
+			SecretRef:          "spicedb-secret",
+			GRPCMaxMessageSize: pointer.Int(16777216),
+		})
+
+		container := spicedbContainer(t, ctx)
+		require.Contains(t, container.Args, "--grpc-max-recv-msg-size=16777216")
+		require.Contains(t, container.Args, "--grpc-max-send-msg-size=16777216")
+		require.Contains(t, Env(ctx), corev1.EnvVar{Name: "SPICEDB_GRPC_MAX_MESSAGE_SIZE", Value: "16777216"})
+	})
+}
+
+func TestDeployment_GRPCKeepalive(t *testing.T) {
+	t.Run("not rendered by default", func(t *testing.T) {
+		for _, env := range Env(renderContextWithSpiceDBEnabled(t)) {
+			require.NotContains(t, env.Name, "SPICEDB_GRPC_KEEPALIVE_")
+		}
+	})
+
+	t.Run("rendered on clients", func(t *testing.T) {
+		keepaliveTime := util.Duration(10 * time.Minute)
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			GRPCKeepalive: &experimental.SpiceDBGRPCKeepaliveConfig{
+				Time: &keepaliveTime,
+			},
+		})
+
+		_, err := deployment(ctx)
+		require.NoError(t, err)
+
+		envs := Env(ctx)
+		require.Contains(t, envs, corev1.EnvVar{Name: "SPICEDB_GRPC_KEEPALIVE_TIME_MS", Value: "600000"})
+		require.Contains(t, envs, corev1.EnvVar{Name: "SPICEDB_GRPC_KEEPALIVE_TIMEOUT_MS", Value: "20000"})
+		require.Contains(t, envs, corev1.EnvVar{Name: "SPICEDB_GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM", Value: "false"})
+	})
+
+	t.Run("embedded spicedb rejects a time below the server minimum", func(t *testing.T) {
+		keepaliveTime := util.Duration(30 * time.Second)
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			GRPCKeepalive: &experimental.SpiceDBGRPCKeepaliveConfig{
+				Time: &keepaliveTime,
+			},
+		})
+
+		_, err := deployment(ctx)
+		require.ErrorContains(t, err, "spicedb.grpcKeepalive.time must be at least 5m0s")
+	})
+
+	t.Run("embedded spicedb rejects pings without stream", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			GRPCKeepalive: &experimental.SpiceDBGRPCKeepaliveConfig{
+				PermitWithoutStream: true,
+			},
+		})
+
+		_, err := deployment(ctx)
+		require.ErrorContains(t, err, "spicedb.grpcKeepalive.permitWithoutStream")
+	})
+}
+
+func TestDeployment_ReadReplicaConns(t *testing.T) {
+	t.Run("not rendered by default", func(t *testing.T) {
+		container := spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+		for _, env := range container.Env {
+			require.NotEqual(t, "SPICEDB_DATASTORE_READ_REPLICA_CONN_URI", env.Name)
+		}
+	})
+
+	t.Run("rendered when configured", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				ReadReplicaConns: []string{"replica-0:3306", "replica-1:3306"},
+			},
+		})
+
+		container := spicedbContainer(t, ctx)
+		require.Contains(t, container.Env, corev1.EnvVar{
+			Name:  "SPICEDB_DATASTORE_READ_REPLICA_CONN_URI",
+			Value: "$(DB_USERNAME):$(DB_PASSWORD)@tcp(replica-0:3306)/authorization?parseTime=true,$(DB_USERNAME):$(DB_PASSWORD)@tcp(replica-1:3306)/authorization?parseTime=true",
+		})
+	})
+
+	t.Run("rejected for unsupported engines", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				Engine:           experimental.SpiceDBDatastoreEngineCockroachDB,
+				ReadReplicaConns: []string{"replica-0:26257"},
+			},
+		})
+
+		_, err := deployment(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spicedb.datastore.readReplicaConns")
+	})
+}
+
+func TestDeployment_Hedging(t *testing.T) {
+	t.Run("disabled by default", func(t *testing.T) {
+		container := spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+		require.Contains(t, container.Args, "--datastore-request-hedging=false")
+	})
+
+	t.Run("rendered from config", func(t *testing.T) {
+		slow := util.Duration(20 * time.Millisecond)
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				Hedging: &experimental.SpiceDBHedgingConfig{
+					Enabled:          true,
+					InitialSlowValue: &slow,
+					MaxRequests:      pointer.Int(1000000),
+					Quantile:         pointer.Float64(0.95),
+				},
+			},
+		})
+
+		container := spicedbContainer(t, ctx)
+		require.Contains(t, container.Args, "--datastore-request-hedging=true")
+		require.Contains(t, container.Args, "--datastore-request-hedging-initial-slow-value=20ms")
+		require.Contains(t, container.Args, "--datastore-request-hedging-max-requests=1000000")
+		require.Contains(t, container.Args, "--datastore-request-hedging-quantile=0.95")
+		require.NotContains(t, container.Args, "--datastore-request-hedging=false")
+	})
+}
+
+func TestDeployment_RequireSchemaVersion(t *testing.T) {
+	t.Run("no check by default", func(t *testing.T) {
+		dpl := spicedbDeployment(t, renderContextWithSpiceDBEnabled(t))
+		for _, c := range dpl.Spec.Template.Spec.Containers {
+			require.NotEqual(t, "schema-version-check", c.Name)
+		}
+	})
+
+	t.Run("readiness checks the configured schema version", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:              true,
+			SecretRef:            "spicedb-secret",
+			RequireSchemaVersion: "3",
+		})
+
+		dpl := spicedbDeployment(t, ctx)
+		var check *corev1.Container
+		for i, c := range dpl.Spec.Template.Spec.Containers {
+			if c.Name == "schema-version-check" {
+				check = &dpl.Spec.Template.Spec.Containers[i]
+			}
+		}
+		require.NotNil(t, check, "schema-version-check container must be rendered")
+		require.NotNil(t, check.ReadinessProbe)
+
+		command := check.ReadinessProbe.Exec.Command
+		require.Contains(t, command[len(command)-1], "zed schema read --endpoint=localhost:50051")
+		require.Contains(t, command[len(command)-1], `grep -qF "gitpod-schema-version: 3"`)
+	})
+}
+
+func TestDeployment_Annotations(t *testing.T) {
+	t.Run("custom annotations are applied", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:               true,
+			SecretRef:             "spicedb-secret",
+			PodAnnotations:        map[string]string{"sidecar.istio.io/inject": "false"},
+			DeploymentAnnotations: map[string]string{"cost-center": "platform"},
+		})
+
+		dpl := spicedbDeployment(t, ctx)
+		require.Equal(t, "platform", dpl.Annotations["cost-center"])
+		require.NotContains(t, dpl.Annotations, "sidecar.istio.io/inject")
+		require.Equal(t, "false", dpl.Spec.Template.Annotations["sidecar.istio.io/inject"])
+		require.NotContains(t, dpl.Spec.Template.Annotations, "cost-center")
+	})
+
+	t.Run("reserved annotations are rejected", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:        true,
+			SecretRef:      "spicedb-secret",
+			PodAnnotations: map[string]string{common.AnnotationConfigChecksum: "overwritten"},
+		})
+
+		_, err := deployment(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spicedb.podAnnotations")
+	})
+}
+
+func TestDeployment_CABundle(t *testing.T) {
+	t.Run("not mounted by default", func(t *testing.T) {
+		dpl := spicedbDeployment(t, renderContextWithSpiceDBEnabled(t))
+		for _, v := range dpl.Spec.Template.Spec.Volumes {
+			require.NotEqual(t, "ca-bundle", v.Name)
+		}
+	})
+
+	t.Run("mounted when configured", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:           true,
+			SecretRef:         "spicedb-secret",
+			CABundleSecretRef: "private-ca",
+		})
+
+		dpl := spicedbDeployment(t, ctx)
+		require.Contains(t, dpl.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name: "ca-bundle",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: "private-ca",
+					Items:      []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+				},
+			},
+		})
+
+		container := spicedbContainer(t, ctx)
+		require.Contains(t, container.VolumeMounts, corev1.VolumeMount{
+			Name:      "ca-bundle",
+			MountPath: "/etc/spicedb/ca-bundle",
+			ReadOnly:  true,
+		})
+		require.Contains(t, container.Env, corev1.EnvVar{Name: "SSL_CERT_FILE", Value: "/etc/spicedb/ca-bundle/ca.crt"})
+	})
+}
+
+func TestDeployment_DispatchConcurrencyLimit(t *testing.T) {
+	t.Run("not rendered by default", func(t *testing.T) {
+		container := spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+		for _, arg := range container.Args {
+			require.NotContains(t, arg, "--dispatch-concurrency-limit")
+		}
+	})
+
+	t.Run("rendered from config", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:                  true,
+			SecretRef:                "spicedb-secret",
+			DispatchConcurrencyLimit: pointer.Int(25),
+		})
+
+		container := spicedbContainer(t, ctx)
+		require.Contains(t, container.Args, "--dispatch-concurrency-limit=25")
+	})
+}
+
+func TestDeployment_ReadOnly(t *testing.T) {
+	t.Run("writable by default", func(t *testing.T) {
+		container := spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+		require.NotContains(t, container.Args, "--datastore-readonly=true")
+		require.Contains(t, container.Args, "--datastore-bootstrap-overwrite=true")
+	})
+
+	t.Run("read-only when set", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			ReadOnly:  true,
+		})
+
+		container := spicedbContainer(t, ctx)
+		require.Contains(t, container.Args, "--datastore-readonly=true")
+		require.NotContains(t, container.Args, "--datastore-bootstrap-overwrite=true")
+	})
+}
+
+func TestDeployment_DatastoreGC(t *testing.T) {
+	window := util.Duration(2 * time.Hour)
+	interval := util.Duration(time.Minute)
+	maxOperationTime := util.Duration(30 * time.Second)
+
+	t.Run("not rendered by default", func(t *testing.T) {
+		container := spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+		for _, arg := range container.Args {
+			require.NotContains(t, arg, "--datastore-gc-")
+		}
+	})
+
+	t.Run("rendered from config", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				GCWindow:           &window,
+				GCInterval:         &interval,
+				GCMaxOperationTime: &maxOperationTime,
+			},
+		})
+
+		container := spicedbContainer(t, ctx)
+		require.Contains(t, container.Args, "--datastore-gc-window=2h0m0s")
+		require.Contains(t, container.Args, "--datastore-gc-interval=1m0s")
+		require.Contains(t, container.Args, "--datastore-gc-max-operation-time=30s")
+	})
+
+	t.Run("rejected for engines without gc", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				Engine:   experimental.SpiceDBDatastoreEngineCockroachDB,
+				GCWindow: &window,
+			},
+		})
+
+		_, err := deployment(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "garbage collection")
+	})
+}
+
+func TestDeployment_HealthProbeBinary(t *testing.T) {
+	healthProbeInstaller := func(dpl *appsv1.Deployment) *corev1.Container {
+		for i, c := range dpl.Spec.Template.Spec.InitContainers {
+			if c.Name == "grpc-health-probe-installer" {
+				return &dpl.Spec.Template.Spec.InitContainers[i]
+			}
+		}
+		return nil
+	}
+
+	t.Run("uses the binary of the SpiceDB image by default", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBEnabled(t)
+		dpl := spicedbDeployment(t, ctx)
+		require.Nil(t, healthProbeInstaller(dpl), "health probe installer must not be rendered by default")
+		for _, v := range dpl.Spec.Template.Spec.Volumes {
+			require.NotEqual(t, "grpc-health-probe", v.Name)
+		}
+
+		container := spicedbContainer(t, ctx)
+		require.Equal(t, "grpc_health_probe", container.ReadinessProbe.Exec.Command[0])
+		for _, m := range container.VolumeMounts {
+			require.NotEqual(t, "grpc-health-probe", m.Name)
+		}
+	})
+
+	t.Run("defaults the installer to the debug image", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:     true,
+			SecretRef:   "spicedb-secret",
+			HealthProbe: &experimental.SpiceDBHealthProbeConfig{},
+		})
+		installer := healthProbeInstaller(spicedbDeployment(t, ctx))
+		require.NotNil(t, installer, "health probe installer init container must be rendered")
+		require.Contains(t, installer.Image, "authzed/spicedb:"+HealthProbeImageTag)
+		require.Equal(t, []string{"cp", HealthProbeBinaryPath, "/grpc-health-probe/grpc_health_probe"}, installer.Command)
+
+		container := spicedbContainer(t, ctx)
+		require.Equal(t, "/grpc-health-probe/grpc_health_probe", container.ReadinessProbe.Exec.Command[0])
+		require.Contains(t, container.VolumeMounts, corev1.VolumeMount{Name: "grpc-health-probe", MountPath: "/grpc-health-probe", ReadOnly: true})
+	})
+
+	t.Run("uses the configured image", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			HealthProbe: &experimental.SpiceDBHealthProbeConfig{
+				Image:      "registry.example.com/grpc-health-probe:v0.4.19",
+				BinaryPath: "/bin/grpc_health_probe",
+			},
+		})
+		installer := healthProbeInstaller(spicedbDeployment(t, ctx))
+		require.NotNil(t, installer)
+		require.Equal(t, "registry.example.com/grpc-health-probe:v0.4.19", installer.Image)
+		require.Equal(t, []string{"cp", "/bin/grpc_health_probe", "/grpc-health-probe/grpc_health_probe"}, installer.Command)
+	})
+}
+
+func TestDeployment_Relationships(t *testing.T) {
+	t.Run("not rendered by default", func(t *testing.T) {
+		container := spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+		for _, arg := range container.Args {
+			require.NotContains(t, arg, "relationship-expiration")
+			require.NotContains(t, arg, "relationship-integrity")
+		}
+	})
+
+	enabled := &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Datastore: &experimental.SpiceDBDatastoreConfig{
+			Engine: experimental.SpiceDBDatastoreEngineCockroachDB,
+		},
+		Relationships: &experimental.SpiceDBRelationshipsConfig{
+			ExpirationEnabled: true,
+			Integrity: &experimental.SpiceDBRelationshipIntegrityConfig{
+				Enabled:      true,
+				KeySecretRef: "spicedb-integrity",
+				KeyID:        "key-1",
+			},
+		},
+	}
+
+	t.Run("rejected by the pinned image", func(t *testing.T) {
+		_, err := deployment(renderContextWithSpiceDBConfig(t, enabled))
+		require.ErrorContains(t, err, "spicedb.relationships is not supported by the pinned SpiceDB image")
+
+		_, err = deployment(renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:       true,
+			SecretRef:     "spicedb-secret",
+			Relationships: &experimental.SpiceDBRelationshipsConfig{ExpirationEnabled: true},
+		}))
+		require.ErrorContains(t, err, "spicedb.relationships is not supported by the pinned SpiceDB image")
+	})
+
+	t.Run("flags and key volume", func(t *testing.T) {
+		args := relationshipArgs(enabled)
+		require.Contains(t, args, "--enable-experimental-relationship-expiration=true")
+		require.Contains(t, args, "--datastore-relationship-integrity-enabled=true")
+		require.Contains(t, args, "--datastore-relationship-integrity-current-key-id=key-1")
+		require.Contains(t, args, "--datastore-relationship-integrity-current-key-filename=/etc/spicedb/relationship-integrity/integrityKey")
+		require.Contains(t, relationshipIntegrityVolumeMounts(enabled), corev1.VolumeMount{Name: "relationship-integrity-key", MountPath: "/etc/spicedb/relationship-integrity", ReadOnly: true})
+
+		volumes := relationshipIntegrityVolumes(enabled)
+		require.Len(t, volumes, 1)
+		require.Equal(t, "spicedb-integrity", volumes[0].Secret.SecretName)
+	})
+
+	t.Run("integrity rejected for unsupported engines", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Relationships: &experimental.SpiceDBRelationshipsConfig{
+				Integrity: &experimental.SpiceDBRelationshipIntegrityConfig{
+					Enabled:      true,
+					KeySecretRef: "spicedb-integrity",
+					KeyID:        "key-1",
+				},
+			},
+		})
+
+		_, err := deployment(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "relationships.integrity")
+	})
+
+	t.Run("integrity requires a key", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				Engine: experimental.SpiceDBDatastoreEngineCockroachDB,
+			},
+			Relationships: &experimental.SpiceDBRelationshipsConfig{
+				Integrity: &experimental.SpiceDBRelationshipIntegrityConfig{Enabled: true},
+			},
+		})
+
+		_, err := deployment(ctx)
+		require.Error(t, err)
+	})
+}
+
+func TestDeployment_MaxDepth(t *testing.T) {
+	container := spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+	require.Contains(t, container.Args, "--dispatch-max-depth=50")
+
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		MaxDepth:  pointer.Int(100),
+	})
+	container = spicedbContainer(t, ctx)
+	require.Contains(t, container.Args, "--dispatch-max-depth=100")
+}
+
+func TestDeployment_DispatchDrain(t *testing.T) {
+	t.Run("not rendered for a single replica", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBEnabled(t)
+		for _, arg := range spicedbContainer(t, ctx).Args {
+			require.NotContains(t, arg, "--grpc-shutdown-grace-period")
+		}
+		require.Equal(t, int64(30), *spicedbDeployment(t, ctx).Spec.Template.Spec.TerminationGracePeriodSeconds)
+	})
+
+	clustered := func(t *testing.T, cfg *experimental.SpiceDBConfig) *common.RenderContext {
+		ctx := renderContextWithSpiceDBConfig(t, cfg)
+		ctx.Config.Components = &config.Components{
+			PodConfig: map[string]*config.PodConfig{Component: {Replicas: pointer.Int32(3)}},
+		}
+		return ctx
+	}
+
+	t.Run("default drain when clustered", func(t *testing.T) {
+		ctx := clustered(t, &experimental.SpiceDBConfig{Enabled: true, SecretRef: "spicedb-secret"})
+		require.Contains(t, spicedbContainer(t, ctx).Args, "--grpc-shutdown-grace-period=5s")
+		require.Equal(t, int64(35), *spicedbDeployment(t, ctx).Spec.Template.Spec.TerminationGracePeriodSeconds)
+	})
+
+	t.Run("drain from config", func(t *testing.T) {
+		drain := util.Duration(20 * time.Second)
+		ctx := clustered(t, &experimental.SpiceDBConfig{Enabled: true, SecretRef: "spicedb-secret", DispatchDrainPeriod: &drain})
+		require.Contains(t, spicedbContainer(t, ctx).Args, "--grpc-shutdown-grace-period=20s")
+		require.Equal(t, int64(50), *spicedbDeployment(t, ctx).Spec.Template.Spec.TerminationGracePeriodSeconds)
+	})
+}
+
+func TestDeployment_PresharedKeys(t *testing.T) {
+	presharedKeyEnv := func(name, key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "spicedb-secret"},
+					Key:                  key,
+				},
+			},
+		}
+	}
+
+	t.Run("single key by default", func(t *testing.T) {
+		container := spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+		require.Contains(t, container.Env, presharedKeyEnv("SPICEDB_GRPC_PRESHARED_KEY", SecretPresharedKeyName))
+		for _, arg := range container.Args {
+			require.NotContains(t, arg, "--grpc-preshared-key")
+		}
+	})
+
+	t.Run("multiple keys render repeated flags", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:       true,
+			SecretRef:     "spicedb-secret",
+			PresharedKeys: []string{"current", "previous"},
+		})
+
+		container := spicedbContainer(t, ctx)
+		require.Contains(t, container.Env, presharedKeyEnv("SPICEDB_GRPC_PRESHARED_KEY_0", "current"))
+		require.Contains(t, container.Env, presharedKeyEnv("SPICEDB_GRPC_PRESHARED_KEY_1", "previous"))
+		require.Contains(t, container.Args, "--grpc-preshared-key=$(SPICEDB_GRPC_PRESHARED_KEY_0)")
+		require.Contains(t, container.Args, "--grpc-preshared-key=$(SPICEDB_GRPC_PRESHARED_KEY_1)")
+		for _, env := range container.Env {
+			require.NotEqual(t, "SPICEDB_GRPC_PRESHARED_KEY", env.Name)
+		}
+
+		require.Contains(t, Env(ctx), presharedKeyEnv("SPICEDB_PRESHARED_KEY", "current"), "clients must use the first key")
+	})
+
+	for name, keys := range map[string][]string{
+		"no keys":    {},
+		"empty key":  {"current", ""},
+		"duplicates": {"current", "current"},
+	} {
+		t.Run("rejects "+name, func(t *testing.T) {
+			_, err := deployment(renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+				Enabled:       true,
+				SecretRef:     "spicedb-secret",
+				PresharedKeys: keys,
+			}))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDeployment_EnableSchemaReflection(t *testing.T) {
+	container := spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+	require.NotContains(t, container.Args, "--enable-experimental-schema-reflection=true")
+
+	t.Run("rejected by the pinned image", func(t *testing.T) {
+		_, err := deployment(renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:                true,
+			SecretRef:              "spicedb-secret",
+			EnableSchemaReflection: true,
+		}))
+		require.ErrorContains(t, err, "spicedb.enableSchemaReflection")
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		args := serverArgs(renderContextWithSpiceDBEnabled(t), &experimental.SpiceDBConfig{
+			Enabled:                true,
+			SecretRef:              "spicedb-secret",
+			EnableSchemaReflection: true,
+		}, 1, nil)
+		require.Contains(t, args, "--enable-experimental-schema-reflection=true")
+	})
+}
+
+func TestDeployment_SecondaryDispatch(t *testing.T) {
+	withReplicas := func(t *testing.T, replicas int32, secondary *experimental.SpiceDBSecondaryDispatchConfig) *common.RenderContext {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:           true,
+			SecretRef:         "spicedb-secret",
+			SecondaryDispatch: secondary,
+		})
+		ctx.Config.Components = &config.Components{
+			PodConfig: map[string]*config.PodConfig{Component: {Replicas: pointer.Int32(replicas)}},
+		}
+		return ctx
+	}
+
+	enabled := func() *experimental.SpiceDBSecondaryDispatchConfig {
+		return &experimental.SpiceDBSecondaryDispatchConfig{
+			Enabled: true,
+			Upstreams: []experimental.SpiceDBSecondaryDispatchUpstream{
+				{Name: "us-east", Address: "spicedb.us-east.example.com:50053"},
+				{Name: "eu-west", Address: "spicedb.eu-west.example.com:50053"},
+			},
+			Exprs: map[string]string{
+				"lookupresources": "['eu-west']",
+				"check":           "['us-east', 'eu-west']",
+			},
+		}
+	}
+
+	t.Run("not rendered by default", func(t *testing.T) {
+		for _, arg := range spicedbContainer(t, withReplicas(t, 3, nil)).Args {
+			require.NotContains(t, arg, "--dispatch-secondary-")
+		}
+	})
+
+	t.Run("not rendered when disabled", func(t *testing.T) {
+		secondary := enabled()
+		secondary.Enabled = false
+
+		for _, arg := range spicedbContainer(t, withReplicas(t, 3, secondary)).Args {
+			require.NotContains(t, arg, "--dispatch-secondary-")
+		}
+	})
+
+	t.Run("rejected by the pinned image", func(t *testing.T) {
+		_, err := deployment(withReplicas(t, 3, enabled()))
+		require.ErrorContains(t, err, "spicedb.secondaryDispatch is not supported by the pinned SpiceDB image")
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		args := secondaryDispatchArgs(&experimental.SpiceDBConfig{SecondaryDispatch: enabled()})
+		require.Contains(t, args, "--dispatch-secondary-upstream-addrs=us-east=spicedb.us-east.example.com:50053")
+		require.Contains(t, args, "--dispatch-secondary-upstream-addrs=eu-west=spicedb.eu-west.example.com:50053")
+		require.Contains(t, args, `--dispatch-secondary-upstream-exprs="check=['us-east', 'eu-west']"`)
+		require.Contains(t, args, `--dispatch-secondary-upstream-exprs="lookupresources=['eu-west']"`)
+	})
+
+	t.Run("requires clustered mode", func(t *testing.T) {
+		_, err := deployment(withReplicas(t, 1, enabled()))
+		require.ErrorContains(t, err, "spicedb.secondaryDispatch requires clustered mode")
+	})
+
+	t.Run("requires an upstream", func(t *testing.T) {
+		secondary := enabled()
+		secondary.Upstreams = nil
+
+		_, err := deployment(withReplicas(t, 3, secondary))
+		require.ErrorContains(t, err, "spicedb.secondaryDispatch requires at least one upstream")
+	})
+
+	t.Run("rejects duplicate upstream names", func(t *testing.T) {
+		secondary := enabled()
+		secondary.Upstreams[1].Name = "us-east"
+
+		_, err := deployment(withReplicas(t, 3, secondary))
+		require.ErrorContains(t, err, `name "us-east" is not unique`)
+	})
+}
+
+func TestDeployment_DispatchMembershipProbe(t *testing.T) {
+	withReplicas := func(t *testing.T, replicas int32) *common.RenderContext {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:                 true,
+			SecretRef:               "spicedb-secret",
+			DispatchMembershipProbe: true,
+		})
+		ctx.Config.Components = &config.Components{
+			PodConfig: map[string]*config.PodConfig{Component: {Replicas: pointer.Int32(replicas)}},
+		}
+		return ctx
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		require.Nil(t, spicedbContainer(t, renderContextWithSpiceDBEnabled(t)).LivenessProbe)
+	})
+
+	t.Run("not rendered for a single replica", func(t *testing.T) {
+		require.Nil(t, spicedbContainer(t, withReplicas(t, 1)).LivenessProbe)
+	})
+
+	t.Run("checks the dispatch server of the pod when clustered", func(t *testing.T) {
+		container := spicedbContainer(t, withReplicas(t, 3))
+		require.NotNil(t, container.LivenessProbe)
+		require.Equal(t, []string{
+			"grpc_health_probe",
+			"-v",
+			"-addr=localhost:50053",
+		}, container.LivenessProbe.Exec.Command)
+
+		// readiness keeps checking the local API only
+		require.Contains(t, container.ReadinessProbe.Exec.Command, "-addr=localhost:50051")
+	})
+
+	t.Run("passes while all pods are unready", func(t *testing.T) {
+		// unready pods are removed from the endpoints of the Service, a probe through the Service would fail on every
+		// pod at once, neither probe may depend on it
+		container := spicedbContainer(t, withReplicas(t, 3))
+		for _, probe := range []*corev1.Probe{container.LivenessProbe, container.ReadinessProbe} {
+			for _, arg := range probe.Exec.Command {
+				require.NotContains(t, arg, "svc.cluster.local")
+				require.NotContains(t, arg, fmt.Sprintf("-addr=%s:", Component))
+			}
+		}
+	})
+}
+
+func TestDeployment_ExtraArgs(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		ExtraArgs: []string{"--datastore-prometheus-metrics=false", "--log-format=json"},
+	})
+	_, err := deployment(ctx)
+	require.ErrorContains(t, err, "spicedb.extraArgs must not set --log-format")
+
+	ctx = renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		ExtraArgs: []string{"--datastore-prometheus-metrics=false", "--schema-prefixes-required", "true"},
+	})
+	args := spicedbContainer(t, ctx).Args
+	require.Equal(t, []string{"--datastore-prometheus-metrics=false", "--schema-prefixes-required", "true"}, args[len(args)-3:])
+}
+
+func TestDeployment_DisableV0API(t *testing.T) {
+	container := spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+	require.Contains(t, container.Args, "--disable-v0-api=true", "the v0 API must be disabled by default")
+
+	for _, disabled := range []bool{true, false} {
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:      true,
+			SecretRef:    "spicedb-secret",
+			DisableV0API: pointer.Bool(disabled),
+		})
+		container := spicedbContainer(t, ctx)
+		require.Contains(t, container.Args, fmt.Sprintf("--disable-v0-api=%t", disabled))
+	}
+}
+
+func TestDeployment_GRPCMaxConcurrentStreams(t *testing.T) {
+	for _, arg := range spicedbContainer(t, renderContextWithSpiceDBEnabled(t)).Args {
+		require.NotContains(t, arg, "--grpc-max-concurrent-streams")
+	}
+
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:                  true,
+		SecretRef:                "spicedb-secret",
+		GRPCMaxConcurrentStreams: pointer.Int(1000),
+	})
+	require.Contains(t, spicedbContainer(t, ctx).Args, "--grpc-max-concurrent-streams=1000")
+}
+
+func TestDeployment_SchemaReadOnly(t *testing.T) {
+	require.NotContains(t, spicedbContainer(t, renderContextWithSpiceDBEnabled(t)).Args, "--disable-v1-schema-api=true")
+
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:        true,
+		SecretRef:      "spicedb-secret",
+		SchemaReadOnly: true,
+	})
+	container := spicedbContainer(t, ctx)
+	require.Contains(t, container.Args, "--disable-v1-schema-api=true")
+	require.Contains(t, container.Args, "--datastore-bootstrap-overwrite=true", "the installer keeps writing the schema")
+
+	_, err := deployment(renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:              true,
+		SecretRef:            "spicedb-secret",
+		SchemaReadOnly:       true,
+		RequireSchemaVersion: "3",
+	}))
+	require.Error(t, err)
+}
+
+func TestDeployment_GeneratePassword(t *testing.T) {
+	generatePasswordConfig := func() *experimental.SpiceDBConfig {
+		return &experimental.SpiceDBConfig{
+			Enabled:   true,
+			SecretRef: "spicedb-secret",
+			Datastore: &experimental.SpiceDBDatastoreConfig{
+				GeneratePassword: true,
+			},
+		}
+	}
+
+	renderedPassword := func(t *testing.T, ctx *common.RenderContext) string {
+		t.Helper()
+
+		objects, err := datastorePasswordSecret(ctx)
+		require.NoError(t, err)
+		require.Len(t, objects, 1)
+
+		secret, ok := objects[0].(*corev1.Secret)
+		require.True(t, ok)
+		require.Equal(t, DatastoreSecretName, secret.Name)
+
+		return string(secret.Data[SecretDatastorePasswordKeyName])
+	}
+
+	t.Run("not rendered by default", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBEnabled(t)
+
+		objects, err := datastorePasswordSecret(ctx)
+		require.NoError(t, err)
+		require.Empty(t, objects)
+
+		container := spicedbContainer(t, ctx)
+		require.Contains(t, container.Env, corev1.EnvVar{
+			Name:  "SPICEDB_DATASTORE_CONN_URI",
+			Value: "$(DB_USERNAME):$(DB_PASSWORD)@tcp($(DB_HOST):$(DB_PORT))/authorization?parseTime=true",
+		})
+	})
+
+	t.Run("generates a password", func(t *testing.T) {
+		first := renderedPassword(t, renderContextWithSpiceDBConfig(t, generatePasswordConfig()))
+		second := renderedPassword(t, renderContextWithSpiceDBConfig(t, generatePasswordConfig()))
+		require.NotEmpty(t, first)
+		require.NotEqual(t, first, second)
+	})
+
+	t.Run("reuses an existing password", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, generatePasswordConfig())
+		ctx.Values.SpiceDBDatastorePassword = "existing-password"
+
+		require.Equal(t, "existing-password", renderedPassword(t, ctx))
+		require.Equal(t, "existing-password", renderedPassword(t, ctx))
+	})
+
+	t.Run("referenced by server and migrations", func(t *testing.T) {
+		ctx := renderContextWithSpiceDBConfig(t, generatePasswordConfig())
+
+		passwordEnv := corev1.EnvVar{
+			Name: "SPICEDB_DATASTORE_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: DatastoreSecretName},
+					Key:                  SecretDatastorePasswordKeyName,
+				},
+			},
+		}
+		connURIEnv := corev1.EnvVar{
+			Name:  "SPICEDB_DATASTORE_CONN_URI",
+			Value: "$(DB_USERNAME):$(SPICEDB_DATASTORE_PASSWORD)@tcp($(DB_HOST):$(DB_PORT))/authorization?parseTime=true",
+		}
+
+		for _, env := range [][]corev1.EnvVar{
+			spicedbContainer(t, ctx).Env,
+			migrationJob(t, ctx).Spec.Template.Spec.Containers[0].Env,
+		} {
+			require.Contains(t, env, passwordEnv)
+			require.Contains(t, env, connURIEnv)
+		}
+	})
+
+	t.Run("reuses a configured secret", func(t *testing.T) {
+		cfg := generatePasswordConfig()
+		cfg.Datastore.PasswordSecretRef = "existing-datastore-secret"
+		ctx := renderContextWithSpiceDBConfig(t, cfg)
+
+		objects, err := datastorePasswordSecret(ctx)
+		require.NoError(t, err)
+		require.Empty(t, objects, "no secret must be generated when one is configured")
+
+		passwordEnv := corev1.EnvVar{
+			Name: "SPICEDB_DATASTORE_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "existing-datastore-secret"},
+					Key:                  SecretDatastorePasswordKeyName,
+				},
+			},
+		}
+		for _, env := range [][]corev1.EnvVar{
+			spicedbContainer(t, ctx).Env,
+			migrationJob(t, ctx).Spec.Template.Spec.Containers[0].Env,
+		} {
+			require.Contains(t, env, passwordEnv)
+			require.Contains(t, env, corev1.EnvVar{
+				Name:  "SPICEDB_DATASTORE_CONN_URI",
+				Value: "$(DB_USERNAME):$(SPICEDB_DATASTORE_PASSWORD)@tcp($(DB_HOST):$(DB_PORT))/authorization?parseTime=true",
+			})
+		}
+
+		// the secret is also used without generatePassword
+		cfg.Datastore.GeneratePassword = false
+		require.Contains(t, spicedbContainer(t, renderContextWithSpiceDBConfig(t, cfg)).Env, passwordEnv)
+	})
+
+	t.Run("rejected for the memory engine", func(t *testing.T) {
+		cfg := generatePasswordConfig()
+		cfg.Datastore.Engine = experimental.SpiceDBDatastoreEngineMemory
+
+		_, err := deployment(renderContextWithSpiceDBConfig(t, cfg))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spicedb.datastore.generatePassword")
+	})
+}
+
+func TestDeployment_ReadinessInitialDelay(t *testing.T) {
+	initialDelay := func(t *testing.T, datastore *experimental.SpiceDBDatastoreConfig, delay *util.Duration) int32 {
+		t.Helper()
+
+		ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+			Enabled:               true,
+			SecretRef:             "spicedb-secret",
+			Datastore:             datastore,
+			ReadinessInitialDelay: delay,
+		})
+
+		return spicedbContainer(t, ctx).ReadinessProbe.InitialDelaySeconds
+	}
+
+	memory := &experimental.SpiceDBDatastoreConfig{Engine: experimental.SpiceDBDatastoreEngineMemory}
+	postgres := &experimental.SpiceDBDatastoreConfig{Engine: experimental.SpiceDBDatastoreEnginePostgres}
+
+	require.EqualValues(t, 1, initialDelay(t, memory, nil))
+	require.EqualValues(t, 10, initialDelay(t, postgres, nil))
+	require.EqualValues(t, 10, initialDelay(t, nil, nil), "the default mysql engine connects to a datastore")
+
+	delay := util.Duration(30 * time.Second)
+	require.EqualValues(t, 30, initialDelay(t, memory, &delay))
+	require.EqualValues(t, 30, initialDelay(t, postgres, &delay))
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gitpod-io/gitpod/installer/pkg/common"
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func ingressEnabled(cfg *experimental.SpiceDBConfig) bool {
+	return cfg.Ingress != nil && cfg.Ingress.Enabled
+}
+
+// ingress renders an Ingress per exposed API. The APIs are served on separate hosts, as gRPC usually needs a
+// different backend protocol configuration of the ingress controller than the HTTP gateway.
+func ingress(ctx *common.RenderContext) ([]runtime.Object, error) {
+	cfg := getExperimentalSpiceDBConfig(ctx)
+	if cfg == nil || !ingressEnabled(cfg) {
+		return nil, nil
+	}
+
+	if cfg.Ingress.HTTP == nil && cfg.Ingress.GRPC == nil {
+		return nil, errors.New("spicedb.ingress requires http or grpc to be configured")
+	}
+	if cfg.Ingress.HTTP != nil && !cfg.HTTPEnabled {
+		return nil, errors.New("spicedb.ingress.http requires spicedb.httpEnabled")
+	}
+
+	var objects []runtime.Object
+	if rule := cfg.Ingress.HTTP; rule != nil {
+		obj, err := ingressForRule(ctx, cfg, fmt.Sprintf("%s-http", Component), "spicedb.ingress.http", rule, httpPort(cfg))
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	if rule := cfg.Ingress.GRPC; rule != nil {
+		obj, err := ingressForRule(ctx, cfg, fmt.Sprintf("%s-grpc", Component), "spicedb.ingress.grpc", rule, ContainerGRPCPort)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+
+	return objects, nil
+}
+
+func ingressForRule(ctx *common.RenderContext, cfg *experimental.SpiceDBConfig, name, field string, rule *experimental.SpiceDBIngressRule, port int32) (runtime.Object, error) {
+	if rule.Host == "" {
+		return nil, fmt.Errorf("%s.host is required", field)
+	}
+	if err := validateAnnotations(field+".annotations", rule.Annotations); err != nil {
+		return nil, err
+	}
+
+	tlsSecret := cfg.Ingress.TLSSecretRef
+	if tlsSecret == "" {
+		tlsSecret = ctx.Config.Certificate.Name
+	}
+
+	var className *string
+	if cfg.Ingress.ClassName != "" {
+		className = &cfg.Ingress.ClassName
+	}
+
+	pathType := networkingv1.PathTypePrefix
+
+	return &networkingv1.Ingress{
+		TypeMeta: common.TypeMetaIngress,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ctx.Namespace,
+			Labels:    common.CustomizeLabel(ctx, Component, common.TypeMetaIngress),
+			Annotations: common.CustomizeAnnotation(ctx, Component, common.TypeMetaIngress, func() map[string]string {
+				return rule.Annotations
+			}),
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: className,
+			TLS: []networkingv1.IngressTLS{
+				{
+					Hosts:      []string{rule.Host},
+					SecretName: tlsSecret,
+				},
+			},
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: rule.Host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     "/",
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: Component,
+											Port: networkingv1.ServiceBackendPort{Number: port},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
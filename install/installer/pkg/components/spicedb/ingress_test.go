@@ -0,0 +1,99 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	networkingv1 "k8s.io/api/networking/v1"
+
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
+)
+
+func TestIngress_NotRenderedByDefault(t *testing.T) {
+	objects, err := ingress(renderContextWithSpiceDBEnabled(t))
+	require.NoError(t, err)
+	require.Empty(t, objects)
+}
+
+func TestIngress_RenderedFromConfig(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:     true,
+		SecretRef:   "spicedb-secret",
+		HTTPEnabled: true,
+		Ingress: &experimental.SpiceDBIngressConfig{
+			Enabled:      true,
+			ClassName:    "nginx",
+			TLSSecretRef: "spicedb-tls",
+			HTTP:         &experimental.SpiceDBIngressRule{Host: "spicedb-http.example.com"},
+			GRPC: &experimental.SpiceDBIngressRule{
+				Host:        "spicedb-grpc.example.com",
+				Annotations: map[string]string{"nginx.ingress.kubernetes.io/backend-protocol": "GRPC"},
+			},
+		},
+	})
+
+	objects, err := ingress(ctx)
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+
+	for _, expected := range []struct {
+		Name string
+		Host string
+		Port int32
+	}{
+		{Name: "spicedb-http", Host: "spicedb-http.example.com", Port: ContainerHTTPPort},
+		{Name: "spicedb-grpc", Host: "spicedb-grpc.example.com", Port: ContainerGRPCPort},
+	} {
+		var ing *networkingv1.Ingress
+		for _, obj := range objects {
+			if i := obj.(*networkingv1.Ingress); i.Name == expected.Name {
+				ing = i
+			}
+		}
+		require.NotNil(t, ing, "ingress %s must be rendered", expected.Name)
+
+		require.Equal(t, "nginx", *ing.Spec.IngressClassName)
+		require.Equal(t, []networkingv1.IngressTLS{{Hosts: []string{expected.Host}, SecretName: "spicedb-tls"}}, ing.Spec.TLS)
+		require.Len(t, ing.Spec.Rules, 1)
+		require.Equal(t, expected.Host, ing.Spec.Rules[0].Host)
+
+		backend := ing.Spec.Rules[0].HTTP.Paths[0].Backend.Service
+		require.Equal(t, Component, backend.Name)
+		require.Equal(t, expected.Port, backend.Port.Number)
+	}
+}
+
+func TestIngress_DefaultsToInstallationCertificate(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Ingress: &experimental.SpiceDBIngressConfig{
+			Enabled: true,
+			GRPC:    &experimental.SpiceDBIngressRule{Host: "spicedb.example.com"},
+		},
+	})
+	ctx.Config.Certificate.Name = "https-certificates"
+
+	objects, err := ingress(ctx)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	require.Equal(t, "https-certificates", objects[0].(*networkingv1.Ingress).Spec.TLS[0].SecretName)
+}
+
+func TestIngress_HTTPRequiresGateway(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Ingress: &experimental.SpiceDBIngressConfig{
+			Enabled: true,
+			HTTP:    &experimental.SpiceDBIngressRule{Host: "spicedb.example.com"},
+		},
+	})
+
+	_, err := ingress(ctx)
+	require.Error(t, err)
+}
@@ -5,12 +5,18 @@
 package spicedb
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"time"
 
+	"github.com/gitpod-io/gitpod/common-go/log"
 	"github.com/gitpod-io/gitpod/installer/pkg/common"
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/utils/pointer"
@@ -24,11 +30,39 @@ func migrations(ctx *common.RenderContext) ([]runtime.Object, error) {
 	}
 
 	if cfg.DisableMigrations {
+		log.Warn("spicedb.disableMigrations is set, no migration Job is rendered: migrate the spicedb datastore before rolling out")
 		return nil, nil
 	}
 
+	files, err := getBootstrapFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bootstrap files: %w", err)
+	}
+
+	backoffLimit, activeDeadlineSeconds, timeout := migrationLimits(cfg)
+	if timeout >= time.Duration(activeDeadlineSeconds)*time.Second {
+		return nil, fmt.Errorf("spicedb.migration.timeout (%s) must be shorter than activeDeadlineSeconds (%d)", timeout, activeDeadlineSeconds)
+	}
+
+	args := []string{
+		"migrate",
+		"head",
+		"--log-format=json",
+		"--log-level=debug",
+		fmt.Sprintf("--datastore-engine=%s", datastoreEngine(cfg)),
+		fmt.Sprintf("--migration-timeout=%s", timeout),
+	}
+
+	var extraArgs []string
+	if cfg.Migration != nil {
+		extraArgs = cfg.Migration.ExtraArgs
+	}
+	if err := validateExtraArgs("spicedb.migration.extraArgs", args, extraArgs); err != nil {
+		return nil, err
+	}
+
 	objectMeta := metav1.ObjectMeta{
-		Name:        fmt.Sprintf("%s-migrations", Component),
+		Name:        migrationJobName(files, datastoreEngine(cfg)),
 		Namespace:   ctx.Namespace,
 		Labels:      common.CustomizeLabel(ctx, Component, common.TypeMetaBatchJob),
 		Annotations: common.CustomizeAnnotation(ctx, Component, common.TypeMetaBatchJob),
@@ -40,6 +74,8 @@ func migrations(ctx *common.RenderContext) ([]runtime.Object, error) {
 			ObjectMeta: objectMeta,
 			Spec: batchv1.JobSpec{
 				TTLSecondsAfterFinished: pointer.Int32(60),
+				BackoffLimit:            pointer.Int32(backoffLimit),
+				ActiveDeadlineSeconds:   pointer.Int64(activeDeadlineSeconds),
 				Template: corev1.PodTemplateSpec{
 					ObjectMeta: objectMeta,
 					Spec: corev1.PodSpec{
@@ -50,23 +86,18 @@ func migrations(ctx *common.RenderContext) ([]runtime.Object, error) {
 							dbWaiter(ctx),
 						},
 						Containers: []corev1.Container{{
-							Name:            fmt.Sprintf("%s-migrations", Component),
+							Name:            MigrationContainerName,
 							Image:           ctx.ImageName(common.ThirdPartyContainerRepo(ctx.Config.Repository, RegistryRepo), RegistryImage, ImageTag),
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Env: common.CustomizeEnvvar(ctx, Component, common.MergeEnv(
 								common.DefaultEnv(&ctx.Config),
 								spicedbEnvVars(ctx),
 							)),
+							Resources: common.ResourceRequirements(ctx, Component, MigrationContainerName, migrationResources(cfg)),
 							SecurityContext: &corev1.SecurityContext{
 								AllowPrivilegeEscalation: pointer.Bool(false),
 							},
-							Args: []string{
-								"migrate",
-								"head",
-								"--log-format=json",
-								"--log-level=debug",
-								"--datastore-engine=mysql",
-							},
+							Args: append(args, extraArgs...),
 						}},
 					},
 				},
@@ -74,3 +105,57 @@ func migrations(ctx *common.RenderContext) ([]runtime.Object, error) {
 		},
 	}, nil
 }
+
+// migrationResources returns the configured resources of the migration container, falling back to modest defaults.
+func migrationResources(cfg *experimental.SpiceDBConfig) corev1.ResourceRequirements {
+	if cfg.Migration != nil && cfg.Migration.Resources != nil {
+		return *cfg.Migration.Resources
+	}
+
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+	}
+}
+
+const (
+	defaultMigrationBackoffLimit          = 6
+	defaultMigrationActiveDeadlineSeconds = 6 * 60 * 60
+	defaultMigrationTimeout               = 4 * time.Hour
+)
+
+// migrationLimits returns the retry and time limits of the migration Job. The defaults are generous, as migrations of
+// large datastores legitimately take long.
+func migrationLimits(cfg *experimental.SpiceDBConfig) (backoffLimit int32, activeDeadlineSeconds int64, timeout time.Duration) {
+	backoffLimit, activeDeadlineSeconds, timeout = defaultMigrationBackoffLimit, defaultMigrationActiveDeadlineSeconds, defaultMigrationTimeout
+
+	if m := cfg.Migration; m != nil {
+		if m.BackoffLimit != nil {
+			backoffLimit = *m.BackoffLimit
+		}
+		if m.ActiveDeadlineSeconds != nil {
+			activeDeadlineSeconds = *m.ActiveDeadlineSeconds
+		}
+		if m.Timeout != nil {
+			timeout = time.Duration(*m.Timeout)
+		}
+	}
+
+	return backoffLimit, activeDeadlineSeconds, timeout
+}
+
+// migrationJobName suffixes the Job name with a hash of the schema and the migration inputs. A Job is immutable once
+// created, a changed schema therefore results in a fresh Job, while re-applying an identical config reuses the existing one.
+func migrationJobName(files []file, engine experimental.SpiceDBDatastoreEngine) string {
+	hash := sha256.New()
+	hash.Write([]byte(ImageTag))
+	hash.Write([]byte(engine))
+	for _, f := range files {
+		hash.Write([]byte(f.name))
+		hash.Write([]byte(f.data))
+	}
+
+	return fmt.Sprintf("%s-migrations-%s", Component, hex.EncodeToString(hash.Sum(nil))[:8])
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/pointer"
+
+	"github.com/gitpod-io/gitpod/common-go/util"
+	"github.com/gitpod-io/gitpod/installer/pkg/common"
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
+)
+
+func TestMigrations_JobNameChangesWithSchema(t *testing.T) {
+	files := []file{{name: "schema.yaml", data: "schema: definition user {}"}}
+	changed := []file{{name: "schema.yaml", data: "schema: definition user {}\ndefinition organization {}"}}
+
+	name := migrationJobName(files, experimental.SpiceDBDatastoreEngineMySQL)
+	require.Equal(t, name, migrationJobName(files, experimental.SpiceDBDatastoreEngineMySQL), "identical input must produce the same name")
+	require.NotEqual(t, name, migrationJobName(changed, experimental.SpiceDBDatastoreEngineMySQL))
+	require.Contains(t, name, "spicedb-migrations-")
+}
+
+func TestMigrations_JobHasTTL(t *testing.T) {
+	job := migrationJob(t, renderContextWithSpiceDBEnabled(t))
+
+	require.NotNil(t, job.Spec.TTLSecondsAfterFinished)
+	require.Greater(t, *job.Spec.TTLSecondsAfterFinished, int32(0))
+}
+
+func TestMigrations_JobUsesOwnResources(t *testing.T) {
+	job := migrationJob(t, renderContextWithSpiceDBEnabled(t))
+	server := spicedbContainer(t, renderContextWithSpiceDBEnabled(t))
+
+	resources := job.Spec.Template.Spec.Containers[0].Resources
+	require.False(t, resources.Requests.Memory().IsZero(), "migration job must have default resources")
+	require.NotEqual(t, server.Resources, resources)
+
+	configured := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+		Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+	}
+	job = migrationJob(t, renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Migration: &experimental.SpiceDBMigrationConfig{Resources: &configured},
+	}))
+	require.Equal(t, configured, job.Spec.Template.Spec.Containers[0].Resources)
+}
+
+func TestMigrations_JobLimits(t *testing.T) {
+	job := migrationJob(t, renderContextWithSpiceDBEnabled(t))
+	require.Equal(t, int32(6), *job.Spec.BackoffLimit)
+	require.Equal(t, int64(6*60*60), *job.Spec.ActiveDeadlineSeconds)
+	require.Contains(t, job.Spec.Template.Spec.Containers[0].Args, "--migration-timeout=4h0m0s")
+
+	timeout := util.Duration(90 * time.Minute)
+	job = migrationJob(t, renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Migration: &experimental.SpiceDBMigrationConfig{
+			BackoffLimit:          pointer.Int32(2),
+			ActiveDeadlineSeconds: pointer.Int64(7200),
+			Timeout:               &timeout,
+		},
+	}))
+	require.Equal(t, int32(2), *job.Spec.BackoffLimit)
+	require.Equal(t, int64(7200), *job.Spec.ActiveDeadlineSeconds)
+	require.Contains(t, job.Spec.Template.Spec.Containers[0].Args, "--migration-timeout=1h30m0s")
+}
+
+func TestMigrations_TimeoutMustBeShorterThanDeadline(t *testing.T) {
+	_, err := migrations(renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Migration: &experimental.SpiceDBMigrationConfig{
+			ActiveDeadlineSeconds: pointer.Int64(60),
+		},
+	}))
+	require.Error(t, err)
+}
+
+func migrationJob(t *testing.T, ctx *common.RenderContext) *batchv1.Job {
+	t.Helper()
+
+	objects, err := migrations(ctx)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+
+	job, ok := objects[0].(*batchv1.Job)
+	require.True(t, ok)
+
+	return job
+}
+
+func TestMigrations_ExtraArgs(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Migration: &experimental.SpiceDBMigrationConfig{ExtraArgs: []string{"--datastore-conn-max-open=5"}},
+	})
+	args := migrationJob(t, ctx).Spec.Template.Spec.Containers[0].Args
+	require.Equal(t, "--datastore-conn-max-open=5", args[len(args)-1])
+
+	ctx = renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Migration: &experimental.SpiceDBMigrationConfig{ExtraArgs: []string{"--migration-timeout=1h"}},
+	})
+	_, err := migrations(ctx)
+	require.ErrorContains(t, err, "spicedb.migration.extraArgs must not set --migration-timeout")
+}
+
+func TestMigrations_Disabled(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:           true,
+		SecretRef:         "spicedb-secret",
+		DisableMigrations: true,
+	})
+
+	objects, err := migrations(ctx)
+	require.NoError(t, err)
+	require.Empty(t, objects)
+
+	objects, err = Objects(ctx)
+	require.NoError(t, err)
+	for _, obj := range objects {
+		_, isJob := obj.(*batchv1.Job)
+		require.False(t, isJob, "no migration Job must be rendered")
+	}
+	require.NotEmpty(t, objects, "the server must still be rendered")
+}
@@ -6,6 +6,7 @@ package spicedb
 
 import (
 	"github.com/gitpod-io/gitpod/installer/pkg/common"
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
 
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -16,6 +17,73 @@ import (
 func networkpolicy(ctx *common.RenderContext) ([]runtime.Object, error) {
 	labels := common.DefaultLabels(Component)
 
+	cfg := getExperimentalSpiceDBConfig(ctx)
+	if cfg == nil {
+		return nil, nil
+	}
+
+	apiPorts := []networkingv1.NetworkPolicyPort{
+		{
+			Protocol: common.TCPProtocol,
+			Port:     &intstr.IntOrString{IntVal: ContainerGRPCPort},
+		},
+	}
+	if cfg.HTTPEnabled {
+		apiPorts = append(apiPorts, networkingv1.NetworkPolicyPort{
+			Protocol: common.TCPProtocol,
+			Port:     &intstr.IntOrString{IntVal: httpPort(cfg)},
+		})
+	}
+
+	ingressRules := []networkingv1.NetworkPolicyIngressRule{
+		{
+			Ports: []networkingv1.NetworkPolicyPort{
+				{
+					Protocol: common.TCPProtocol,
+					Port:     &intstr.IntOrString{IntVal: ContainerDispatchPort},
+				},
+			},
+			From: []networkingv1.NetworkPolicyPeer{
+				{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"component": Component,
+						},
+					},
+				},
+			},
+		},
+		{
+			Ports: apiPorts,
+			From: []networkingv1.NetworkPolicyPeer{
+				{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"component": common.PublicApiComponent,
+						},
+					},
+				},
+			},
+		},
+		{
+			Ports: apiPorts,
+			From: []networkingv1.NetworkPolicyPeer{
+				{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"component": common.ServerComponent,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if ports := ingressPorts(cfg); len(ports) > 0 {
+		// ingress controllers run in namespaces and with labels we don't know, the exposed ports are open to all peers
+		ingressRules = append(ingressRules, networkingv1.NetworkPolicyIngressRule{Ports: ports})
+	}
+
 	return []runtime.Object{
 		&networkingv1.NetworkPolicy{
 			TypeMeta: common.TypeMetaNetworkPolicy,
@@ -26,69 +94,33 @@ func networkpolicy(ctx *common.RenderContext) ([]runtime.Object, error) {
 			},
 			Spec: networkingv1.NetworkPolicySpec{
 				PodSelector: metav1.LabelSelector{MatchLabels: labels},
+				// egress is not restricted, the datastore may be served from another namespace or outside of the cluster
 				PolicyTypes: []networkingv1.PolicyType{"Ingress"},
-				Ingress: []networkingv1.NetworkPolicyIngressRule{
-					{
-						Ports: []networkingv1.NetworkPolicyPort{
-							{
-								Protocol: common.TCPProtocol,
-								Port:     &intstr.IntOrString{IntVal: ContainerDispatchPort},
-							},
-						},
-						From: []networkingv1.NetworkPolicyPeer{
-							{
-								PodSelector: &metav1.LabelSelector{
-									MatchLabels: map[string]string{
-										"component": Component,
-									},
-								},
-							},
-						},
-					},
-					{
-						Ports: []networkingv1.NetworkPolicyPort{
-							{
-								Protocol: common.TCPProtocol,
-								Port:     &intstr.IntOrString{IntVal: ContainerHTTPPort},
-							},
-							{
-								Protocol: common.TCPProtocol,
-								Port:     &intstr.IntOrString{IntVal: ContainerGRPCPort},
-							},
-						},
-						From: []networkingv1.NetworkPolicyPeer{
-							{
-								PodSelector: &metav1.LabelSelector{
-									MatchLabels: map[string]string{
-										"component": common.PublicApiComponent,
-									},
-								},
-							},
-						},
-					},
-					{
-						Ports: []networkingv1.NetworkPolicyPort{
-							{
-								Protocol: common.TCPProtocol,
-								Port:     &intstr.IntOrString{IntVal: ContainerHTTPPort},
-							},
-							{
-								Protocol: common.TCPProtocol,
-								Port:     &intstr.IntOrString{IntVal: ContainerGRPCPort},
-							},
-						},
-						From: []networkingv1.NetworkPolicyPeer{
-							{
-								PodSelector: &metav1.LabelSelector{
-									MatchLabels: map[string]string{
-										"component": common.ServerComponent,
-									},
-								},
-							},
-						},
-					},
-				},
+				Ingress:     ingressRules,
 			},
 		},
 	}, nil
 }
+
+// ingressPorts returns the ports exposed through an Ingress.
+func ingressPorts(cfg *experimental.SpiceDBConfig) []networkingv1.NetworkPolicyPort {
+	if !ingressEnabled(cfg) {
+		return nil
+	}
+
+	var ports []networkingv1.NetworkPolicyPort
+	if cfg.Ingress.GRPC != nil {
+		ports = append(ports, networkingv1.NetworkPolicyPort{
+			Protocol: common.TCPProtocol,
+			Port:     &intstr.IntOrString{IntVal: ContainerGRPCPort},
+		})
+	}
+	if cfg.Ingress.HTTP != nil && cfg.HTTPEnabled {
+		ports = append(ports, networkingv1.NetworkPolicyPort{
+			Protocol: common.TCPProtocol,
+			Port:     &intstr.IntOrString{IntVal: httpPort(cfg)},
+		})
+	}
+
+	return ports
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
+)
+
+func TestNetworkPolicy_CrossNamespaceDatastore(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Datastore: &experimental.SpiceDBDatastoreConfig{
+			Host: "mysql.database.svc.cluster.local",
+			Port: 3306,
+		},
+	})
+
+	container := spicedbContainer(t, ctx)
+	require.Contains(t, container.Env, corev1.EnvVar{
+		Name:  "SPICEDB_DATASTORE_CONN_URI",
+		Value: "$(DB_USERNAME):$(DB_PASSWORD)@tcp(mysql.database.svc.cluster.local:3306)/authorization?parseTime=true",
+	})
+
+	objects, err := networkpolicy(ctx)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+
+	policy := objects[0].(*networkingv1.NetworkPolicy)
+	require.NotContains(t, policy.Spec.PolicyTypes, networkingv1.PolicyTypeEgress, "egress to the datastore namespace must not be restricted")
+	require.Empty(t, policy.Spec.Egress)
+}
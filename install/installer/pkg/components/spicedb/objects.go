@@ -5,9 +5,11 @@
 package spicedb
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/gitpod-io/gitpod/installer/pkg/common"
 	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
@@ -22,11 +24,21 @@ func Objects(ctx *common.RenderContext) ([]runtime.Object, error) {
 		return nil, nil
 	}
 
+	// In external mode, SpiceDB is managed outside of this installation. Other components only consume Env.
+	if isExternal(spiceDBConfig) {
+		if spiceDBConfig.External == nil || spiceDBConfig.External.Address == "" {
+			return nil, errors.New("missing configuration for spicedb.external.address")
+		}
+		return nil, nil
+	}
+
 	return common.CompositeRenderFunc(
 		deployment,
 		service,
-		common.DefaultServiceAccount(Component),
+		ingress,
+		serviceaccount,
 		migrations,
+		datastorePasswordSecret,
 		networkpolicy,
 		bootstrap,
 		role,
@@ -44,16 +56,84 @@ func getExperimentalSpiceDBConfig(ctx *common.RenderContext) *experimental.Spice
 	return webappCfg.SpiceDB
 }
 
+func isExternal(cfg *experimental.SpiceDBConfig) bool {
+	return cfg.Mode == experimental.SpiceDBModeExternal
+}
+
+// httpPort returns the port of the HTTP gateway, falling back to the default when not configured.
+func httpPort(cfg *experimental.SpiceDBConfig) int32 {
+	if cfg.HTTPPort != 0 {
+		return int32(cfg.HTTPPort)
+	}
+
+	return ContainerHTTPPort
+}
+
+const defaultMinTLSVersion = "1.2"
+
+// minTLSVersion returns the configured minimum TLS version, defaulting to TLS 1.2 to keep older clients working.
+func minTLSVersion(cfg *experimental.SpiceDBConfig) string {
+	if cfg.MinTLSVersion != "" {
+		return cfg.MinTLSVersion
+	}
+
+	return defaultMinTLSVersion
+}
+
+const (
+	defaultGRPCKeepaliveTimeout = 20 * time.Second
+
+	// minGRPCKeepaliveTime is the minimum ping interval the gRPC server of SpiceDB tolerates.
+	minGRPCKeepaliveTime = 5 * time.Minute
+)
+
+func grpcKeepaliveTimeout(keepalive *experimental.SpiceDBGRPCKeepaliveConfig) time.Duration {
+	if keepalive.Timeout != nil {
+		return time.Duration(*keepalive.Timeout)
+	}
+
+	return defaultGRPCKeepaliveTimeout
+}
+
+// validateGRPCKeepalive rejects keepalive settings, which the gRPC server of the embedded SpiceDB answers by closing
+// the connection.
+func validateGRPCKeepalive(cfg *experimental.SpiceDBConfig) error {
+	keepalive := cfg.GRPCKeepalive
+	if keepalive == nil {
+		return nil
+	}
+
+	if keepalive.Time != nil && time.Duration(*keepalive.Time) < minGRPCKeepaliveTime {
+		return fmt.Errorf("spicedb.grpcKeepalive.time must be at least %s, got %s", minGRPCKeepaliveTime, time.Duration(*keepalive.Time))
+	}
+	if keepalive.PermitWithoutStream {
+		return errors.New("spicedb.grpcKeepalive.permitWithoutStream is not permitted by spicedb")
+	}
+
+	return nil
+}
+
 func Env(ctx *common.RenderContext) []corev1.EnvVar {
 	cfg := getExperimentalSpiceDBConfig(ctx)
 	if cfg == nil {
 		return nil
 	}
 
-	return []corev1.EnvVar{
+	address := net.JoinHostPort(fmt.Sprintf("%s.%s.svc.cluster.local", Component, ctx.Namespace), strconv.Itoa(ContainerGRPCPort))
+	tls := false
+	if isExternal(cfg) && cfg.External != nil {
+		address = cfg.External.Address
+		tls = cfg.External.TLS
+	}
+
+	envs := []corev1.EnvVar{
 		{
 			Name:  "SPICEDB_ADDRESS",
-			Value: net.JoinHostPort(fmt.Sprintf("%s.%s.svc.cluster.local", Component, ctx.Namespace), strconv.Itoa(ContainerGRPCPort)),
+			Value: address,
+		},
+		{
+			Name:  "SPICEDB_TLS_ENABLED",
+			Value: strconv.FormatBool(tls),
 		},
 		{
 			Name: "SPICEDB_PRESHARED_KEY",
@@ -62,9 +142,44 @@ func Env(ctx *common.RenderContext) []corev1.EnvVar {
 					LocalObjectReference: corev1.LocalObjectReference{
 						Name: cfg.SecretRef,
 					},
-					Key: SecretPresharedKeyName,
+					Key: presharedKeyNames(cfg)[0],
 				},
 			},
 		},
 	}
+
+	if tls {
+		envs = append(envs, corev1.EnvVar{
+			Name:  "SPICEDB_TLS_MIN_VERSION",
+			Value: minTLSVersion(cfg),
+		})
+	}
+
+	if cfg.GRPCMaxMessageSize != nil {
+		envs = append(envs, corev1.EnvVar{
+			Name:  "SPICEDB_GRPC_MAX_MESSAGE_SIZE",
+			Value: strconv.Itoa(*cfg.GRPCMaxMessageSize),
+		})
+	}
+
+	if keepalive := cfg.GRPCKeepalive; keepalive != nil {
+		if keepalive.Time != nil {
+			envs = append(envs, corev1.EnvVar{
+				Name:  "SPICEDB_GRPC_KEEPALIVE_TIME_MS",
+				Value: strconv.FormatInt(time.Duration(*keepalive.Time).Milliseconds(), 10),
+			})
+		}
+		envs = append(envs,
+			corev1.EnvVar{
+				Name:  "SPICEDB_GRPC_KEEPALIVE_TIMEOUT_MS",
+				Value: strconv.FormatInt(grpcKeepaliveTimeout(keepalive).Milliseconds(), 10),
+			},
+			corev1.EnvVar{
+				Name:  "SPICEDB_GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM",
+				Value: strconv.FormatBool(keepalive.PermitWithoutStream),
+			},
+		)
+	}
+
+	return envs
 }
@@ -0,0 +1,119 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gitpod-io/gitpod/installer/pkg/common"
+	config "github.com/gitpod-io/gitpod/installer/pkg/config/v1"
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
+	"github.com/gitpod-io/gitpod/installer/pkg/config/versions"
+)
+
+func TestObjects_NotRenderedByDefault(t *testing.T) {
+	ctx, err := common.NewRenderContext(config.Config{}, versions.Manifest{}, "test-namespace")
+	require.NoError(t, err)
+
+	objects, err := Objects(ctx)
+	require.NoError(t, err)
+	require.Empty(t, objects, "no objects should be rendered with default config")
+}
+
+func renderContextWithSpiceDBConfig(t *testing.T, spicedb *experimental.SpiceDBConfig) *common.RenderContext {
+	ctx, err := common.NewRenderContext(config.Config{
+		Domain: "test.domain.everything.awesome.is",
+		Experimental: &experimental.Config{
+			WebApp: &experimental.WebAppConfig{
+				SpiceDB: spicedb,
+			},
+		},
+		Database: config.Database{
+			CloudSQL: &config.DatabaseCloudSQL{
+				ServiceAccount: config.ObjectRef{
+					Name: "gcp-db-creds-service-account-name",
+				},
+			},
+		},
+	}, versions.Manifest{
+		Components: versions.Components{
+			ServiceWaiter: versions.Versioned{
+				Version: "commit-test-latest",
+			},
+		},
+	}, "test-namespace")
+	require.NoError(t, err)
+
+	return ctx
+}
+
+func renderContextWithSpiceDBEnabled(t *testing.T) *common.RenderContext {
+	return renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+	})
+}
+
+func TestObjects_ExternalMode(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Mode:      experimental.SpiceDBModeExternal,
+		External: &experimental.SpiceDBExternalConfig{
+			Address: "spicedb.example.com:443",
+			TLS:     true,
+		},
+	})
+
+	objects, err := Objects(ctx)
+	require.NoError(t, err)
+	require.Empty(t, objects, "external mode must not render a deployment, nor any other server objects")
+
+	env := Env(ctx)
+	require.Contains(t, env, corev1.EnvVar{Name: "SPICEDB_ADDRESS", Value: "spicedb.example.com:443"})
+	require.Contains(t, env, corev1.EnvVar{Name: "SPICEDB_TLS_ENABLED", Value: "true"})
+	require.Contains(t, env, corev1.EnvVar{
+		Name: "SPICEDB_PRESHARED_KEY",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "spicedb-secret"},
+				Key:                  SecretPresharedKeyName,
+			},
+		},
+	})
+}
+
+func TestObjects_ExternalModeRequiresAddress(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Mode:      experimental.SpiceDBModeExternal,
+	})
+
+	_, err := Objects(ctx)
+	require.Error(t, err)
+}
+
+func TestObjects_MinTLSVersion(t *testing.T) {
+	external := func(tls bool, minTLSVersion string) *experimental.SpiceDBConfig {
+		return &experimental.SpiceDBConfig{
+			Enabled:       true,
+			SecretRef:     "spicedb-secret",
+			Mode:          experimental.SpiceDBModeExternal,
+			External:      &experimental.SpiceDBExternalConfig{Address: "spicedb.example.com:443", TLS: tls},
+			MinTLSVersion: minTLSVersion,
+		}
+	}
+
+	require.Contains(t, Env(renderContextWithSpiceDBConfig(t, external(true, ""))), corev1.EnvVar{Name: "SPICEDB_TLS_MIN_VERSION", Value: "1.2"})
+	require.Contains(t, Env(renderContextWithSpiceDBConfig(t, external(true, "1.3"))), corev1.EnvVar{Name: "SPICEDB_TLS_MIN_VERSION", Value: "1.3"})
+
+	for _, env := range Env(renderContextWithSpiceDBConfig(t, external(false, "1.3"))) {
+		require.NotEqual(t, "SPICEDB_TLS_MIN_VERSION", env.Name, "plaintext connections have no TLS version")
+	}
+}
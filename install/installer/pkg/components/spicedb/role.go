@@ -15,6 +15,23 @@ import (
 func role(ctx *common.RenderContext) ([]runtime.Object, error) {
 	labels := common.DefaultLabels(Component)
 
+	// The kubernetes:/// dispatch resolver watches the endpoints of the spicedb service
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"endpoints", "services"},
+			Verbs: []string{
+				"get",
+				"list",
+				"watch",
+			},
+		},
+	}
+
+	if cfg := getExperimentalSpiceDBConfig(ctx); cfg != nil && len(cfg.RoleRules) > 0 {
+		rules = cfg.RoleRules
+	}
+
 	return []runtime.Object{
 		&rbacv1.Role{
 			TypeMeta: common.TypeMetaRole,
@@ -23,16 +40,7 @@ func role(ctx *common.RenderContext) ([]runtime.Object, error) {
 				Namespace: ctx.Namespace,
 				Labels:    labels,
 			},
-			Rules: []rbacv1.PolicyRule{
-				{
-					APIGroups: []string{""},
-					Resources: []string{"endpoints"},
-					Verbs: []string{
-						"get",
-						"watch",
-					},
-				},
-			},
+			Rules: rules,
 		},
 	}, nil
 }
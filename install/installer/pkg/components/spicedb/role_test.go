@@ -0,0 +1,54 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
+)
+
+func TestRole_MatchesRoleBindingRoleRef(t *testing.T) {
+	ctx := renderContextWithSpiceDBEnabled(t)
+
+	roles, err := role(ctx)
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+	r := roles[0].(*rbacv1.Role)
+
+	bindings, err := rolebinding(ctx)
+	require.NoError(t, err)
+	binding := bindings[0].(*rbacv1.RoleBinding)
+
+	require.Equal(t, "Role", binding.RoleRef.Kind)
+	require.Equal(t, r.Name, binding.RoleRef.Name)
+	require.Equal(t, r.Namespace, binding.Namespace)
+
+	require.Len(t, r.Rules, 1)
+	require.Contains(t, r.Rules[0].Resources, "services")
+	require.Contains(t, r.Rules[0].Verbs, "watch")
+}
+
+func TestRole_CustomRules(t *testing.T) {
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"endpoints"},
+			Verbs:     []string{"get", "watch"},
+		},
+	}
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		RoleRules: rules,
+	})
+
+	roles, err := role(ctx)
+	require.NoError(t, err)
+	require.Equal(t, rules, roles[0].(*rbacv1.Role).Rules)
+}
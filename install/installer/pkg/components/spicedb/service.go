@@ -5,26 +5,67 @@
 package spicedb
 
 import (
+	"github.com/gitpod-io/gitpod/common-go/baseserver"
 	"github.com/gitpod-io/gitpod/installer/pkg/common"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
 func service(ctx *common.RenderContext) ([]runtime.Object, error) {
-	return common.GenerateService(Component, []common.ServicePort{
+	cfg := getExperimentalSpiceDBConfig(ctx)
+	if cfg == nil {
+		return nil, nil
+	}
+
+	ports := []common.ServicePort{
 		{
 			Name:          ContainerGRPCName,
 			ContainerPort: ContainerGRPCPort,
 			ServicePort:   ContainerGRPCPort,
 		},
-		{
-			Name:          ContainerHTTPName,
-			ContainerPort: ContainerHTTPPort,
-			ServicePort:   ContainerHTTPPort,
-		},
 		{
 			Name:          ContainerDispatchName,
 			ContainerPort: ContainerDispatchPort,
 			ServicePort:   ContainerDispatchPort,
 		},
+	}
+
+	if cfg.HTTPEnabled {
+		ports = append(ports, common.ServicePort{
+			Name:          ContainerHTTPName,
+			ContainerPort: httpPort(cfg),
+			ServicePort:   httpPort(cfg),
+		})
+	}
+
+	services, err := common.GenerateService(Component, ports)(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MetricsService {
+		metrics, err := metricsService(ctx)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, metrics...)
+	}
+
+	return services, nil
+}
+
+// metricsService exposes only the metrics port served by kube-rbac-proxy, labelled so a ServiceMonitor can select it.
+func metricsService(ctx *common.RenderContext) ([]runtime.Object, error) {
+	ports := []common.ServicePort{
+		{
+			Name:          baseserver.BuiltinMetricsPortName,
+			ContainerPort: baseserver.BuiltinMetricsPort,
+			ServicePort:   baseserver.BuiltinMetricsPort,
+		},
+	}
+
+	return common.GenerateService(Component, ports, func(service *corev1.Service) {
+		service.Name = MetricsServiceName
+		service.Labels[MetricsServiceLabelKey] = "true"
 	})(ctx)
 }
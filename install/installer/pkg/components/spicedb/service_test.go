@@ -0,0 +1,72 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gitpod-io/gitpod/common-go/baseserver"
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
+)
+
+func TestService_HTTPPortAbsentByDefault(t *testing.T) {
+	ctx := renderContextWithSpiceDBEnabled(t)
+
+	objects, err := service(ctx)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+
+	svc := objects[0].(*corev1.Service)
+	for _, port := range svc.Spec.Ports {
+		require.NotEqual(t, ContainerHTTPName, port.Name)
+	}
+}
+
+func TestService_HTTPPortWhenEnabled(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:     true,
+		SecretRef:   "spicedb-secret",
+		HTTPEnabled: true,
+		HTTPPort:    9443,
+	})
+
+	objects, err := service(ctx)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+
+	svc := objects[0].(*corev1.Service)
+	var found bool
+	for _, port := range svc.Spec.Ports {
+		if port.Name == ContainerHTTPName {
+			found = true
+			require.EqualValues(t, 9443, port.Port)
+			require.EqualValues(t, 9443, port.TargetPort.IntVal)
+		}
+	}
+	require.True(t, found, "service must expose the http port")
+}
+
+func TestService_MetricsServiceWhenEnabled(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:        true,
+		SecretRef:      "spicedb-secret",
+		MetricsService: true,
+	})
+
+	objects, err := service(ctx)
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+
+	svc := objects[1].(*corev1.Service)
+	require.Equal(t, MetricsServiceName, svc.Name)
+	require.Equal(t, "true", svc.Labels[MetricsServiceLabelKey])
+	require.NotContains(t, objects[0].(*corev1.Service).Labels, MetricsServiceLabelKey)
+	require.Len(t, svc.Spec.Ports, 1)
+	require.Equal(t, baseserver.BuiltinMetricsPortName, svc.Spec.Ports[0].Name)
+	require.EqualValues(t, baseserver.BuiltinMetricsPort, svc.Spec.Ports[0].Port)
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"github.com/gitpod-io/gitpod/installer/pkg/common"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func serviceaccount(ctx *common.RenderContext) ([]runtime.Object, error) {
+	cfg := getExperimentalSpiceDBConfig(ctx)
+	if cfg == nil {
+		return nil, nil
+	}
+
+	objects, err := common.DefaultServiceAccount(Component)(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range objects {
+		sa, ok := o.(*corev1.ServiceAccount)
+		if !ok {
+			continue
+		}
+
+		for k, v := range cfg.ServiceAccountAnnotations {
+			sa.Annotations[k] = v
+		}
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
+)
+
+func TestServiceAccount_MatchesRoleBindingSubject(t *testing.T) {
+	ctx := renderContextWithSpiceDBEnabled(t)
+
+	objects, err := serviceaccount(ctx)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	sa := objects[0].(*corev1.ServiceAccount)
+	require.Equal(t, ctx.Namespace, sa.Namespace)
+
+	bindings, err := rolebinding(ctx)
+	require.NoError(t, err)
+
+	binding := bindings[0].(*rbacv1.RoleBinding)
+	require.Len(t, binding.Subjects, 1)
+	require.Equal(t, "ServiceAccount", binding.Subjects[0].Kind)
+	require.Equal(t, sa.Name, binding.Subjects[0].Name)
+}
+
+func TestServiceAccount_Annotations(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		ServiceAccountAnnotations: map[string]string{
+			"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789012:role/spicedb",
+		},
+	})
+
+	objects, err := serviceaccount(ctx)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+
+	sa := objects[0].(*corev1.ServiceAccount)
+	require.Equal(t, "arn:aws:iam::123456789012:role/spicedb", sa.Annotations["eks.amazonaws.com/role-arn"])
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gitpod-io/gitpod/installer/pkg/common"
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
+)
+
+// tracingArgs returns the flags exporting SpiceDB spans through OpenTelemetry, when tracing is enabled for the installation.
+func tracingArgs(ctx *common.RenderContext, cfg *experimental.SpiceDBConfig) []string {
+	tracing := ctx.Config.Observability.Tracing
+	if tracing == nil {
+		return nil
+	}
+
+	endpoint := ""
+	if tracing.Endpoint != nil {
+		endpoint = *tracing.Endpoint
+	}
+	if cfg.Tracing != nil && cfg.Tracing.Endpoint != "" {
+		endpoint = cfg.Tracing.Endpoint
+	}
+	if endpoint == "" {
+		return nil
+	}
+
+	args := []string{
+		"--otel-provider=otlpgrpc",
+		fmt.Sprintf("--otel-endpoint=%s", endpoint),
+		fmt.Sprintf("--otel-service-name=%s", Component),
+	}
+
+	var sampleRatio *float64
+	_ = ctx.WithExperimental(func(ucfg *experimental.Config) error {
+		if ucfg.WebApp != nil && ucfg.WebApp.Tracing != nil {
+			sampleRatio = ucfg.WebApp.Tracing.SamplerParam
+		}
+		return nil
+	})
+	if cfg.Tracing != nil && cfg.Tracing.SampleRatio != nil {
+		sampleRatio = cfg.Tracing.SampleRatio
+	}
+	if sampleRatio != nil {
+		args = append(args, fmt.Sprintf("--otel-sample-ratio=%s", strconv.FormatFloat(*sampleRatio, 'f', -1, 64)))
+	}
+
+	if cfg.Tracing != nil && cfg.Tracing.Insecure {
+		args = append(args, "--otel-insecure=true")
+	}
+
+	return args
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License.AGPL.txt in the project root for license information.
+
+package spicedb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/pointer"
+
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1"
+	"github.com/gitpod-io/gitpod/installer/pkg/config/v1/experimental"
+)
+
+func TestTracing_NotRenderedWhenTracingDisabled(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Tracing: &experimental.SpiceDBTracingConfig{
+			Endpoint: "otel-collector:4317",
+		},
+	})
+
+	container := spicedbContainer(t, ctx)
+	for _, arg := range container.Args {
+		require.False(t, strings.HasPrefix(arg, "--otel-"), "unexpected arg %s", arg)
+	}
+}
+
+func TestTracing_RenderedWhenTracingEnabled(t *testing.T) {
+	ctx := renderContextWithSpiceDBConfig(t, &experimental.SpiceDBConfig{
+		Enabled:   true,
+		SecretRef: "spicedb-secret",
+		Tracing: &experimental.SpiceDBTracingConfig{
+			Endpoint:    "otel-collector:4317",
+			SampleRatio: pointer.Float64(0.1),
+			Insecure:    true,
+		},
+	})
+	ctx.Config.Observability.Tracing = &config.Tracing{
+		Endpoint: pointer.String("http://jaeger:14268/api/traces"),
+	}
+
+	container := spicedbContainer(t, ctx)
+	require.Contains(t, container.Args, "--otel-provider=otlpgrpc")
+	require.Contains(t, container.Args, "--otel-endpoint=otel-collector:4317")
+	require.Contains(t, container.Args, "--otel-sample-ratio=0.1")
+	require.Contains(t, container.Args, "--otel-insecure=true")
+}
+
+func TestTracing_DefaultsToInstallationEndpoint(t *testing.T) {
+	ctx := renderContextWithSpiceDBEnabled(t)
+	ctx.Config.Observability.Tracing = &config.Tracing{
+		Endpoint: pointer.String("otel-collector:4317"),
+	}
+
+	container := spicedbContainer(t, ctx)
+	require.Contains(t, container.Args, "--otel-endpoint=otel-collector:4317")
+	require.NotContains(t, container.Args, "--otel-insecure=true")
+}
@@ -15,9 +15,11 @@ import (
 
 	agentSmith "github.com/gitpod-io/gitpod/agent-smith/pkg/config"
 	"github.com/gitpod-io/gitpod/common-go/grpc"
+	"github.com/gitpod-io/gitpod/common-go/util"
 	db "github.com/gitpod-io/gitpod/components/gitpod-db/go"
 	"github.com/gitpod-io/gitpod/ws-daemon/pkg/cpulimit"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -198,11 +200,399 @@ type IAMConfig struct {
 type SpiceDBConfig struct {
 	Enabled bool `json:"enabled"`
 
+	// DisableMigrations omits the migration Job, for operators who migrate the datastore themselves, e.g. in a change
+	// window. The datastore must then be migrated before SpiceDB is rolled out. Migrations run by default.
 	DisableMigrations bool `json:"disableMigrations"`
 
 	// Reference to a k8s secret which contains a "presharedKey" for authentication with SpiceDB
 	// Required.
 	SecretRef string `json:"secretRef"`
+
+	// PresharedKeys lists the keys of the secret referenced by SecretRef, which hold preshared keys SpiceDB accepts.
+	// Listing several keys allows clients to be rotated to a new key gradually. Gitpod components authenticate with the
+	// first key. Defaults to the single "presharedKey".
+	PresharedKeys []string `json:"presharedKeys,omitempty"`
+
+	// Mode is either "embedded" (default), which runs SpiceDB as part of the installation, or "external", which
+	// only configures other components to connect to an externally managed SpiceDB cluster.
+	Mode SpiceDBMode `json:"mode,omitempty" validate:"omitempty,oneof=embedded external"`
+
+	// External configures the connection to SpiceDB in external mode.
+	External *SpiceDBExternalConfig `json:"external,omitempty"`
+
+	// MinTLSVersion is the minimum TLS version of connections to SpiceDB served with TLS, which are those to an external
+	// SpiceDB with external.tls. The embedded gRPC API is served in plaintext within the cluster. Defaults to 1.2.
+	MinTLSVersion string `json:"minTLSVersion,omitempty" validate:"omitempty,oneof=1.2 1.3"`
+
+	// HTTPEnabled exposes the SpiceDB HTTP gateway next to the gRPC API. Disabled by default.
+	HTTPEnabled bool `json:"httpEnabled"`
+
+	// HTTPPort is the port the HTTP gateway listens on. Defaults to 8443.
+	HTTPPort int `json:"httpPort,omitempty"`
+
+	// WatchEnabled declares that clients stream relationship changes through the Watch API, which SpiceDB always serves.
+	// It shortens the datastore revision quantization so reads keep up with the stream, and requires a datastore engine
+	// which supports watching for changes.
+	WatchEnabled bool `json:"watchEnabled"`
+
+	// DisableV0API turns off the deprecated v0 API. Defaults to true, set it to false for clients which still need it.
+	DisableV0API *bool `json:"disableV0API,omitempty"`
+
+	// EnableSchemaReflection enables the experimental schema reflection API, which lets tooling introspect the schema.
+	// The API is experimental and may change between SpiceDB releases, it is not served by the pinned SpiceDB image,
+	// so enabling it is rejected until the image is bumped. Disabled by default.
+	// It is unrelated to gRPC server reflection, which SpiceDB always serves and provides no flag to disable: hardened
+	// environments need to block the grpc.reflection services in front of SpiceDB.
+	EnableSchemaReflection bool `json:"enableSchemaReflection"`
+
+	// PodAnnotations are added to the pods of the Deployment, DeploymentAnnotations to the Deployment itself.
+	// Keys with the gitpod.io/ prefix are reserved for annotations set by Gitpod, and are rejected.
+	PodAnnotations        map[string]string `json:"podAnnotations,omitempty"`
+	DeploymentAnnotations map[string]string `json:"deploymentAnnotations,omitempty"`
+
+	// ServiceAccountAnnotations are added to the ServiceAccount, e.g. to bind it to a cloud IAM role.
+	ServiceAccountAnnotations map[string]string `json:"serviceAccountAnnotations,omitempty"`
+
+	// RoleRules replace the default rules of the namespaced Role bound to the ServiceAccount.
+	RoleRules []rbacv1.PolicyRule `json:"roleRules,omitempty"`
+
+	// DetailedDispatchMetrics enables per-method dispatch and dispatch cache metrics.
+	DetailedDispatchMetrics bool `json:"detailedDispatchMetrics"`
+
+	// RequireSchemaVersion holds pods back from becoming ready until SpiceDB serves a schema declaring this version,
+	// through a `gitpod-schema-version: <version>` doc comment. This prevents serving traffic against an old schema.
+	RequireSchemaVersion string `json:"requireSchemaVersion,omitempty"`
+
+	// CABundleSecretRef references a k8s secret with a "ca.crt" key, holding the CA certificates SpiceDB trusts for all
+	// outgoing TLS connections, including the datastore and OpenTelemetry export. The bundle replaces the default system
+	// CA file, so it must also contain any public roots which are still needed.
+	CABundleSecretRef string `json:"caBundleSecretRef,omitempty"`
+
+	// Tracing configures the export of SpiceDB spans through OpenTelemetry. Spans are only exported when tracing
+	// is enabled for the installation through observability.tracing.
+	Tracing *SpiceDBTracingConfig `json:"tracing,omitempty"`
+
+	// MetricsService renders an additional Service exposing only the metrics port, so scrapers can target it separately.
+	// The Service carries the gitpod.io/metrics-service=true label to select it by. The installer renders no
+	// ServiceMonitor: it is managed together with the Prometheus operator, which decides on the labels it requires.
+	MetricsService bool `json:"metricsService"`
+
+	// AllowAnonymousMetrics serves the metrics endpoint without authentication, for scrapers which cannot present a
+	// service account token, e.g. inside a service mesh which already restricts access. By default kube-rbac-proxy,
+	// which serves the metrics of SpiceDB, requires an authenticated and authorized scraper.
+	AllowAnonymousMetrics bool `json:"allowAnonymousMetrics"`
+
+	// ReadOnly puts SpiceDB into read-only mode, e.g. to protect the datastore during an incident. Permission checks
+	// continue to be served, while writes are rejected with an error. Schema bootstrapping is skipped while enabled.
+	ReadOnly bool `json:"readOnly"`
+
+	// SchemaReadOnly rejects schema changes through the API, e.g. WriteSchema calls, while permission checks and
+	// relationship writes continue. The installer keeps bootstrapping the schema. SpiceDB has no switch for schema writes
+	// alone, the whole v1 schema API is disabled, which also rejects ReadSchema and conflicts with requireSchemaVersion.
+	SchemaReadOnly bool `json:"schemaReadOnly"`
+
+	// DispatchDrainPeriod is how long a terminating pod keeps serving in clustered mode, with more than one replica,
+	// so other replicas stop dispatching to it before in-flight requests are cancelled. Defaults to 5s.
+	DispatchDrainPeriod *util.Duration `json:"dispatchDrainPeriod,omitempty"`
+
+	// ReadinessInitialDelay is how long the readiness probe waits after the start of SpiceDB. Defaults to 1s for the
+	// memory engine, which is ready immediately, and to 10s for the other engines, which connect to the datastore first.
+	ReadinessInitialDelay *util.Duration `json:"readinessInitialDelay,omitempty"`
+
+	// DispatchMembershipProbe restarts pods, whose dispatch server stops serving the dispatches of the other pods of the
+	// cluster. Only the local dispatch server is probed, such that an outage making all pods unready does not restart
+	// them all. Only applies in clustered mode, with more than one replica.
+	DispatchMembershipProbe bool `json:"dispatchMembershipProbe"`
+
+	// DispatchConcurrencyLimit caps the number of concurrent dispatches per request. Defaults to the SpiceDB default.
+	DispatchConcurrencyLimit *int `json:"dispatchConcurrencyLimit,omitempty" validate:"omitempty,min=1,max=65535"`
+
+	// SecondaryDispatch sends selected dispatches to secondary upstreams, e.g. SpiceDB clusters in other regions of a
+	// geo-distributed installation, which serve them from their caches. It requires clustered mode, with more than one
+	// replica. It is not supported by the pinned SpiceDB image, enabling it is rejected until the image is bumped.
+	// Disabled by default.
+	SecondaryDispatch *SpiceDBSecondaryDispatchConfig `json:"secondaryDispatch,omitempty"`
+
+	// MaxDepth is the maximum depth of the relationship graph a permission check traverses. Defaults to 50,
+	// deeply nested organization hierarchies may need a higher value.
+	MaxDepth *int `json:"maxDepth,omitempty" validate:"omitempty,min=1,max=500"`
+
+	// GRPCMaxMessageSize is the maximum size in bytes of gRPC messages SpiceDB and its clients send and receive.
+	// Defaults to the SpiceDB default of 4MiB.
+	GRPCMaxMessageSize *int `json:"grpcMaxMessageSize,omitempty" validate:"omitempty,min=1"`
+
+	// GRPCMaxConcurrentStreams is the maximum number of concurrent streams per gRPC connection to SpiceDB, which may need to
+	// be raised for many long-lived Watch streams. Defaults to the SpiceDB default.
+	GRPCMaxConcurrentStreams *int `json:"grpcMaxConcurrentStreams,omitempty" validate:"omitempty,min=1"`
+
+	// GRPCKeepalive configures the keepalive pings Gitpod components send on their connections to SpiceDB, which keep
+	// long-lived Watch streams open through proxies dropping idle connections. SpiceDB provides no flags for keepalive,
+	// its server keeps the gRPC defaults: pings more frequent than every 5m, or without an open stream, are answered by
+	// closing the connection. The embedded SpiceDB therefore rejects a shorter time, and permitWithoutStream.
+	// Keepalive pings are disabled by default.
+	GRPCKeepalive *SpiceDBGRPCKeepaliveConfig `json:"grpcKeepalive,omitempty"`
+
+	// Ingress exposes the SpiceDB APIs outside of the cluster. Disabled by default.
+	Ingress *SpiceDBIngressConfig `json:"ingress,omitempty"`
+
+	// Relationships enables experimental relationship features. They are not supported by the pinned SpiceDB image,
+	// enabling any of them is rejected until the image is bumped to a release which supports them.
+	Relationships *SpiceDBRelationshipsConfig `json:"relationships,omitempty"`
+
+	// HealthProbe configures an image providing the grpc_health_probe binary of the probes, which is copied into the
+	// SpiceDB pod by an init container. When unset, the probes run the binary shipped with the SpiceDB image.
+	HealthProbe *SpiceDBHealthProbeConfig `json:"healthProbe,omitempty"`
+
+	// Migration configures the Job which migrates the datastore.
+	Migration *SpiceDBMigrationConfig `json:"migration,omitempty"`
+
+	// ExtraArgs are appended to the args of the SpiceDB server, to pass flags the installer does not support yet.
+	// Flags set by the installer must not be set again, and are rejected.
+	ExtraArgs []string `json:"extraArgs,omitempty"`
+
+	// Datastore configures where SpiceDB persists relationships. Results are cached in the memory of each replica, and
+	// shared between replicas through dispatching: SpiceDB has no external cache, such as Redis, in front of the datastore.
+	Datastore *SpiceDBDatastoreConfig `json:"datastore,omitempty"`
+}
+
+type SpiceDBIngressConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// ClassName is the IngressClass of the Ingresses. Defaults to the cluster default class.
+	ClassName string `json:"className,omitempty"`
+
+	// TLSSecretRef references the k8s TLS secret served for the hosts. Defaults to the certificate of the installation.
+	TLSSecretRef string `json:"tlsSecretRef,omitempty"`
+
+	// HTTP exposes the HTTP gateway, which requires httpEnabled.
+	HTTP *SpiceDBIngressRule `json:"http,omitempty"`
+
+	// GRPC exposes the gRPC API. Ingress controllers usually need an annotation to proxy gRPC to the backend.
+	GRPC *SpiceDBIngressRule `json:"grpc,omitempty"`
+}
+
+type SpiceDBIngressRule struct {
+	// Host the API is served on.
+	Host string `json:"host" validate:"required,hostname"`
+
+	// Annotations are added to the Ingress, e.g. to configure the ingress controller.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type SpiceDBRelationshipsConfig struct {
+	// ExpirationEnabled allows writing relationships which expire at a given time. Disabled by default.
+	// Expired relationships are deleted by the garbage collection of old revisions, tuned through datastore.gcWindow
+	// and datastore.gcInterval: SpiceDB runs no separate garbage collection for expired or caveated relationships.
+	ExpirationEnabled bool `json:"expirationEnabled"`
+
+	// Integrity signs relationships on write and verifies them on read. Disabled by default.
+	Integrity *SpiceDBRelationshipIntegrityConfig `json:"integrity,omitempty"`
+}
+
+type SpiceDBRelationshipIntegrityConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// KeySecretRef references a k8s secret with an "integrityKey" key, holding the key relationships are signed with.
+	// Required when enabled.
+	KeySecretRef string `json:"keySecretRef,omitempty"`
+
+	// KeyID identifies the key, and has to change whenever the key is rotated. Required when enabled.
+	KeyID string `json:"keyId,omitempty"`
+}
+
+type SpiceDBHealthProbeConfig struct {
+	// Image is the full reference of an image containing a shell and the grpc_health_probe binary.
+	// Defaults to the debug variant of the SpiceDB image.
+	Image string `json:"image,omitempty"`
+
+	// BinaryPath is the path of the grpc_health_probe binary within the image. Defaults to /usr/local/bin/grpc_health_probe.
+	BinaryPath string `json:"binaryPath,omitempty" validate:"omitempty,startswith=/"`
+}
+
+type SpiceDBMigrationConfig struct {
+	// Resources of the migration container, independent of the resources of the SpiceDB server.
+	// Defaults to modest requests, which may need to be raised for large datastores.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// BackoffLimit is the number of retries before the Job is marked failed. Defaults to 6.
+	BackoffLimit *int32 `json:"backoffLimit,omitempty" validate:"omitempty,min=0"`
+
+	// ActiveDeadlineSeconds bounds the total runtime of the Job, including retries. Defaults to 6 hours.
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty" validate:"omitempty,min=1"`
+
+	// Timeout bounds a single migration run, and must be shorter than ActiveDeadlineSeconds. Defaults to 4h.
+	Timeout *util.Duration `json:"timeout,omitempty"`
+
+	// ExtraArgs are appended to the args of the migration, like spicedb.extraArgs to those of the server.
+	ExtraArgs []string `json:"extraArgs,omitempty"`
+}
+
+type SpiceDBMode string
+
+const (
+	SpiceDBModeEmbedded SpiceDBMode = "embedded"
+	SpiceDBModeExternal SpiceDBMode = "external"
+)
+
+type SpiceDBExternalConfig struct {
+	// Address of the external SpiceDB gRPC API, as host:port.
+	Address string `json:"address"`
+
+	// TLS enables TLS for connections to the external SpiceDB.
+	TLS bool `json:"tls"`
+}
+
+type SpiceDBTracingConfig struct {
+	// Endpoint is the host:port of the OTLP gRPC collector. Defaults to observability.tracing.endpoint.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// SampleRatio is the ratio of traces which are sampled. Defaults to the webapp tracing samplerParam.
+	SampleRatio *float64 `json:"sampleRatio,omitempty" validate:"omitempty,gte=0,lte=1"`
+
+	// Insecure disables TLS for the connection to the collector.
+	Insecure bool `json:"insecure"`
+}
+
+type SpiceDBDatastoreEngine string
+
+const (
+	SpiceDBDatastoreEngineMySQL       SpiceDBDatastoreEngine = "mysql"
+	SpiceDBDatastoreEnginePostgres    SpiceDBDatastoreEngine = "postgres"
+	SpiceDBDatastoreEngineCockroachDB SpiceDBDatastoreEngine = "cockroachdb"
+	SpiceDBDatastoreEngineMemory      SpiceDBDatastoreEngine = "memory"
+)
+
+type SpiceDBDatastoreConfig struct {
+	// Engine is the datastore SpiceDB persists relationships in. Defaults to mysql.
+	// The memory engine keeps all relationships in process memory, which are lost on restart. It does not write to
+	// disk, so mounting a volume would not persist it either: use the postgres or mysql engine to persist dev setups.
+	Engine SpiceDBDatastoreEngine `json:"engine,omitempty" validate:"omitempty,spicedb_datastore_engine"`
+
+	// Host of the datastore. Defaults to the host of the Gitpod database. It is used verbatim, a datastore in another
+	// namespace is referenced by its fully-qualified service name, e.g. mysql.database.svc.cluster.local.
+	Host string `json:"host,omitempty"`
+
+	// Port of the datastore. Defaults to the port of the Gitpod database.
+	Port int `json:"port,omitempty"`
+
+	// PasswordSecretRef names an existing Secret, whose "password" key the server and the migration Job authenticate to
+	// the datastore with, instead of the password of the Gitpod database. The user still is the Gitpod database user,
+	// the password must be the one of that user in the datastore. Takes precedence over GeneratePassword.
+	PasswordSecretRef string `json:"passwordSecretRef,omitempty"`
+
+	// GeneratePassword renders a Secret with a generated password, used like the one of PasswordSecretRef, when no
+	// PasswordSecretRef is configured. The installer does not provision the database user: the password must be set for
+	// the Gitpod database user in the datastore. The installer renders without access to the cluster, the password is
+	// generated anew on every render, unless it is already present in the generated values of the render context.
+	// Configure PasswordSecretRef for a password which is stable across re-renders.
+	GeneratePassword bool `json:"generatePassword"`
+
+	// WaitForDatastore renders an init container which blocks until the datastore accepts TCP connections.
+	// Useful when the datastore is brought up together with SpiceDB, not needed for always-on external datastores.
+	WaitForDatastore bool `json:"waitForDatastore"`
+
+	// StartupRetry bounds the wait for the datastore on startup. It renders the same init container as
+	// WaitForDatastore, which gives up after the configured attempts: the pod then fails to start, and is restarted by
+	// kubelet with backoff, instead of blocking for good on a datastore which never comes up.
+	StartupRetry *SpiceDBStartupRetryConfig `json:"startupRetry,omitempty"`
+
+	// MaxOpenConns caps the number of open connections to the datastore, per replica. Defaults to 100.
+	MaxOpenConns *int `json:"maxOpenConns,omitempty" validate:"omitempty,min=1"`
+
+	// MinOpenConns is the number of connections kept open to the datastore, per replica. Defaults to 10.
+	MinOpenConns *int `json:"minOpenConns,omitempty" validate:"omitempty,min=0"`
+
+	// ConnMaxLifetime is the maximum time a datastore connection is reused for. Defaults to 30m.
+	ConnMaxLifetime *util.Duration `json:"connMaxLifetime,omitempty"`
+
+	// ConnMaxIdleTime is the maximum time a datastore connection is kept open while idle. Defaults to 5m, keep it
+	// below the idle timeout of the database and any proxy in between, which otherwise close connections still pooled.
+	ConnMaxIdleTime *util.Duration `json:"connMaxIdleTime,omitempty"`
+
+	// ReadReplicaConns are the host:port addresses of read replicas of the datastore, which serve permission checks.
+	// They are accessed with the credentials of the primary. Only supported by the mysql and postgres engines.
+	ReadReplicaConns []string `json:"readReplicaConns,omitempty" validate:"omitempty,dive,hostname_port"`
+
+	// GCWindow is how long old revisions are kept before they are garbage collected. Defaults to 24h.
+	// GC settings are only supported by the mysql and postgres engines.
+	GCWindow *util.Duration `json:"gcWindow,omitempty"`
+
+	// GCInterval is the time between garbage collection runs. Defaults to 3m.
+	GCInterval *util.Duration `json:"gcInterval,omitempty"`
+
+	// GCMaxOperationTime bounds the duration of a single garbage collection run. Defaults to 1m.
+	GCMaxOperationTime *util.Duration `json:"gcMaxOperationTime,omitempty"`
+
+	// Hedging configures request hedging, which issues a second datastore request when the first one is slow.
+	// Disabled by default.
+	Hedging *SpiceDBHedgingConfig `json:"hedging,omitempty"`
+
+	// CockroachDB holds settings which only apply to the cockroachdb engine.
+	CockroachDB *SpiceDBCockroachDBConfig `json:"cockroachdb,omitempty"`
+}
+
+type SpiceDBStartupRetryConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// MaxAttempts is the number of connection attempts before giving up. Defaults to 30.
+	MaxAttempts *int `json:"maxAttempts,omitempty" validate:"omitempty,min=1"`
+
+	// Interval is the time between connection attempts. Defaults to 2s.
+	Interval *util.Duration `json:"interval,omitempty"`
+}
+
+type SpiceDBGRPCKeepaliveConfig struct {
+	// Time is the interval of keepalive pings on an idle connection.
+	Time *util.Duration `json:"time,omitempty"`
+
+	// Timeout is how long a keepalive ping waits for its acknowledgement before the connection is closed. Defaults to 20s.
+	Timeout *util.Duration `json:"timeout,omitempty"`
+
+	// PermitWithoutStream also sends keepalive pings while the connection has no open stream.
+	PermitWithoutStream bool `json:"permitWithoutStream"`
+}
+
+type SpiceDBSecondaryDispatchConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Upstreams are the secondary upstreams dispatches may be sent to. Required when enabled.
+	Upstreams []SpiceDBSecondaryDispatchUpstream `json:"upstreams,omitempty" validate:"omitempty,dive"`
+
+	// Exprs map dispatch methods, e.g. check, to CEL expressions returning the names of the upstreams to send the
+	// dispatch to, e.g. "['us-east']". Methods without an expression are only dispatched within the cluster.
+	Exprs map[string]string `json:"exprs,omitempty"`
+}
+
+type SpiceDBSecondaryDispatchUpstream struct {
+	// Name identifies the upstream in Exprs.
+	Name string `json:"name" validate:"required"`
+
+	// Address is the host:port of the dispatch API of the upstream.
+	Address string `json:"address" validate:"required,hostname_port"`
+}
+
+type SpiceDBHedgingConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// InitialSlowValue is the duration after which a request is considered slow, until enough requests were observed.
+	InitialSlowValue *util.Duration `json:"initialSlowValue,omitempty"`
+
+	// MaxRequests is the number of requests tracked to compute the slow request threshold.
+	MaxRequests *int `json:"maxRequests,omitempty" validate:"omitempty,min=1"`
+
+	// Quantile of tracked request durations above which a request is hedged, e.g. 0.95.
+	Quantile *float64 `json:"quantile,omitempty" validate:"omitempty,gt=0,lt=1"`
+}
+
+type SpiceDBCockroachDBConfig struct {
+	// MaxTxRetries is the number of times a transaction is retried on contention.
+	MaxTxRetries *int `json:"maxTxRetries,omitempty" validate:"omitempty,min=0"`
+
+	// OverlapStrategy controls how transactions overlapping in time are ordered. One of static, prefix, request, insecure.
+	OverlapStrategy string `json:"overlapStrategy,omitempty" validate:"omitempty,oneof=static prefix request insecure"`
+
+	// OverlapKey is the key used by the static overlap strategy.
+	OverlapKey string `json:"overlapKey,omitempty"`
 }
 
 type WebAppConfig struct {
@@ -25,6 +25,13 @@ var ServiceTypeList = map[corev1.ServiceType]struct{}{
 	corev1.ServiceTypeExternalName: {},
 }
 
+var SpiceDBDatastoreEngineList = map[SpiceDBDatastoreEngine]struct{}{
+	SpiceDBDatastoreEngineMySQL:       {},
+	SpiceDBDatastoreEnginePostgres:    {},
+	SpiceDBDatastoreEngineCockroachDB: {},
+	SpiceDBDatastoreEngineMemory:      {},
+}
+
 var ValidationChecks = map[string]validator.Func{
 	"tracing_sampler_type": func(fl validator.FieldLevel) bool {
 		_, ok := TracingSampleTypeList[TracingSampleType(fl.Field().String())]
@@ -34,6 +41,10 @@ var ValidationChecks = map[string]validator.Func{
 		_, ok := ServiceTypeList[corev1.ServiceType(fl.Field().String())]
 		return ok
 	},
+	"spicedb_datastore_engine": func(fl validator.FieldLevel) bool {
+		_, ok := SpiceDBDatastoreEngineList[SpiceDBDatastoreEngine(fl.Field().String())]
+		return ok
+	},
 }
 
 func ClusterValidation(cfg *Config) cluster.ValidationChecks {